@@ -0,0 +1,370 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Command nfsbench measures the I/O performance of an NFS export through this
+// library, to help choose block sizes and the pipelining and read-ahead
+// settings of files.
+//
+//	nfsbench [flags] <host>:<export>
+//
+// Each of the -jobs workers reads or writes its own file of -size bytes in the
+// -dir directory, in blocks of -bs bytes, for -runtime.  The files are laid
+// out before reads are measured and removed at the end, unless -keep is given.
+// The IOPS, the throughput and the latency percentiles of the calls are
+// reported once all the workers are done.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	_path "path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/rpc"
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+// workload is what the workers do
+type workload struct {
+	write, random bool
+}
+
+var workloads = map[string]workload{
+	"read":      {},
+	"write":     {write: true},
+	"randread":  {random: true},
+	"randwrite": {write: true, random: true},
+}
+
+// result is what a worker measured
+type result struct {
+	bytes     int64
+	latencies []time.Duration
+	err       error
+}
+
+func main() {
+	var (
+		uid       = flag.Uint("uid", uint(os.Getuid()), "uid to send to the server")
+		gid       = flag.Uint("gid", uint(os.Getgid()), "gid to send to the server")
+		priv      = flag.Bool("priv", false, "connect from a privileged port")
+		debug     = flag.Bool("debug", false, "log the NFS calls")
+		rw        = flag.String("rw", "read", "workload: read, write, randread or randwrite")
+		bs        = flag.String("bs", "128k", "block size of the calls")
+		size      = flag.String("size", "64m", "size of the file of each job")
+		jobs      = flag.Int("jobs", 1, "number of concurrent jobs")
+		runtime   = flag.Duration("runtime", 10*time.Second, "duration of the measurement")
+		dir       = flag.String("dir", ".", "directory holding the files on the export")
+		pipelined = flag.Bool("pipelined", false, "pipeline the writes of sequential workloads")
+		readAhead = flag.Int("readahead", 0, "READs kept in flight by sequential reads")
+		keep      = flag.Bool("keep", false, "keep the files once done")
+		nconnect  = flag.Int("nconnect", 1, "number of connections to the NFS service")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <host>:<export>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// an IPv6 host is bracketed, as in [::1]:/export
+	sep := ":"
+	if strings.HasPrefix(flag.Arg(0), "[") {
+		sep = "]:"
+	}
+	host, export, ok := strings.Cut(strings.TrimPrefix(flag.Arg(0), "["), sep)
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	wl, ok := workloads[*rw]
+	if !ok {
+		log.Fatalf("unknown workload %q", *rw)
+	}
+
+	blockSize, err := parseSize(*bs)
+	if err != nil || blockSize <= 0 {
+		log.Fatalf("invalid block size %q", *bs)
+	}
+
+	fileSize, err := parseSize(*size)
+	if err != nil || fileSize < blockSize {
+		log.Fatalf("invalid file size %q", *size)
+	}
+
+	if *jobs < 1 {
+		log.Fatalf("invalid number of jobs %d", *jobs)
+	}
+
+	util.DefaultLogger.SetDebug(*debug)
+
+	d := &nfs.Dialer{Priv: *priv, Nconnect: *nconnect}
+	mount, err := d.DialMount(context.Background(), host)
+	if err != nil {
+		log.Fatalf("unable to dial MOUNT service: %v", err)
+	}
+	defer mount.Close()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("hostname: %v", err)
+	}
+
+	auth := rpc.NewAuthUnix(hostname, uint32(*uid), uint32(*gid))
+
+	v, err := mount.Mount(export, auth.Auth())
+	if err != nil {
+		log.Fatalf("unable to mount %s: %v", export, err)
+	}
+	defer v.Close()
+
+	if info, err := v.FSInfo(); err == nil {
+		fmt.Printf("server: rtmax %d rtpref %d wtmax %d wtpref %d\n", info.RTMax, info.RTPref, info.WTMax, info.WTPref)
+	}
+
+	paths := make([]string, *jobs)
+	for i := range paths {
+		paths[i] = _path.Join(*dir, fmt.Sprintf("nfsbench.%d", i))
+	}
+
+	if !*keep {
+		defer func() {
+			for _, path := range paths {
+				if err := v.Remove(path); err != nil && !os.IsNotExist(err) {
+					util.Errorf("remove %s: %v", path, err)
+				}
+			}
+		}()
+	}
+
+	if !wl.write {
+		fmt.Printf("laying out %d files of %s\n", *jobs, *size)
+		for _, path := range paths {
+			if err = layout(v, path, fileSize); err != nil {
+				log.Fatalf("lay out %s: %v", path, err)
+			}
+		}
+	}
+
+	fmt.Printf("%s: %d jobs, bs %s, size %s, runtime %s, nconnect %d\n", *rw, *jobs, *bs, *size, *runtime, *nconnect)
+
+	results := make([]result, *jobs)
+	deadline := time.Now().Add(*runtime)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			j := &job{
+				v:         v,
+				path:      paths[i],
+				workload:  wl,
+				blockSize: blockSize,
+				fileSize:  fileSize,
+				pipelined: *pipelined,
+				readAhead: *readAhead,
+				rand:      rand.New(rand.NewSource(int64(i) + time.Now().UnixNano())),
+			}
+			results[i] = j.run(deadline)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var (
+		total     int64
+		latencies []time.Duration
+	)
+	for i, r := range results {
+		if r.err != nil {
+			log.Fatalf("job %d: %v", i, r.err)
+		}
+		total += r.bytes
+		latencies = append(latencies, r.latencies...)
+	}
+
+	report(os.Stdout, total, latencies, elapsed)
+}
+
+// job is the state of a worker
+type job struct {
+	v         *nfs.Target
+	path      string
+	workload  workload
+	blockSize int64
+	fileSize  int64
+	pipelined bool
+	readAhead int
+	rand      *rand.Rand
+}
+
+// run issues calls until the deadline, recording the latency of each
+func (j *job) run(deadline time.Time) result {
+	oflag := os.O_RDONLY
+	if j.workload.write {
+		oflag = os.O_RDWR | os.O_CREATE
+	}
+
+	f, err := j.v.OpenFile(j.path, oflag, 0o644)
+	if err != nil {
+		return result{err: err}
+	}
+
+	if j.workload.write && j.pipelined && !j.workload.random {
+		if err = f.SetPipelined(true); err != nil {
+			f.Close()
+			return result{err: err}
+		}
+	}
+	if !j.workload.write && j.readAhead > 0 && !j.workload.random {
+		f.SetReadAhead(j.readAhead)
+	}
+
+	var (
+		r   result
+		off int64
+	)
+	buf := make([]byte, j.blockSize)
+	j.rand.Read(buf)
+	blocks := j.fileSize / j.blockSize
+
+	for time.Now().Before(deadline) {
+		if j.workload.random {
+			off = j.rand.Int63n(blocks) * j.blockSize
+		} else if off+j.blockSize > j.fileSize {
+			// wrap around to the start of the file
+			off = 0
+			if _, err = f.Seek(0, io.SeekStart); err != nil {
+				break
+			}
+		}
+
+		var n int
+		t := time.Now()
+		switch {
+		case j.workload.write && j.workload.random:
+			n, err = f.WriteAt(buf, off)
+		case j.workload.write:
+			n, err = f.Write(buf)
+		case j.workload.random:
+			n, err = f.ReadAt(buf, off)
+		default:
+			n, err = io.ReadFull(f, buf)
+		}
+		r.latencies = append(r.latencies, time.Since(t))
+
+		if err != nil {
+			break
+		}
+
+		r.bytes += int64(n)
+		off += int64(n)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	r.err = err
+
+	return r
+}
+
+// layout writes a file of size bytes for the read workloads
+func layout(v *nfs.Target, path string, size int64) error {
+	if info, err := v.Stat(path); err == nil && info.Size() >= size {
+		return nil
+	}
+
+	f, err := v.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err = f.SetPipelined(true); err != nil {
+		f.Close()
+		return err
+	}
+
+	if _, err = io.CopyN(f, rand.New(rand.NewSource(size)), size); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// report prints the rates of the run and the distribution of the latencies
+func report(w io.Writer, total int64, latencies []time.Duration, elapsed time.Duration) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	secs := elapsed.Seconds()
+	fmt.Fprintf(w, "ops %d in %s: %.0f IOPS, %.2f MiB/s\n",
+		len(latencies), elapsed.Round(time.Millisecond), float64(len(latencies))/secs, float64(total)/secs/(1<<20))
+
+	if len(latencies) == 0 {
+		return
+	}
+
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	fmt.Fprintf(w, "latency min %s avg %s max %s\n",
+		latencies[0], sum/time.Duration(len(latencies)), latencies[len(latencies)-1])
+
+	fmt.Fprintf(w, "percentiles")
+	for _, p := range []float64{50, 90, 95, 99, 99.9} {
+		fmt.Fprintf(w, " p%g %s", p, percentile(latencies, p))
+	}
+	fmt.Fprintln(w)
+}
+
+// percentile returns the p-th percentile of the sorted latencies, by the
+// nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+
+	return sorted[rank]
+}
+
+// parseSize parses a size in bytes, with an optional k, m or g binary suffix
+func parseSize(s string) (int64, error) {
+	shift := 0
+	switch strings.ToLower(s[len(s)-min(1, len(s)):]) {
+	case "k":
+		shift = 10
+	case "m":
+		shift = 20
+	case "g":
+		shift = 30
+	}
+	if shift != 0 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return n << shift, nil
+}
@@ -0,0 +1,533 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Command nfscli inspects and changes an NFS export from user space, without
+// mounting it.
+//
+//	nfscli [flags] <host>:<export> <command> [args]
+//
+// The commands are:
+//
+//	ls [-l] [path...]        list directories
+//	stat path...             print the attributes of files
+//	cat path...              print the contents of files
+//	get remote [local]       copy a file from the export
+//	put local [remote]       copy a file to the export
+//	rm [-r] path...          remove files, or trees with -r
+//	mkdir [-p] path...       create directories, and their parents with -p
+//	mv from to               rename a file
+//	df                       print the space and inodes of the file system
+//	fstest [path...]         check the export with testing/fstest, expecting
+//	                         the paths to exist
+//
+// Paths on the export are relative to its root.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	_path "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/nfstest"
+	"github.com/go-nfs/nfsv3/nfs/rpc"
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+// readAhead is the number of READs kept in flight for the files read
+const readAhead = 4
+
+// errUsage is returned by the commands given the wrong arguments
+var errUsage = errors.New("wrong arguments")
+
+// command is a subcommand, run with the arguments following its name
+type command struct {
+	usage string
+	run   func(v *nfs.Target, args []string) error
+}
+
+var commands = map[string]command{
+	"ls":     {"ls [-l] [path...]", ls},
+	"stat":   {"stat path...", stat},
+	"cat":    {"cat path...", cat},
+	"get":    {"get remote [local]", get},
+	"put":    {"put local [remote]", put},
+	"rm":     {"rm [-r] path...", rm},
+	"mkdir":  {"mkdir [-p] path...", mkdir},
+	"mv":     {"mv from to", mv},
+	"df":     {"df", df},
+	"fstest": {"fstest [path...]", fsTest},
+}
+
+func main() {
+	var (
+		uid   = flag.Uint("uid", uint(os.Getuid()), "uid to send to the server")
+		gid   = flag.Uint("gid", uint(os.Getgid()), "gid to send to the server")
+		priv  = flag.Bool("priv", false, "connect from a privileged port")
+		debug = flag.Bool("debug", false, "log the NFS calls")
+	)
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	// an IPv6 host is bracketed, as in [::1]:/export
+	sep := ":"
+	if strings.HasPrefix(flag.Arg(0), "[") {
+		sep = "]:"
+	}
+	host, export, ok := strings.Cut(strings.TrimPrefix(flag.Arg(0), "["), sep)
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(1)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "nfscli: unknown command %q\n", flag.Arg(1))
+		usage()
+		os.Exit(2)
+	}
+
+	util.DefaultLogger.SetDebug(*debug)
+
+	mount, err := nfs.DialMount(host, *priv)
+	if err != nil {
+		log.Fatalf("unable to dial MOUNT service: %v", err)
+	}
+	defer mount.Close()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("hostname: %v", err)
+	}
+
+	auth := rpc.NewAuthUnix(hostname, uint32(*uid), uint32(*gid))
+
+	v, err := mount.Mount(export, auth.Auth())
+	if err != nil {
+		log.Fatalf("unable to mount %s: %v", export, err)
+	}
+
+	err = cmd.run(v, flag.Args()[2:])
+	if cerr := v.Close(); err == nil {
+		err = cerr
+	}
+	if err == errUsage {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <host>:<export> %s\n", os.Args[0], cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nfscli %s: %v\n", flag.Arg(1), err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [flags] <host>:<export> <command> [args]\n\ncommands:\n", os.Args[0])
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n", commands[name].usage)
+	}
+
+	fmt.Fprintf(out, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+// parse parses the flags of a command, which takes at least min arguments,
+// leaving them in fs.Args
+func parse(fs *flag.FlagSet, args []string, min int) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if fs.NArg() < min {
+		return errUsage
+	}
+
+	return nil
+}
+
+func ls(v *nfs.Target, args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	long := fs.Bool("l", false, "long listing")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', tabwriter.AlignRight)
+	defer w.Flush()
+
+	for i, path := range paths {
+		info, err := v.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		// a file is listed by the path it was given, the entries of a
+		// directory by their names
+		infos, names := []os.FileInfo{info}, []string{path}
+		dir := info.IsDir()
+		if dir {
+			infos, names = infos[:0], names[:0]
+			for info, err := range v.Entries(path) {
+				if err != nil {
+					return err
+				}
+				infos = append(infos, info)
+			}
+			sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+			for _, info := range infos {
+				names = append(names, info.Name())
+			}
+
+			if len(paths) > 1 {
+				if i > 0 {
+					fmt.Fprintln(w)
+				}
+				fmt.Fprintf(w, "%s:\n", path)
+			}
+		}
+
+		for j, info := range infos {
+			name := names[j]
+			if !*long {
+				fmt.Fprintln(w, name)
+				continue
+			}
+
+			f := fattr(info)
+			line := fmt.Sprintf("%s\t %d\t %d\t %d\t %s\t %s",
+				info.Mode(), f.Nlink, f.UID, f.GID, humanSize(info.Size()), info.ModTime().Format(time.DateTime))
+			if info.Mode()&os.ModeSymlink != 0 {
+				link := path
+				if dir {
+					link = _path.Join(path, name)
+				}
+				if target, err := v.Readlink(link); err == nil {
+					name += " -> " + target
+				}
+			}
+			fmt.Fprintf(w, "%s\t %s\n", line, name)
+		}
+	}
+
+	return nil
+}
+
+// fattr returns the NFS attributes of a listed file
+func fattr(info os.FileInfo) *nfs.Fattr {
+	if fattr, ok := info.Sys().(*nfs.Fattr); ok {
+		return fattr
+	}
+
+	return &nfs.Fattr{}
+}
+
+func stat(v *nfs.Target, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	for _, path := range args {
+		info, err := v.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		f := fattr(info)
+		fmt.Printf("  File: %s\n", path)
+		fmt.Printf("  Size: %d\tBlocks used: %d\tType: %s\n", f.Filesize, f.Used, typeName(f.Type))
+		fmt.Printf("Fileid: %d\tLinks: %d\tFSID: %#x\n", f.Fileid, f.Nlink, f.FSID)
+		fmt.Printf("  Mode: %s (%04o)\tUid: %d\tGid: %d\n", info.Mode(), f.FileMode&0o7777, f.UID, f.GID)
+		if f.Type == nfs.NF3Chr || f.Type == nfs.NF3Blk {
+			fmt.Printf("Device: %d,%d\n", f.SpecData[0], f.SpecData[1])
+		}
+		fmt.Printf("Access: %s\n", nfsTime(f.Atime))
+		fmt.Printf("Modify: %s\n", nfsTime(f.Mtime))
+		fmt.Printf("Change: %s\n", nfsTime(f.Ctime))
+	}
+
+	return nil
+}
+
+func typeName(ftype uint32) string {
+	switch ftype {
+	case nfs.NF3Reg:
+		return "regular file"
+	case nfs.NF3Dir:
+		return "directory"
+	case nfs.NF3Blk:
+		return "block device"
+	case nfs.NF3Chr:
+		return "character device"
+	case nfs.NF3Lnk:
+		return "symbolic link"
+	case nfs.NF3Sock:
+		return "socket"
+	case nfs.NF3FIFO:
+		return "fifo"
+	}
+
+	return fmt.Sprintf("type %d", ftype)
+}
+
+func nfsTime(t nfs.NFS3Time) string {
+	return time.Unix(int64(t.Seconds), int64(t.Nseconds)).Format("2006-01-02 15:04:05.000000000 -0700")
+}
+
+func cat(v *nfs.Target, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	for _, path := range args {
+		f, err := v.Open(path)
+		if err != nil {
+			return err
+		}
+
+		f.SetReadAhead(readAhead)
+		_, err = io.Copy(os.Stdout, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func get(v *nfs.Target, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+
+	remote, local := args[0], _path.Base(args[0])
+	if len(args) == 2 {
+		local = args[1]
+	}
+
+	f, err := v.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if st, err := os.Stat(local); err == nil && st.IsDir() {
+		local = filepath.Join(local, _path.Base(remote))
+	}
+
+	out, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	f.SetReadAhead(readAhead)
+	if _, err = io.Copy(out, f); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(local, time.Time{}, info.ModTime())
+}
+
+func put(v *nfs.Target, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+
+	local, remote := args[0], filepath.Base(args[0])
+	if len(args) == 2 {
+		remote = args[1]
+	}
+
+	in, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info, err := v.Stat(remote); err == nil && info.IsDir() {
+		remote = _path.Join(remote, filepath.Base(local))
+	}
+
+	f, err := v.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if err = f.SetPipelined(true); err != nil {
+		f.Close()
+		return err
+	}
+
+	if _, err = io.Copy(f, in); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return f.Chtimes(time.Time{}, st.ModTime())
+}
+
+func rm(v *nfs.Target, args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "remove directories and their contents")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	for _, path := range fs.Args() {
+		info, err := v.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case *recursive:
+			err = v.RemoveAll(path)
+		case info.IsDir():
+			err = v.RmDir(path)
+		default:
+			err = v.Remove(path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mkdir(v *nfs.Target, args []string) error {
+	fs := flag.NewFlagSet("mkdir", flag.ContinueOnError)
+	parents := fs.Bool("p", false, "create the parent directories as needed")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	for _, path := range fs.Args() {
+		var err error
+		if *parents {
+			err = v.MkdirAll(path, 0o755)
+		} else {
+			_, err = v.Mkdir(path, 0o755)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mv(v *nfs.Target, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	from, to := args[0], args[1]
+	if info, err := v.Stat(to); err == nil && info.IsDir() {
+		to = _path.Join(to, _path.Base(from))
+	}
+
+	return v.Rename(from, to)
+}
+
+func df(v *nfs.Target, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	st, err := v.FSStat()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "\tSize\t Used\t Avail\t Use%%\t\n")
+	fmt.Fprintf(w, "Bytes\t%s\t %s\t %s\t %s\t\n",
+		humanSize(int64(st.TBytes)), humanSize(int64(st.TBytes-st.FBytes)), humanSize(int64(st.ABytes)),
+		percent(st.TBytes-st.FBytes, st.TBytes))
+	fmt.Fprintf(w, "Inodes\t%d\t %d\t %d\t %s\t\n",
+		st.TFiles, st.TFiles-st.FFiles, st.AFiles, percent(st.TFiles-st.FFiles, st.TFiles))
+
+	return w.Flush()
+}
+
+func percent(used, total uint64) string {
+	if total == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d%%", (used*100+total-1)/total)
+}
+
+// humanSize formats n bytes with a binary unit suffix, like ls -h
+func humanSize(n int64) string {
+	const units = "KMGTPE"
+
+	if n < 1024 {
+		return fmt.Sprintf("%d", n)
+	}
+
+	f, i := float64(n)/1024, 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+
+	return fmt.Sprintf("%.1f%c", f, units[i])
+}
+
+// fsTest reports the misbehaviours testing/fstest finds on the export
+func fsTest(v *nfs.Target, args []string) error {
+	if err := nfstest.TestFS(v, args...); err != nil {
+		return err
+	}
+
+	fmt.Println("ok")
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Command nfsmount mounts an NFS export through FUSE, without the kernel NFS
+// client or root privileges.
+//
+//	nfsmount [flags] <host>:<export> <mountpoint>
+//
+// The file system stays mounted until the command is interrupted or the mount
+// point is unmounted with fusermount -u.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/fusefs"
+	"github.com/go-nfs/nfsv3/nfs/rpc"
+	"github.com/go-nfs/nfsv3/nfs/util"
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func main() {
+	var (
+		uid      = flag.Uint("uid", uint(os.Getuid()), "uid to send to the server")
+		gid      = flag.Uint("gid", uint(os.Getgid()), "gid to send to the server")
+		priv     = flag.Bool("priv", false, "connect from a privileged port")
+		debug    = flag.Bool("debug", false, "log the NFS calls")
+		options  = flag.String("o", "", "comma separated FUSE mount options")
+		readOnly = flag.Bool("ro", false, "mount read-only")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <host>:<export> <mountpoint>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// an IPv6 host is bracketed, as in [::1]:/export
+	sep := ":"
+	if strings.HasPrefix(flag.Arg(0), "[") {
+		sep = "]:"
+	}
+	host, export, ok := strings.Cut(strings.TrimPrefix(flag.Arg(0), "["), sep)
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := flag.Arg(1)
+
+	util.DefaultLogger.SetDebug(*debug)
+
+	mount, err := nfs.DialMount(host, *priv)
+	if err != nil {
+		log.Fatalf("unable to dial MOUNT service: %v", err)
+	}
+	defer mount.Close()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("hostname: %v", err)
+	}
+
+	auth := rpc.NewAuthUnix(hostname, uint32(*uid), uint32(*gid))
+
+	v, err := mount.Mount(export, auth.Auth())
+	if err != nil {
+		log.Fatalf("unable to mount %s: %v", export, err)
+	}
+	defer v.Close()
+
+	opts := &fs.Options{}
+	opts.FsName = flag.Arg(0)
+	opts.Name = "nfs"
+	opts.Debug = *debug
+	if *options != "" {
+		opts.Options = strings.Split(*options, ",")
+	}
+	if *readOnly {
+		opts.Options = append(opts.Options, "ro")
+	}
+
+	server, err := fusefs.Mount(dir, v, opts)
+	if err != nil {
+		log.Fatalf("unable to mount %s on %s: %v", flag.Arg(0), dir, err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		if err := server.Unmount(); err != nil {
+			util.Errorf("unmount %s: %v", dir, err)
+		}
+	}()
+
+	server.Wait()
+}
@@ -0,0 +1,201 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Command nfssftp serves an NFS export to SFTP clients.
+//
+//	nfssftp [flags] -hostkey <key> -authorized-keys <file> <host>:<export>
+//
+// Clients authenticate with one of the public keys of the authorized keys
+// file.  Every client accesses the export with the uid and gid given on the
+// command line, whatever user name it logs in with.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/rpc"
+	"github.com/go-nfs/nfsv3/nfs/sftpfs"
+	"github.com/go-nfs/nfsv3/nfs/util"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func main() {
+	var (
+		listen   = flag.String("listen", ":2022", "address to listen on")
+		hostKey  = flag.String("hostkey", "", "file holding the private host key")
+		keysFile = flag.String("authorized-keys", "", "file holding the public keys of the clients")
+		uid      = flag.Uint("uid", uint(os.Getuid()), "uid to send to the server")
+		gid      = flag.Uint("gid", uint(os.Getgid()), "gid to send to the server")
+		priv     = flag.Bool("priv", false, "connect from a privileged port")
+		debug    = flag.Bool("debug", false, "log the NFS calls")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] -hostkey <key> -authorized-keys <file> <host>:<export>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *hostKey == "" || *keysFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// an IPv6 host is bracketed, as in [::1]:/export
+	sep := ":"
+	if strings.HasPrefix(flag.Arg(0), "[") {
+		sep = "]:"
+	}
+	host, export, ok := strings.Cut(strings.TrimPrefix(flag.Arg(0), "["), sep)
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	util.DefaultLogger.SetDebug(*debug)
+
+	config, err := serverConfig(*hostKey, *keysFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mount, err := nfs.DialMount(host, *priv)
+	if err != nil {
+		log.Fatalf("unable to dial MOUNT service: %v", err)
+	}
+	defer mount.Close()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("hostname: %v", err)
+	}
+
+	auth := rpc.NewAuthUnix(hostname, uint32(*uid), uint32(*gid))
+
+	v, err := mount.Mount(export, auth.Auth())
+	if err != nil {
+		log.Fatalf("unable to mount %s: %v", export, err)
+	}
+	defer v.Close()
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("listen %s: %v", *listen, err)
+	}
+	util.Infof("serving %s on %s", flag.Arg(0), l.Addr())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Fatalf("accept: %v", err)
+		}
+
+		go serve(conn, config, v)
+	}
+}
+
+// serverConfig loads the host key and the keys clients may log in with
+func serverConfig(hostKey, keysFile string) (*ssh.ServerConfig, error) {
+	b, err := os.ReadFile(hostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %v", hostKey, err)
+	}
+
+	b, err = os.ReadFile(keysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys [][]byte
+	for len(bytes.TrimSpace(b)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %v", keysFile, err)
+		}
+		keys = append(keys, key.Marshal())
+		b = rest
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range keys {
+				if bytes.Equal(k, key.Marshal()) {
+					return nil, nil
+				}
+			}
+
+			return nil, fmt.Errorf("unknown public key for %q", c.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	return config, nil
+}
+
+// serve runs the SSH connection of a client, answering the sftp subsystem
+// requests of its sessions
+func serve(conn net.Conn, config *ssh.ServerConfig, v *nfs.Target) {
+	defer conn.Close()
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		util.Errorf("handshake with %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sconn.Close()
+
+	util.Infof("%s logged in from %s", sconn.User(), sconn.RemoteAddr())
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			util.Errorf("accept channel from %s: %v", sconn.RemoteAddr(), err)
+			return
+		}
+
+		go session(ch, requests, v)
+	}
+}
+
+// session waits for the sftp subsystem to be requested, then serves it until
+// the client is done
+func session(ch ssh.Channel, requests <-chan *ssh.Request, v *nfs.Target) {
+	defer ch.Close()
+
+	for req := range requests {
+		// the payload is the name of the subsystem, as an SSH string
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+
+		go ssh.DiscardRequests(requests)
+
+		server := sftp.NewRequestServer(ch, sftpfs.Handlers(v))
+		if err := server.Serve(); err != nil && err != io.EOF {
+			util.Errorf("sftp: %v", err)
+		}
+		server.Close()
+		return
+	}
+}
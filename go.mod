@@ -1,5 +1,17 @@
 module github.com/go-nfs/nfsv3
 
-go 1.15
+go 1.23
 
-require github.com/rasky/go-xdr v0.0.0-20170124162913-1a41d1a06c93 // indirect
+require (
+	github.com/go-git/go-billy/v5 v5.6.2
+	github.com/hanwen/go-fuse/v2 v2.7.2
+	github.com/pkg/sftp v1.13.7
+	github.com/rasky/go-xdr v0.0.0-20170124162913-1a41d1a06c93
+	golang.org/x/crypto v0.32.0
+	golang.org/x/net v0.34.0
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+)
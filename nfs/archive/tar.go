@@ -0,0 +1,402 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Package archive streams trees of NFS targets to and from archives, reading
+// and writing the files with RPCs as the archive is produced or consumed,
+// without staging them on local disk.
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	_path "path"
+	"strings"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+// readAhead is the number of READs kept in flight for the files archived
+const readAhead = 4
+
+// TarTree writes the tree at root on v to w as a tar stream, with the names
+// relative to root.  Regular files, directories, symlinks, named pipes and
+// device nodes are archived with their modes, owners and modification times,
+// and further links to an already archived file are recorded as hard links.
+// Sockets are skipped.  It stops at the first error.
+func TarTree(v *nfs.Target, root string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	// the first name archived for each file with several links
+	links := map[uint64]string{}
+	parents := newParents(v, root, "tar")
+
+	err := v.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == root {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if err := parents.check(path); err != nil {
+			return err
+		}
+
+		return tarFile(v, tw, links, path, entryName(root, path), info)
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// tarFile writes the header of the file at path, archived as name, followed
+// by its contents
+func tarFile(v *nfs.Target, tw *tar.Writer, links map[uint64]string, path, name string, info os.FileInfo) error {
+	fattr, ok := info.Sys().(*nfs.Fattr)
+	if !ok {
+		return &os.PathError{Op: "tar", Path: path, Err: os.ErrInvalid}
+	}
+
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(fattr.FileMode & 0o7777),
+		Uid:     int(fattr.UID),
+		Gid:     int(fattr.GID),
+		ModTime: fattr.ModTime(),
+	}
+
+	switch fattr.Type {
+	case nfs.NF3Reg:
+		if fattr.Nlink > 1 {
+			if first, ok := links[fattr.Fileid]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				return tw.WriteHeader(hdr)
+			}
+			links[fattr.Fileid] = name
+		}
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(fattr.Filesize)
+
+	case nfs.NF3Dir:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+
+	case nfs.NF3Lnk:
+		link, err := v.Readlink(path)
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = link
+
+	case nfs.NF3FIFO:
+		hdr.Typeflag = tar.TypeFifo
+
+	case nfs.NF3Chr, nfs.NF3Blk:
+		hdr.Typeflag = tar.TypeChar
+		if fattr.Type == nfs.NF3Blk {
+			hdr.Typeflag = tar.TypeBlock
+		}
+		hdr.Devmajor = int64(fattr.SpecData[0])
+		hdr.Devminor = int64(fattr.SpecData[1])
+
+	default:
+		util.Infof("tar %s: skipping socket", path)
+		return nil
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+		return nil
+	}
+
+	f, err := v.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	f.SetReadAhead(readAhead)
+
+	// a file that shrank while being archived would corrupt the stream
+	n, err := io.Copy(tw, io.LimitReader(f, hdr.Size))
+	if err == nil && n < hdr.Size {
+		err = fmt.Errorf("tar %s: file shrank from %d to %d bytes", path, hdr.Size, n)
+	}
+
+	return err
+}
+
+// entryName is the name path is archived as, in the tree rooted at root
+func entryName(root, path string) string {
+	if root == "." || root == "" {
+		return path
+	}
+
+	return strings.TrimPrefix(strings.TrimPrefix(path, root), "/")
+}
+
+// dirAttrs are applied to a directory once its contents have been restored
+type dirAttrs struct {
+	path  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+// Untar restores the tar stream r under root on v, which is created if
+// needed, preserving the modes and modification times of regular files,
+// directories, symlinks and special files, and recreating hard links.
+// Existing files are replaced.  Names are resolved relative to root, and names
+// and hard links climbing out of it, or leading through a symlink, are
+// refused.  Owners are not restored.  It
+// stops at the first error.
+func Untar(v *nfs.Target, root string, r io.Reader) error {
+	if err := v.MkdirAll(root, 0o755); err != nil {
+		return err
+	}
+
+	var dirs []dirAttrs
+	parents := newParents(v, root, "untar")
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := destPath(root, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		// an earlier entry may have made a directory on the way a symlink,
+		// through which the entry would be restored out of root
+		if hdr.Typeflag == tar.TypeDir {
+			err = parents.walk(path)
+		} else {
+			err = parents.check(path)
+		}
+		if err != nil {
+			return err
+		}
+
+		// the permission, special and type bits
+		mode := hdr.FileInfo().Mode()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			// keep the directory writable until its contents are restored
+			if err = v.MkdirAll(path, mode.Perm()|0o700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirAttrs{path: path, mode: mode, mtime: hdr.ModTime})
+
+		case tar.TypeReg:
+			err = untarFile(v, tr, path, mode, hdr.ModTime)
+
+		case tar.TypeSymlink:
+			attr := nfs.Sattr3{
+				Mode:  nfs.SetMode{SetIt: true, Mode: uint32(hdr.Mode & 0o7777)},
+				Mtime: nfs.SetTime{SetIt: nfs.SetToClientTime, Time: nfs3Time(hdr.ModTime)},
+			}
+			if err = replace(v, path); err == nil {
+				_, _, err = v.Symlink(hdr.Linkname, path, &attr)
+			}
+
+		case tar.TypeLink:
+			var old string
+			if old, err = destPath(root, hdr.Linkname); err != nil {
+				return err
+			}
+			if err = parents.check(old); err != nil {
+				return err
+			}
+			if err = replace(v, path); err == nil {
+				err = v.Link(old, path)
+			}
+
+		case tar.TypeFifo, tar.TypeChar, tar.TypeBlock:
+			if err = replace(v, path); err == nil {
+				_, err = v.Mknod(path, mode, uint32(hdr.Devmajor), uint32(hdr.Devminor))
+			}
+			if err == nil {
+				err = v.Chtimes(path, time.Time{}, hdr.ModTime)
+			}
+
+		default:
+			util.Infof("untar %s: skipping entry of type %q", hdr.Name, hdr.Typeflag)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// deepest first, as setting them on a parent would be undone by the
+	// changes made to its children
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := v.Chmod(d.path, d.mode); err != nil {
+			return err
+		}
+		if err := v.Chtimes(d.path, time.Time{}, d.mtime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// untarFile writes the contents of the current entry of tr to a new file at
+// path
+func untarFile(v *nfs.Target, tr *tar.Reader, path string, mode os.FileMode, mtime time.Time) error {
+	// a file at path may be a hard link to one restored earlier, which
+	// truncating would clobber
+	if err := replace(v, path); err != nil {
+		return err
+	}
+
+	// keep the file writable by its owner until the contents are written
+	f, err := v.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm()|0o200)
+	if err != nil {
+		return err
+	}
+
+	if err = f.SetPipelined(true); err != nil {
+		f.Close()
+		return err
+	}
+
+	if _, err = io.Copy(f, tr); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if err = f.Chmod(mode); err != nil {
+		return err
+	}
+
+	return f.Chtimes(time.Time{}, mtime)
+}
+
+// replace removes the file at path, if any, to make room for a restored one.
+// A directory in the way is an error.
+func replace(v *nfs.Target, path string) error {
+	info, err := v.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return &os.PathError{Op: "untar", Path: path, Err: os.ErrExist}
+	}
+
+	return v.Remove(path)
+}
+
+// destPath maps the name of an archive entry to its path under root, refusing
+// names that climb out of it
+func destPath(root, name string) (string, error) {
+	if clean := _path.Clean(name); clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", &os.PathError{Op: "untar", Path: name, Err: os.ErrInvalid}
+	}
+
+	return _path.Join(root, _path.Clean("/"+name)), nil
+}
+
+// errSymlinkParent is the error of a path leading through a symlink
+var errSymlinkParent = errors.New("symlink in the path")
+
+// parents checks that no directory leading from root to a path is a symlink,
+// which would take the files restored or archived out of root.  The
+// directories checked are remembered, as restoring an archive never replaces
+// them.
+type parents struct {
+	v    *nfs.Target
+	root string
+	op   string
+	dirs map[string]bool
+}
+
+// newParents returns the checker of the paths under root, failing with
+// *os.PathError errors of op
+func newParents(v *nfs.Target, root, op string) *parents {
+	return &parents{v: v, root: _path.Clean(root), op: op, dirs: map[string]bool{}}
+}
+
+// check returns an error when a directory between root and path is a symlink
+func (p *parents) check(path string) error {
+	return p.walk(_path.Dir(path))
+}
+
+// walk returns an error when dir, or a directory between root and dir, is a
+// symlink
+func (p *parents) walk(dir string) error {
+	rel := entryName(p.root, _path.Clean(dir))
+	if rel == "." || rel == "" {
+		return nil
+	}
+
+	at := p.root
+	for _, name := range strings.Split(rel, "/") {
+		at = _path.Join(at, name)
+		if p.dirs[at] {
+			continue
+		}
+
+		info, err := p.v.Lstat(at)
+		if os.IsNotExist(err) {
+			// nothing below exists yet
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return &os.PathError{Op: p.op, Path: at, Err: errSymlinkParent}
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		p.dirs[at] = true
+	}
+
+	return nil
+}
+
+func nfs3Time(t time.Time) nfs.NFS3Time {
+	return nfs.NFS3Time{
+		Seconds:  uint32(t.Unix()),
+		Nseconds: uint32(t.Nanosecond()),
+	}
+}
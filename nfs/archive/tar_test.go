@@ -0,0 +1,64 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/go-nfs/nfsv3/nfs/nfstest"
+)
+
+func TestDestPath(t *testing.T) {
+	tests := []struct {
+		root, name, want string
+	}{
+		{"restore", "a/b", "restore/a/b"},
+		{"restore", "./a/b/", "restore/a/b"},
+		{"restore", "./", "restore"},
+		{"restore", "/etc/passwd", "restore/etc/passwd"},
+		{"restore", "a/../b", "restore/b"},
+		{".", "a", "a"},
+		{"restore", "..", ""},
+		{"restore", "../a", ""},
+		{"restore", "a/../../b", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := destPath(tt.root, tt.name)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("destPath(%q, %q) = %q, want an error", tt.root, tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("destPath(%q, %q) = %q, %v, want %q", tt.root, tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// test an archive making a directory a symlink cannot restore files through
+// it
+func TestUntarSymlinkParent(t *testing.T) {
+	_, v := nfstest.Mount(t)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "x", Linkname: "/", Mode: 0o777})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "x/file", Size: 4, Mode: 0o644})
+	tw.Write([]byte("evil"))
+	tw.Close()
+
+	err := Untar(v, "restore", &buf)
+	if !errors.Is(err, errSymlinkParent) {
+		t.Errorf("untar = %v, want the symlink refused", err)
+	}
+	if _, err := v.Lstat("file"); !os.IsNotExist(err) {
+		t.Errorf("file restored out of the root: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package archive
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+// ZipTree writes the tree at root on v to w as a zip archive, with the names
+// relative to root, such as to answer an HTTP request to download a directory.
+// Files are deflated and read through io.SectionReaders over their READs, so
+// nothing is staged locally and w may be written as the archive is built.
+// Directories and symlinks are archived along with their modes and
+// modification times, special files are skipped.  It stops at the first error.
+func ZipTree(v *nfs.Target, root string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	parents := newParents(v, root, "zip")
+
+	err := v.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == root {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		// a directory replaced by a symlink since it was listed would have
+		// the files below read from out of root
+		if err := parents.check(path); err != nil {
+			return err
+		}
+
+		return zipFile(v, zw, path, entryName(root, path), info)
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// zipFile adds the file at path to the archive as name
+func zipFile(v *nfs.Target, zw *zip.Writer, path, name string, info fs.FileInfo) error {
+	mode := info.Mode()
+	if !mode.IsRegular() && !mode.IsDir() && mode&fs.ModeSymlink == 0 {
+		util.Infof("zip %s: skipping special file", path)
+		return nil
+	}
+
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+
+	hdr.Name = name
+	switch {
+	case mode.IsDir():
+		hdr.Name += "/"
+		hdr.Method = zip.Store
+	case mode.IsRegular():
+		hdr.Method = zip.Deflate
+	default:
+		hdr.Method = zip.Store
+	}
+
+	fw, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case mode.IsDir():
+		return nil
+
+	case mode&fs.ModeSymlink != 0:
+		// the contents of a symlink are its target, as Info-ZIP stores them
+		link, err := v.Readlink(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, link)
+		return err
+	}
+
+	f, err := v.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(fw, io.NewSectionReader(f, 0, info.Size()))
+	return err
+}
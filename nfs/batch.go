@@ -0,0 +1,45 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"sync"
+)
+
+// defaultBatchConcurrency is the number of requests a batch keeps in flight
+// unless told otherwise
+const defaultBatchConcurrency = 16
+
+// RemoveBatch removes the files at paths, keeping up to concurrency REMOVEs in
+// flight instead of waiting for each reply before sending the next request.
+// It returns the result for each path at the same index, nil where the file
+// was removed.  A concurrency below 1 selects a default of 16.
+func (v *Target) RemoveBatch(paths []string, concurrency int) []error {
+	if concurrency < 1 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	errs := make([]error, len(paths))
+
+	var wg sync.WaitGroup
+	work := make(chan int)
+	for i := 0; i < concurrency && i < len(paths); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for idx := range work {
+				errs[idx] = v.Remove(paths[idx])
+			}
+		}()
+	}
+
+	for i := range paths {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	return errs
+}
@@ -0,0 +1,244 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Package billyfs implements a go-billy file system over an NFS target, so that
+// go-git and other billy consumers can work on an export directly, without
+// mounting it.
+package billyfs
+
+import (
+	"io/fs"
+	"os"
+	_path "path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-nfs/nfsv3/nfs"
+)
+
+const (
+	// minLockRetry and maxLockRetry bound the delay between attempts to take
+	// a file lock held by someone else
+	minLockRetry = 10 * time.Millisecond
+	maxLockRetry = time.Second
+
+	// defaultDirPerm is the mode of the directories created for new files
+	defaultDirPerm = 0o755
+)
+
+// Filesystem is a billy.Filesystem storing its files on a Target.  Paths are
+// relative to the root of the file system, with or without a leading slash.
+type Filesystem struct {
+	v    *nfs.Target
+	root string
+}
+
+var (
+	_ billy.Filesystem = (*Filesystem)(nil)
+	_ billy.Change     = (*Filesystem)(nil)
+	_ billy.File       = (*file)(nil)
+)
+
+// New returns a file system rooted at the root of the export of v
+func New(v *nfs.Target) *Filesystem {
+	return &Filesystem{
+		v:    v,
+		root: "/",
+	}
+}
+
+// clean turns a billy path into a path relative to the root of the target
+func clean(path string) string {
+	path = strings.TrimPrefix(_path.Clean("/"+path), "/")
+	if path == "" {
+		return "."
+	}
+
+	return path
+}
+
+func (bfs *Filesystem) Create(filename string) (billy.File, error) {
+	return bfs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+func (bfs *Filesystem) Open(filename string) (billy.File, error) {
+	return bfs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the file with the given os.O_* flags like os.OpenFile.  With
+// O_CREATE, the missing parent directories of the file are created as well.
+func (bfs *Filesystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	path := clean(filename)
+	if flag&os.O_CREATE != 0 {
+		if err := bfs.v.MkdirAll(_path.Dir(path), defaultDirPerm); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := bfs.v.OpenFile(path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, name: filename}, nil
+}
+
+func (bfs *Filesystem) Stat(filename string) (os.FileInfo, error) {
+	return bfs.v.Stat(clean(filename))
+}
+
+func (bfs *Filesystem) Lstat(filename string) (os.FileInfo, error) {
+	return bfs.v.Lstat(clean(filename))
+}
+
+func (bfs *Filesystem) Rename(oldpath, newpath string) error {
+	to := clean(newpath)
+	if err := bfs.v.MkdirAll(_path.Dir(to), defaultDirPerm); err != nil {
+		return err
+	}
+
+	return bfs.v.Rename(clean(oldpath), to)
+}
+
+// Remove removes the file or empty directory at filename
+func (bfs *Filesystem) Remove(filename string) error {
+	path := clean(filename)
+
+	info, err := bfs.v.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return bfs.v.RmDir(path)
+	}
+
+	return bfs.v.Remove(path)
+}
+
+func (bfs *Filesystem) Join(elem ...string) string {
+	return _path.Join(elem...)
+}
+
+// TempFile creates a file named prefix followed by a random string in dir, or
+// in the root of the file system for an empty dir
+func (bfs *Filesystem) TempFile(dir, prefix string) (billy.File, error) {
+	path := clean(dir)
+	if err := bfs.v.MkdirAll(path, defaultDirPerm); err != nil {
+		return nil, err
+	}
+
+	f, name, err := bfs.v.CreateTemp(path, prefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, name: _path.Join(dir, _path.Base(name))}, nil
+}
+
+// ReadDir lists a directory with READDIRPLUS, sorted by file name
+func (bfs *Filesystem) ReadDir(path string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(nfs.FS(bfs.v), clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func (bfs *Filesystem) MkdirAll(filename string, perm os.FileMode) error {
+	return bfs.v.MkdirAll(clean(filename), perm)
+}
+
+// Symlink creates link pointing to target, creating the missing parent
+// directories of link
+func (bfs *Filesystem) Symlink(target, link string) error {
+	path := clean(link)
+	if err := bfs.v.MkdirAll(_path.Dir(path), defaultDirPerm); err != nil {
+		return err
+	}
+
+	_, _, err := bfs.v.Symlink(target, path, nil)
+	return err
+}
+
+func (bfs *Filesystem) Readlink(link string) (string, error) {
+	return bfs.v.Readlink(clean(link))
+}
+
+// Chmod, Lchown, Chown and Chtimes implement billy.Change
+
+func (bfs *Filesystem) Chmod(name string, mode os.FileMode) error {
+	return bfs.v.Chmod(clean(name), mode)
+}
+
+func (bfs *Filesystem) Lchown(name string, uid, gid int) error {
+	return bfs.v.Lchown(clean(name), uid, gid)
+}
+
+func (bfs *Filesystem) Chown(name string, uid, gid int) error {
+	return bfs.v.Chown(clean(name), uid, gid)
+}
+
+func (bfs *Filesystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return bfs.v.Chtimes(clean(name), atime, mtime)
+}
+
+// Chroot returns the file system rooted at path.  Like Target.Sub it is not a
+// security boundary, as the server resolves ".." above the new root.
+func (bfs *Filesystem) Chroot(path string) (billy.Filesystem, error) {
+	sub, err := bfs.v.Sub(clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filesystem{
+		v:    sub,
+		root: _path.Join(bfs.root, path),
+	}, nil
+}
+
+func (bfs *Filesystem) Root() string {
+	return bfs.root
+}
+
+// file is a billy.File, holding a whole file lock with the server's lock
+// manager between Lock and Unlock
+type file struct {
+	*nfs.File
+	name string
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+// Lock takes an exclusive lock on the whole file, waiting for conflicting
+// locks to be released
+func (f *file) Lock() error {
+	for delay := minLockRetry; ; {
+		err := f.File.TryLock(0, 0, true)
+		if err != nfs.ErrLocked {
+			return err
+		}
+
+		time.Sleep(delay)
+		if delay *= 2; delay > maxLockRetry {
+			delay = maxLockRetry
+		}
+	}
+}
+
+func (f *file) Unlock() error {
+	return f.File.Unlock(0, 0)
+}
@@ -0,0 +1,26 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package billyfs
+
+import "testing"
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"", "."},
+		{"/", "."},
+		{".", "."},
+		{"a/b", "a/b"},
+		{"/a/b/", "a/b"},
+		{"../a", "a"},
+		{"a/../../b", "b"},
+	}
+
+	for _, tt := range tests {
+		if got := clean(tt.path); got != tt.want {
+			t.Errorf("clean(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+// writeBuffer gathers small writes to contiguous offsets
+type writeBuffer struct {
+	off  uint64
+	data []byte
+}
+
+// bufferedWrite is data taken from the buffer, or passed through it, to be
+// written.  from counts the bytes of the caller's write within data.
+type bufferedWrite struct {
+	off  uint64
+	data []byte
+	from int
+}
+
+// SetBuffered makes Write gather small writes into WRITEs of the server's
+// preferred size instead of issuing one per call.  The buffered data is written
+// when the buffer fills up, when a write does not continue where the previous
+// one ended, and before any read, Stat, SetAttr, Sync, Commit or Close.  A
+// failed WRITE of buffered data may thus be reported by one of those calls.
+// Switching buffering off flushes the buffer.
+func (f *File) SetBuffered(on bool) error {
+	if on {
+		if f.wb == nil {
+			f.wb = &writeBuffer{}
+		}
+		return nil
+	}
+
+	err := f.flush()
+	f.wb = nil
+
+	return err
+}
+
+// write buffers p to be written at off.  The buffer is written out first when
+// p does not continue it.  The WRITEs are issued without holding the lock, so
+// concurrent writers do not wait for each other's replies.
+func (wb *writeBuffer) write(f *File, p []byte, off uint64) (int, error) {
+	size := int(f.fsinfo.WTPref)
+	if size < 1 {
+		size = 1
+	}
+
+	f.mu.Lock()
+	var out []bufferedWrite
+	if len(wb.data) > 0 && off != wb.off+uint64(len(wb.data)) {
+		out = append(out, wb.take(0))
+	}
+
+	if len(wb.data) == 0 && len(p) >= size {
+		// nothing to gain from copying data filling whole WRITEs
+		out = append(out, bufferedWrite{off: off, data: p, from: len(p)})
+	} else {
+		buffered := 0
+		for n := 0; n < len(p); {
+			if len(wb.data) == 0 {
+				if wb.data == nil {
+					wb.data = make([]byte, 0, size)
+				}
+				wb.off = off + uint64(n)
+			}
+
+			m := size - len(wb.data)
+			if m > len(p)-n {
+				m = len(p) - n
+			}
+			wb.data = append(wb.data, p[n:n+m]...)
+			n += m
+			buffered += m
+
+			if len(wb.data) >= size {
+				out = append(out, wb.take(buffered))
+				buffered = 0
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	written := len(p)
+	for i, w := range out {
+		if _, err := f.emit(w.data, w.off); err != nil {
+			for _, unwritten := range out[i:] {
+				written -= unwritten.from
+			}
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// take removes the buffered data, from bytes of which were passed to the
+// current write
+func (wb *writeBuffer) take(from int) bufferedWrite {
+	w := bufferedWrite{off: wb.off, data: wb.data, from: from}
+	wb.data = nil
+
+	return w
+}
+
+// flush writes the buffered data
+func (f *File) flush() error {
+	wb := f.wb
+	if wb == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	w := wb.take(0)
+	f.mu.Unlock()
+
+	if len(w.data) == 0 {
+		return nil
+	}
+
+	_, err := f.emit(w.data, w.off)
+	return err
+}
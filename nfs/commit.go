@@ -0,0 +1,115 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"sync"
+
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+// uncommitted keeps the data the server accepted as Unstable until a COMMIT
+// confirms it reached stable storage.  A change of the write verifier between
+// the WRITEs and the COMMIT means the server lost it, typically by rebooting,
+// in which case the data is sent again.
+type uncommitted struct {
+	mu      sync.Mutex
+	chunks  []uncommittedChunk
+	bytes   int
+	verf    uint64
+	hasVerf bool
+	changed bool
+}
+
+type uncommittedChunk struct {
+	off  uint64
+	data []byte
+}
+
+// add records data written at off, which the server acknowledged with verf
+func (u *uncommitted) add(off uint64, data []byte, verf uint64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.hasVerf && u.verf != verf {
+		u.changed = true
+	}
+
+	u.verf = verf
+	u.hasVerf = true
+	u.chunks = append(u.chunks, uncommittedChunk{off: off, data: data})
+	u.bytes += len(data)
+}
+
+// size returns the amount of data kept
+func (u *uncommitted) size() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.bytes
+}
+
+// committed processes the verifier of a COMMIT of count bytes at offset.  If
+// it shows the server lost data, every chunk not yet committed is written again
+// using FileSync, otherwise the chunks the COMMIT covered are dropped.
+func (u *uncommitted) committed(f *File, offset uint64, count uint32, verf uint64) error {
+	u.mu.Lock()
+	lost := u.lost(verf)
+	u.verf = verf
+	u.hasVerf = true
+	u.changed = false
+
+	var replay []uncommittedChunk
+	if lost {
+		replay = u.chunks
+		u.chunks = nil
+		u.bytes = 0
+	} else {
+		kept := u.chunks[:0]
+		for _, c := range u.chunks {
+			if !c.within(offset, count) {
+				kept = append(kept, c)
+			} else {
+				u.bytes -= len(c.data)
+			}
+		}
+		u.chunks = kept
+	}
+	u.mu.Unlock()
+
+	if len(replay) > 0 {
+		util.Infof("commit(%x): write verifier changed, writing %d chunks again", f.fh, len(replay))
+	}
+
+	for i, c := range replay {
+		if _, _, err := f.writeFull(c.data, c.off, FileSync); err != nil {
+			// keep what is still missing for the next attempt
+			u.mu.Lock()
+			for _, c := range replay[i:] {
+				u.chunks = append(u.chunks, c)
+				u.bytes += len(c.data)
+			}
+			u.mu.Unlock()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lost tells if the verifier of a COMMIT differs from those of the WRITEs
+// since the previous one.  It must be called with mu held.
+func (u *uncommitted) lost(verf uint64) bool {
+	return u.changed || (u.hasVerf && u.verf != verf)
+}
+
+// within tells if the chunk lies in the count bytes at offset, where a count
+// of 0 extends to the end of the file
+func (c uncommittedChunk) within(offset uint64, count uint32) bool {
+	if c.off < offset {
+		return false
+	}
+
+	return count == 0 || c.off+uint64(len(c.data)) <= offset+uint64(count)
+}
@@ -0,0 +1,48 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import "testing"
+
+func TestUncommittedRange(t *testing.T) {
+	u := &uncommitted{}
+	u.add(0, make([]byte, 10), 1)
+	u.add(10, make([]byte, 10), 1)
+	u.add(20, make([]byte, 10), 1)
+
+	// only the middle chunk is covered
+	if err := u.committed(nil, 5, 20, 1); err != nil {
+		t.Fatalf("commit: %s", err.Error())
+	}
+
+	if len(u.chunks) != 2 || u.chunks[0].off != 0 || u.chunks[1].off != 20 {
+		t.Fatalf("unexpected chunks left: %+v", u.chunks)
+	}
+
+	// a count of 0 extends to the end of the file
+	if err := u.committed(nil, 0, 0, 1); err != nil {
+		t.Fatalf("commit: %s", err.Error())
+	}
+
+	if len(u.chunks) != 0 {
+		t.Fatalf("expected no chunks left, got %+v", u.chunks)
+	}
+}
+
+func TestUncommittedVerifierChange(t *testing.T) {
+	u := &uncommitted{}
+	u.add(0, make([]byte, 10), 1)
+	if u.lost(1) {
+		t.Fatalf("unchanged verifier reported as lost")
+	}
+
+	if !u.lost(2) {
+		t.Fatalf("verifier change at commit not detected")
+	}
+
+	u.add(10, make([]byte, 10), 2)
+	if !u.lost(2) {
+		t.Fatalf("verifier change between writes not detected")
+	}
+}
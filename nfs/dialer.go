@@ -0,0 +1,525 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-nfs/nfsv3/nfs/rpc"
+	"github.com/go-nfs/nfsv3/nfs/util"
+	"golang.org/x/net/proxy"
+)
+
+// Dialer holds the settings of the connections to the portmapper, MOUNT, NFS
+// and NLM services of a server.  The zero Dialer connects over TCP from an
+// unprivileged port.  The address of the server may be an IPv4 or IPv6 one,
+// bracketed or not, or a host name, whose IPv4 and IPv6 addresses are raced
+// against each other as Happy Eyeballs does over TCP.
+type Dialer struct {
+	// Priv binds the connections to a privileged port, as the servers
+	// exporting with the secure option require
+	Priv bool
+
+	// PrivFallback connects from an unprivileged port when Priv is set but
+	// the process is not allowed to bind a privileged one, for the servers
+	// not requiring it
+	PrivFallback bool
+
+	// LocalIP binds the connections to this local address when set, and
+	// Interface to an address of this network interface otherwise, of the
+	// family of the address of the server, for hosts on several networks
+	LocalIP   net.IP
+	Interface string
+
+	// Proto is the transport of the connections, rpc.IPProtoTCP or
+	// rpc.IPProtoUDP for the servers only registering their services over
+	// UDP.  Zero means TCP.
+	Proto uint32
+
+	// Nconnect is the number of connections to the NFS service the calls of
+	// a Target are striped across, like the nconnect mount option of Linux,
+	// to get past the throughput of a single TCP stream.  Zero means one.
+	Nconnect int
+
+	// Reconnect replaces the connections of the services that fail, asking
+	// the portmapper for the port of the service again when it moved, and
+	// sends the idempotent calls in flight on them again.  The other calls in
+	// flight fail with a *rpc.ConnResetError.  The host name of the server is
+	// resolved again on each attempt rather than the address first dialed
+	// being kept, so that the connections follow a virtual IP moved through
+	// DNS.
+	Reconnect bool
+
+	// TLS protects the connections to the services but the portmapper with
+	// RPC-with-TLS when set, which needs TCP.  Its Certificates authenticate
+	// the client to the servers asking for it, and its RootCAs pin the
+	// authorities the certificate of the server must chain to.  The
+	// ServerName defaults to the address of the server.
+	TLS *tls.Config
+
+	// PinnedKeys only accepts the servers whose certificate has one of these
+	// SPKIHash values when set, on top of the verification done by TLS
+	PinnedKeys [][]byte
+
+	// NetDialer is the template of the net.Dialer opening the connections
+	// when set, for its timeouts or its Control function, such as one
+	// binding the sockets to a device or a routing mark.  The privileged port
+	// replaces its LocalAddr when Priv is set.
+	NetDialer *net.Dialer
+
+	// DialContext opens the connections in place of a net.Dialer when set,
+	// such as through a VPN tunnel, over vsock or in another network
+	// namespace.  Priv is then left to it.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// SOCKS5 is the host:port of a SOCKS5 proxy, such as one on a bastion,
+	// the connections go through when set, which needs TCP.  The proxy is
+	// dialed with DialContext or NetDialer when set, and Priv does not apply.
+	SOCKS5 string
+
+	// SOCKS5Auth holds the username and password of the SOCKS5 proxy when it
+	// asks for them
+	SOCKS5Auth *proxy.Auth
+
+	// KeepAlive configures the TCP keep-alive probes detecting dead servers
+	// when set, in place of the defaults of Go
+	KeepAlive *net.KeepAliveConfig
+
+	// Nagle turns Nagle's algorithm back on, Go setting TCP_NODELAY on the
+	// TCP connections it opens
+	Nagle bool
+
+	// ReadBuffer and WriteBuffer are the sizes of the receive and send
+	// buffers of the sockets when not zero
+	ReadBuffer  int
+	WriteBuffer int
+
+	// Interceptors intercept the calls made to the MOUNT, NFS and NLM
+	// services, in this order
+	Interceptors []rpc.Interceptor
+
+	// MaxInFlight bounds the calls in flight to each service, and
+	// MaxInFlightPerConn those on each of its connections, as
+	// rpc.Client.SetMaxInFlight does.  Zero leaves them unbounded.
+	MaxInFlight        int
+	MaxInFlightPerConn int
+
+	// MaxReply is the size of the largest reply received over TCP when not
+	// zero, rpc.DefaultMaxRecord otherwise.  Longer replies fail the calls
+	// with a *rpc.RecordTooLargeError, and the opaques, strings and arrays
+	// longer than the replies they are in with a *xdr.TooLargeError.
+	MaxReply int
+
+	// MountPort, NFSPort and NLMPort are the ports of the MOUNT, NFS and NLM
+	// services when not zero, which are then dialed without asking the
+	// portmapper, for the servers behind firewalls or in containers not
+	// exposing it
+	MountPort int
+	NFSPort   int
+	NLMPort   int
+
+	// NoPortmap never asks the portmapper for the ports of the services,
+	// NFS being at NFSPort or 2049 and the others failing to dial without
+	// their port
+	NoPortmap bool
+
+	// Capture records the traffic of the connections when set, as it goes
+	// over them after TLS
+	Capture *rpc.Capture
+}
+
+// network returns the net.Dial network of the connections
+func (d *Dialer) network() string {
+	if d.Proto == rpc.IPProtoUDP {
+		return "udp"
+	}
+
+	return "tcp"
+}
+
+// DialService dials the RPC service prog at addr over the transport of d,
+// after getting its port from the portmapper.  The transport overrides the
+// Prot of prog.
+func (d *Dialer) DialService(ctx context.Context, addr string, prog rpc.Mapping) (*rpc.Client, error) {
+	return d.dialService(ctx, addr, prog, 1)
+}
+
+// dialService is DialService opening conns connections to the service
+func (d *Dialer) dialService(ctx context.Context, addr string, prog rpc.Mapping, conns int) (*rpc.Client, error) {
+	network := d.network()
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+
+	prog.Prot = rpc.IPProtoTCP
+	if network == "udp" {
+		prog.Prot = rpc.IPProtoUDP
+	}
+
+	if d.TLS != nil && network != "tcp" {
+		return nil, errTLSOverUDP
+	}
+	if d.SOCKS5 != "" && network != "tcp" {
+		return nil, errProxyOverUDP
+	}
+
+	port, err := d.port(ctx, network, addr, prog)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.connect(ctx, network, addr, prog, port)
+	if err != nil {
+		return nil, err
+	}
+
+	client := rpc.NewClientFromConn(conn)
+	for i := 1; i < conns; i++ {
+		conn, err := d.connect(ctx, network, addr, prog, port)
+		if err == nil {
+			err = client.AddNetConn(conn)
+		}
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	d.setup(client)
+
+	if d.Reconnect {
+		client.SetReconnect(&rpc.Reconnect{
+			Dial:       d.redial(network, addr, prog, port),
+			Idempotent: idempotent,
+		})
+	}
+
+	return client, nil
+}
+
+// setup applies the interceptors and limits of d to client
+func (d *Dialer) setup(client *rpc.Client) {
+	for _, i := range d.Interceptors {
+		client.AddInterceptor(i)
+	}
+	client.SetMaxInFlight(d.MaxInFlight, d.MaxInFlightPerConn)
+	if d.MaxReply > 0 {
+		client.SetMaxRecord(d.MaxReply)
+	}
+}
+
+// connect opens a connection to the service prog at port of addr, upgraded
+// to TLS when set
+func (d *Dialer) connect(ctx context.Context, network, addr string, prog rpc.Mapping, port int) (net.Conn, error) {
+	conn, err := d.dial(ctx, network, addr, port, d.Priv)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.TLS != nil {
+		tc, err := rpc.StartTLS(ctx, conn, prog.Prog, prog.Vers, d.tlsConfig(addr))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	return d.capture(conn), nil
+}
+
+// capture returns conn, recording its traffic to the capture of d when set
+func (d *Dialer) capture(conn net.Conn) net.Conn {
+	if d.Capture == nil {
+		return conn
+	}
+
+	return d.Capture.Conn(conn)
+}
+
+// dial opens a connection to port of addr, from a privileged port when priv
+// is set
+func (d *Dialer) dial(ctx context.Context, network, addr string, port int, priv bool) (net.Conn, error) {
+	if d.SOCKS5 != "" {
+		return d.dialProxy(ctx, network, net.JoinHostPort(addr, strconv.Itoa(port)))
+	}
+
+	ip, err := d.localIP(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	dial := func(laddr net.Addr, raddr string) (err error) {
+		conn, err = d.dialSocket(ctx, network, laddr, raddr)
+		return err
+	}
+
+	priv = priv && d.DialContext == nil
+	err = dialFrom(network, addr, port, priv, ip, dial)
+	if err != nil && priv && d.PrivFallback && errors.Is(err, os.ErrPermission) {
+		util.Debugf("Not allowed to bind a privileged port, connecting from an unprivileged one")
+		err = dialFrom(network, addr, port, false, ip, dial)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// localIP returns the IP address the connections to addr are bound to, nil
+// for any
+func (d *Dialer) localIP(addr string) (net.IP, error) {
+	if d.LocalIP != nil || d.Interface == "" {
+		return d.LocalIP, nil
+	}
+
+	ifi, err := net.InterfaceByName(d.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	// an address of the family of addr, IPv4 for host names
+	ip := net.ParseIP(addr)
+	v6 := ip != nil && ip.To4() == nil
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && (ipnet.IP.To4() == nil) == v6 {
+			return ipnet.IP, nil
+		}
+	}
+
+	return nil, fmt.Errorf("nfs: interface %s has no address to connect to %s from", d.Interface, addr)
+}
+
+// dialSocket opens a connection from laddr to raddr, with DialContext or
+// NetDialer when set, and applies the socket options of d to it
+func (d *Dialer) dialSocket(ctx context.Context, network string, laddr net.Addr, raddr string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+
+	if d.DialContext != nil {
+		conn, err = d.DialContext(ctx, network, raddr)
+	} else {
+		var nd net.Dialer
+		if d.NetDialer != nil {
+			nd = *d.NetDialer
+		}
+		if laddr != nil {
+			nd.LocalAddr = laddr
+		}
+
+		conn, err = nd.DialContext(ctx, network, raddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = d.setSockopts(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// port returns the port of prog at addr, set on d or asked to the portmapper
+func (d *Dialer) port(ctx context.Context, network, addr string, prog rpc.Mapping) (int, error) {
+	var port int
+	switch prog.Prog {
+	case MountProg:
+		port = d.MountPort
+	case Nfs3Prog:
+		port = d.NFSPort
+		if port == 0 && d.NoPortmap {
+			port = NFSPort
+		}
+	case NLMProg:
+		port = d.NLMPort
+	}
+
+	if port != 0 {
+		return port, nil
+	}
+
+	if d.NoPortmap {
+		return 0, fmt.Errorf("nfs: no port set for program %d without the portmapper", prog.Prog)
+	}
+
+	return d.getport(ctx, network, addr, prog)
+}
+
+// getport asks the portmapper of addr for the port of prog
+func (d *Dialer) getport(ctx context.Context, network, addr string, prog rpc.Mapping) (int, error) {
+	conn, err := d.dial(ctx, network, addr, rpc.PmapPort, false)
+	if err != nil {
+		util.Errorf("Failed to connect to portmapper: %s", err)
+		return 0, err
+	}
+
+	// the address dialed tells rpcbind the family of the connections
+	host := addr
+	switch a := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		host = a.IP.String()
+	case *net.UDPAddr:
+		host = a.IP.String()
+	}
+	if d.SOCKS5 != "" {
+		host = addr
+	}
+
+	pm := rpc.NewPortmapper(rpc.NewClientFromConn(d.capture(conn)), host)
+	defer pm.Close()
+
+	return pm.LookupContext(ctx, prog)
+}
+
+// redial returns the function dialing the service prog at port of addr again,
+// or at the port the portmapper has for it when the service moved as the
+// server restarted.  addr is kept as given, a host name being resolved by
+// each dial.
+func (d *Dialer) redial(network, addr string, prog rpc.Mapping, port int) func(ctx context.Context) (net.Conn, error) {
+	return func(ctx context.Context) (net.Conn, error) {
+		conn, err := d.connect(ctx, network, addr, prog, port)
+		if err == nil {
+			return conn, nil
+		}
+
+		p, perr := d.port(ctx, network, addr, prog)
+		if perr != nil || p == port || p == 0 {
+			return nil, err
+		}
+
+		return d.connect(ctx, network, addr, prog, p)
+	}
+}
+
+// idempotent reports whether the server may execute a call twice without
+// harm, so that it can be sent again on a new connection when the reply to it
+// was lost.  The NFS calls creating, removing or renaming files are not, as
+// a second execution fails once the first one went through.
+func idempotent(prog, vers, proc uint32) bool {
+	switch prog {
+	case Nfs3Prog:
+		switch proc {
+		case NFSProc3Create, NFSProc3Mkdir, NFSProc3Symlink, NFSProc3Mknod,
+			NFSProc3Remove, NFSProc3RmDir, NFSProc3Rename, NFSProc3Link:
+			return false
+		}
+		return true
+
+	case MountProg:
+		return true
+	}
+
+	return false
+}
+
+// DialMount dials the MOUNT service of the server at addr.  The Targets
+// mounted through it connect to the NFS service with the settings of d.
+func (d *Dialer) DialMount(ctx context.Context, addr string) (*Mount, error) {
+	client, err := d.DialService(ctx, addr, rpc.Mapping{
+		Prog: MountProg,
+		Vers: MountVers,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mount{
+		Client: client,
+		Addr:   addr,
+		dialer: *d,
+	}, nil
+}
+
+// NewTarget dials the NFS service of the server at addr for the export whose
+// root is fh, like the function of the same name
+func (d *Dialer) NewTarget(ctx context.Context, addr string, auth rpc.Auth, fh []byte, dirpath string) (*Target, error) {
+	client, err := d.dialService(ctx, addr, rpc.Mapping{
+		Prog: Nfs3Prog,
+		Vers: Nfs3Vers,
+	}, max(d.Nconnect, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	vol, err := newTarget(ctx, client, auth, fh, dirpath)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	vol.lm = &lockManager{
+		addr:   addr,
+		dialer: *d,
+	}
+
+	return vol, nil
+}
+
+// DialMountConn is DialMount over conn, a connection to the MOUNT service
+// established by other means, such as an SSH channel or a userspace tunnel.
+// The Targets mounted through it share conn unless mounted with MountConn.
+// The connection is upgraded to TLS when set, and is not dialed again when it
+// fails.
+func (d *Dialer) DialMountConn(ctx context.Context, conn net.Conn) (*Mount, error) {
+	client, err := d.clientFromConn(ctx, conn, MountProg, MountVers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mount{
+		Client: client,
+		dialer: *d,
+	}, nil
+}
+
+// NewTargetFromConn is NewTarget over conn, a connection to the NFS service
+// established by other means.  The Target cannot lock files, as the lock
+// manager is dialed by address.
+func (d *Dialer) NewTargetFromConn(ctx context.Context, conn net.Conn, auth rpc.Auth, fh []byte, dirpath string) (*Target, error) {
+	client, err := d.clientFromConn(ctx, conn, Nfs3Prog, Nfs3Vers)
+	if err != nil {
+		return nil, err
+	}
+
+	vol, err := newTarget(ctx, client, auth, fh, dirpath)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return vol, nil
+}
+
+// clientFromConn returns a client of vers of the RPC program prog over conn,
+// upgraded to TLS when set
+func (d *Dialer) clientFromConn(ctx context.Context, conn net.Conn, prog, vers uint32) (*rpc.Client, error) {
+	if d.TLS != nil {
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		tc, err := rpc.StartTLS(ctx, conn, prog, vers, d.tlsConfig(host))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	client := rpc.NewClientFromConn(d.capture(conn))
+	d.setup(client)
+
+	return client, nil
+}
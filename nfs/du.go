@@ -0,0 +1,223 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"os"
+	_path "path"
+	"sort"
+	"sync"
+)
+
+// duListings is the number of directories DiskUsage lists at once, along with
+// the LOOKUPs of their entries listed without attributes
+const duListings = 8
+
+// Usage is the space taken by a directory tree, or by a single file
+type Usage struct {
+	Path string
+
+	// bytes allocated on the server, like du reports, and the sum of the
+	// file sizes, like du --apparent-size reports
+	Used int64
+	Size int64
+
+	// number of files other than directories and of directories, including
+	// the directory itself
+	Files int64
+	Dirs  int64
+
+	// usage of the subdirectories, sorted by path
+	Children []*Usage
+}
+
+// diskUsage is the state shared while walking a tree
+type diskUsage struct {
+	v *Target
+
+	// guards the fields below, signalling cond when queue or busy change
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// the directories left to list, and how many are being listed
+	queue []duDir
+	busy  int
+
+	seen map[uint64]bool
+	errs []error
+}
+
+// duDir is a directory queued to be listed, with its usage to fill in
+type duDir struct {
+	u  *Usage
+	fh []byte
+}
+
+// DiskUsage walks the tree at path, listing several directories concurrently
+// with READDIRPLUS, and returns the space taken by each directory including
+// its subdirectories.  Files with several hard links are counted once.  When
+// parts of the tree cannot be listed the usage of the rest is returned
+// together with the failures, wrapped in a *MultiError when there are
+// several.  Symlinks are not followed.
+func (v *Target) DiskUsage(path string) (*Usage, error) {
+	fattr, fh, err := v.lstat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fattr.IsDir() {
+		return &Usage{
+			Path:  path,
+			Used:  int64(fattr.Used),
+			Size:  fattr.Size(),
+			Files: 1,
+		}, nil
+	}
+
+	du := &diskUsage{
+		v:    v,
+		seen: map[uint64]bool{},
+	}
+	du.cond = sync.NewCond(&du.mu)
+
+	u := newDirUsage(path, fattr)
+	du.queue = []duDir{{u: u, fh: fh}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < duListings; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			du.work()
+		}()
+	}
+	wg.Wait()
+
+	u.total()
+
+	return u, multiError(du.errs)
+}
+
+// newDirUsage returns the usage of the directory itself at path
+func newDirUsage(path string, fattr *Fattr) *Usage {
+	return &Usage{
+		Path: path,
+		Used: int64(fattr.Used),
+		Size: fattr.Size(),
+		Dirs: 1,
+	}
+}
+
+// work lists the queued directories until none is left nor being listed,
+// which could queue more
+func (du *diskUsage) work() {
+	du.mu.Lock()
+	defer du.mu.Unlock()
+
+	for {
+		for len(du.queue) == 0 && du.busy > 0 {
+			du.cond.Wait()
+		}
+		if len(du.queue) == 0 {
+			return
+		}
+
+		d := du.queue[len(du.queue)-1]
+		du.queue = du.queue[:len(du.queue)-1]
+		du.busy++
+		du.mu.Unlock()
+
+		subdirs := du.dir(d.u, d.fh)
+
+		du.mu.Lock()
+		du.queue = append(du.queue, subdirs...)
+		du.busy--
+		du.cond.Broadcast()
+	}
+}
+
+func (du *diskUsage) fail(err error) {
+	du.mu.Lock()
+	defer du.mu.Unlock()
+
+	du.errs = append(du.errs, err)
+}
+
+// first reports whether a file with several links is counted for the first
+// time
+func (du *diskUsage) first(fattr *Fattr) bool {
+	if fattr.Nlink <= 1 {
+		return true
+	}
+
+	du.mu.Lock()
+	defer du.mu.Unlock()
+
+	if du.seen[fattr.Fileid] {
+		return false
+	}
+
+	du.seen[fattr.Fileid] = true
+	return true
+}
+
+// dir lists the directory fh, adding up the usage of its files to u, and
+// returns its subdirectories to list in turn
+func (du *diskUsage) dir(u *Usage, fh []byte) []duDir {
+	entries, err := du.v.ReadDirPlusByFh(fh)
+	if err != nil {
+		du.fail(&os.PathError{Op: "readdir", Path: u.Path, Err: err})
+		return nil
+	}
+
+	var subdirs []duDir
+	for _, entry := range entries {
+		if entry.FileName == "." || entry.FileName == ".." {
+			continue
+		}
+
+		child := _path.Join(u.Path, entry.FileName)
+
+		// entries listed with READDIR carry neither attributes nor handles
+		attr, childFh := &entry.Attr.Attr, entry.Handle.FH
+		if !entry.Attr.IsSet || !entry.Handle.IsSet {
+			if attr, childFh, _, err = du.v.lookup(fh, entry.FileName); err != nil {
+				du.fail(&os.PathError{Op: "lookup", Path: child, Err: err})
+				continue
+			}
+		}
+
+		if !attr.IsDir() {
+			if du.first(attr) {
+				u.Used += int64(attr.Used)
+				u.Size += attr.Size()
+				u.Files++
+			}
+			continue
+		}
+
+		sub := newDirUsage(child, attr)
+		u.Children = append(u.Children, sub)
+		subdirs = append(subdirs, duDir{u: sub, fh: childFh})
+	}
+
+	return subdirs
+}
+
+// total adds the usage of the subdirectories of u, once all are listed, to
+// that of u
+func (u *Usage) total() {
+	sort.Slice(u.Children, func(i, j int) bool {
+		return u.Children[i].Path < u.Children[j].Path
+	})
+
+	for _, sub := range u.Children {
+		sub.total()
+
+		u.Used += sub.Used
+		u.Size += sub.Size
+		u.Files += sub.Files
+		u.Dirs += sub.Dirs
+	}
+}
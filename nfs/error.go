@@ -3,7 +3,11 @@
 //
 package nfs
 
-import "os"
+import (
+	"fmt"
+	"io/fs"
+	"os"
+)
 
 const (
 	NFS3Ok             = 0
@@ -34,6 +38,7 @@ const (
 	NFS3ErrTooSmall    = 10005
 	NFS3ErrServerFault = 10006
 	NFS3ErrBadType     = 10007
+	NFS3ErrJukebox     = 10008
 )
 
 var errToName = map[uint32]string{
@@ -65,6 +70,7 @@ var errToName = map[uint32]string{
 	10005: "NFS3ERR_TOOSMALL",
 	10006: "NFS3ERR_SERVERFAULT",
 	10007: "NFS3ERR_BADTYPE",
+	10008: "NFS3ERR_JUKEBOX",
 }
 
 func NFS3Error(errnum uint32) error {
@@ -97,6 +103,58 @@ type Error struct {
 
 func (err *Error) Error() string { return err.ErrorString }
 
+// Is reports whether the error matches one of the generic errors of io/fs, so
+// that errors.Is(err, fs.ErrPermission) holds for NFS3ERR_ACCES as it does for
+// NFS3ERR_PERM
+func (err *Error) Is(target error) bool {
+	switch target {
+	case fs.ErrPermission:
+		return err.ErrorNum == NFS3ErrAcces
+	case fs.ErrInvalid:
+		return err.ErrorNum == NFS3ErrInval
+	}
+
+	return false
+}
+
+// ErrOutsideExport is the error of looking up a path through a symlink whose
+// absolute target lies outside the exported directory.  It matches
+// fs.ErrNotExist, as the target cannot be reached through the export.
+var ErrOutsideExport = fmt.Errorf("nfs: symlink target outside the export: %w", fs.ErrNotExist)
+
+// MultiError holds the errors of an operation carrying on past failures
+type MultiError struct {
+	Errors []error
+}
+
+func (err *MultiError) Error() string {
+	if len(err.Errors) == 1 {
+		return err.Errors[0].Error()
+	}
+
+	return fmt.Sprintf("%s (and %d more errors)", err.Errors[0], len(err.Errors)-1)
+}
+
+// Unwrap returns the errors collected, for errors.Is and errors.As
+func (err *MultiError) Unwrap() []error { return err.Errors }
+
+// multiError returns nil, the single error or a *MultiError holding errs
+func multiError(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	return &MultiError{Errors: errs}
+}
+
+func isNFS3Error(err error, errnum uint32) bool {
+	nfsErr, ok := err.(*Error)
+	return ok && nfsErr.ErrorNum == errnum
+}
+
 func IsNotEmptyError(err error) bool {
 	nfsErr, ok := err.(*Error)
 	if !ok {
@@ -122,3 +180,9 @@ func IsNotDirError(err error) bool {
 
 	return false
 }
+
+// IsNotSyncError reports whether err is NFS3ERR_NOT_SYNC, the error of a
+// guarded SETATTR whose file changed since the ctime it was guarded with
+func IsNotSyncError(err error) bool {
+	return isNFS3Error(err, NFS3ErrNotSync)
+}
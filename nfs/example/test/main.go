@@ -45,7 +45,6 @@ func main() {
 	if err != nil {
 		log.Fatalf("unable to mount volume: %v", err)
 	}
-	defer v.Close()
 
 	// discover any system files such as lost+found or .snapshot
 	dirs, err := ls(v, ".")
@@ -142,7 +141,7 @@ func main() {
 		log.Fatalf("directory should be empty of our created files!")
 	}
 
-	if err = mount.Unmount(); err != nil {
+	if err = v.Close(); err != nil {
 		log.Fatalf("unable to umount target: %v", err)
 	}
 
@@ -159,7 +158,7 @@ func testFileRW(v *nfs.Target, name string, filesize uint64) error {
 		return err
 	}
 
-	wr, err := v.OpenFile(name, 0777)
+	wr, err := v.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
 	if err != nil {
 		util.Errorf("write fail: %s", err.Error())
 		return err
@@ -0,0 +1,370 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-nfs/nfsv3/nfs/rpc"
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+// defaultFailoverTimeouts is how many calls timing out in a row fail over to
+// the next server unless set otherwise
+const defaultFailoverTimeouts = 3
+
+// errTimingOut is why a Target fails over from a server still connected
+var errTimingOut = errors.New("nfs: calls timing out")
+
+// Failover lists the servers exporting the same filesystem, such as replicas
+// or the nodes behind a pair of virtual IPs, which a Target mounted with
+// MountFailover moves between.  The servers must hand out the same file
+// handles, which is checked against the root handle of the export.
+//
+// The Target stays on a server until its connection fails and cannot be
+// dialed again, or Timeouts calls in a row time out, failing with a
+// *rpc.TimeoutError once the retransmissions of the client are exhausted or
+// with os.ErrDeadlineExceeded once their deadline passes.  It then mounts the
+// export from the next server, in order, and carries on there, the calls in
+// flight being sent again as when reconnecting.  The locks held on the former
+// server are lost.
+type Failover struct {
+	// Addrs are the addresses of the servers, the first one mounted first
+	Addrs []string
+
+	// Timeouts is how many calls timing out in a row fail over, zero meaning
+	// 3
+	Timeouts int
+
+	// OnFailover is called once the Target moved from the server at from to
+	// the one at to, with the error it moved because of.  It is called
+	// from the goroutine reconnecting, which the calls wait for.
+	OnFailover func(from, to string, err error)
+
+	// OnError is called with the error of each server the Target cannot move
+	// to
+	OnError func(addr string, err error)
+}
+
+// failover is the state of a Target failing over between servers
+type failover struct {
+	Failover
+
+	d       Dialer
+	dirpath string
+	auth    rpc.Auth
+
+	// the calls timed out in a row
+	timeouts atomic.Int64
+
+	// guards the fields below
+	mu sync.Mutex
+
+	// the root handle of the export, the same on all the servers
+	fh []byte
+
+	// the server in use, and whether the export was mounted from it
+	cur     int
+	mounted bool
+
+	// set once the calls to the server in use time out, moving away from it
+	// on the next dial
+	down error
+
+	// the connections to the server in use still open, closed when moving
+	// away
+	conns []net.Conn
+
+	// the lock manager of the Target, moved along
+	lm *lockManager
+}
+
+// MountFailover mounts dirpath from the first of the servers of f it can,
+// returning a Target which fails over between them.  Reconnect is implied.
+func (d *Dialer) MountFailover(ctx context.Context, f Failover, dirpath string, auth rpc.Auth) (*Target, error) {
+	if len(f.Addrs) == 0 {
+		return nil, errors.New("nfs: no server to mount from")
+	}
+	if f.Timeouts == 0 {
+		f.Timeouts = defaultFailoverTimeouts
+	}
+
+	fo := &failover{Failover: f, d: *d, dirpath: dirpath, auth: auth}
+
+	conn, err := fo.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client := rpc.NewClientFromConn(conn)
+	for i := 1; i < d.Nconnect; i++ {
+		conn, err := fo.dial(ctx)
+		if err == nil {
+			err = client.AddNetConn(conn)
+		}
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	d.setup(client)
+	client.AddInterceptor(&rpc.InterceptorFuncs{
+		After: func(context.Context, *rpc.CallInfo) {
+			fo.timeouts.Store(0)
+		},
+		Error: func(_ context.Context, _ *rpc.CallInfo, err error) error {
+			fo.fail(err)
+			return err
+		},
+	})
+	client.SetReconnect(&rpc.Reconnect{
+		Dial:       fo.dial,
+		Idempotent: idempotent,
+	})
+
+	vol, err := newTarget(ctx, client, auth, fo.fh, dirpath)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	fo.mu.Lock()
+	fo.lm = &lockManager{
+		addr:   fo.Addrs[fo.cur],
+		dialer: *d,
+	}
+	vol.lm = fo.lm
+	fo.mu.Unlock()
+
+	vol.failover = fo
+	vol.exportPath = dirpath
+
+	return vol, nil
+}
+
+// dial connects to the NFS service of the server in use, or of the next one
+// mounting the export when it cannot.  The server in use is tried last once
+// its calls time out, as it may still be the only one up.
+func (f *failover) dial(ctx context.Context) (net.Conn, error) {
+	f.mu.Lock()
+	cur, mounted, cause := f.cur, f.mounted, f.down
+	f.mu.Unlock()
+
+	n := len(f.Addrs)
+	first := 0
+	if cause != nil && n > 1 {
+		first = 1
+	}
+
+	for i := first; i < first+n; i++ {
+		idx := (cur + i) % n
+		addr := f.Addrs[idx]
+
+		conn, err := f.connect(ctx, addr, idx != cur || !mounted)
+		if err != nil {
+			util.Errorf("failover %s: %s", addr, err.Error())
+			if f.OnError != nil {
+				f.OnError(addr, err)
+			}
+			if cause == nil {
+				cause = err
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+
+		f.mu.Lock()
+		var closing []net.Conn
+		from, moved := f.Addrs[f.cur], idx != f.cur && f.mounted
+		if moved {
+			closing = f.move(idx, cause)
+		}
+		f.cur, f.mounted, f.down = idx, true, nil
+		conn = f.track(conn)
+		f.timeouts.Store(0)
+		f.mu.Unlock()
+
+		for _, c := range closing {
+			c.Close()
+		}
+		if moved && f.OnFailover != nil {
+			f.OnFailover(from, addr, cause)
+		}
+
+		return conn, nil
+	}
+
+	return nil, fmt.Errorf("nfs: no server of %s reachable: %w", f.dirpath, cause)
+}
+
+// connect dials the NFS service of the server at addr, mounting the export
+// from it first when mount is set
+func (f *failover) connect(ctx context.Context, addr string, mount bool) (net.Conn, error) {
+	if mount {
+		if err := f.mount(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	network := f.d.network()
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	prog := rpc.Mapping{Prog: Nfs3Prog, Vers: Nfs3Vers, Prot: rpc.IPProtoTCP}
+	if network == "udp" {
+		prog.Prot = rpc.IPProtoUDP
+	}
+
+	port, err := f.d.port(ctx, network, host, prog)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.d.connect(ctx, network, host, prog, port)
+}
+
+// mount mounts the export from the server at addr, checking it has the root
+// handle of the other servers
+func (f *failover) mount(ctx context.Context, addr string) error {
+	m, err := f.d.DialMount(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	fh, err := m.mnt(ctx, f.dirpath, f.auth)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fh == nil {
+		f.fh = fh
+	} else if !bytes.Equal(fh, f.fh) {
+		return fmt.Errorf("nfs: %s exports %s with another root handle", addr, f.dirpath)
+	}
+
+	return nil
+}
+
+// move makes the server at idx the one in use, returning the connections to
+// the former one for the caller to close once f is unlocked
+func (f *failover) move(idx int, cause error) []net.Conn {
+	util.Infof("failover: %s moved from %s to %s: %v", f.dirpath, f.Addrs[f.cur], f.Addrs[idx], cause)
+
+	conns := f.conns
+	f.conns = nil
+
+	if f.lm != nil {
+		f.lm.move(f.Addrs[idx])
+	}
+
+	return conns
+}
+
+// track records conn as a connection to the server in use, returning it
+// wrapped to forget it once closed
+func (f *failover) track(conn net.Conn) net.Conn {
+	fc := &failoverConn{Conn: conn, f: f}
+	f.conns = append(f.conns, fc)
+
+	if pc, ok := conn.(net.PacketConn); ok {
+		return &failoverPacketConn{failoverConn: fc, pc: pc}
+	}
+
+	return fc
+}
+
+// forget drops conn from the connections to the server in use
+func (f *failover) forget(conn net.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, c := range f.conns {
+		if c == conn {
+			f.conns = append(f.conns[:i], f.conns[i+1:]...)
+			break
+		}
+	}
+}
+
+// failoverConn is a connection of a failover, forgotten once closed
+type failoverConn struct {
+	net.Conn
+	f *failover
+}
+
+func (fc *failoverConn) Close() error {
+	fc.f.forget(fc)
+	return fc.Conn.Close()
+}
+
+// failoverPacketConn is a UDP connection of a failover, still telling the
+// transports it carries datagrams
+type failoverPacketConn struct {
+	*failoverConn
+	pc net.PacketConn
+}
+
+func (fc *failoverPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	return fc.pc.ReadFrom(p)
+}
+
+func (fc *failoverPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return fc.pc.WriteTo(p, addr)
+}
+
+// fail counts a call failing with err, moving away from the server in use
+// once Timeouts calls in a row timed out.  Without retransmissions, as over
+// TCP by default, the calls to a hung server fail at their deadlines instead.
+func (f *failover) fail(err error) {
+	var te *rpc.TimeoutError
+	if !errors.As(err, &te) && !errors.Is(err, os.ErrDeadlineExceeded) {
+		return
+	}
+
+	if f.timeouts.Add(1) != int64(f.Timeouts) {
+		return
+	}
+
+	// the connections fail, and the client reconnects elsewhere
+	go func() {
+		f.mu.Lock()
+		f.down = errTimingOut
+		conns := f.conns
+		f.conns = nil
+		f.mu.Unlock()
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+}
+
+// unmount unmounts the export from the server in use, giving up once ctx is
+// done
+func (f *failover) unmount(ctx context.Context) error {
+	f.mu.Lock()
+	addr := f.Addrs[f.cur]
+	f.mu.Unlock()
+
+	m, err := f.d.DialMount(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	return m.unmount(ctx, f.dirpath)
+}
@@ -0,0 +1,183 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs/rpc"
+)
+
+// serveMount answers the calls coming to l with the MNT reply granting fh
+func serveMount(l net.Listener, fh []byte) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go serveMountConn(conn, fh)
+	}
+}
+
+// serveMountConn answers the calls coming on conn with the MNT reply granting
+// fh
+func serveMountConn(conn net.Conn, fh []byte) {
+	defer conn.Close()
+
+	for {
+		var hdr uint32
+		if err := binary.Read(conn, binary.BigEndian, &hdr); err != nil {
+			return
+		}
+		call := make([]byte, hdr&0x7fffffff)
+		if _, err := io.ReadFull(conn, call); err != nil {
+			return
+		}
+
+		// an accepted reply with a null verifier, MNT3_OK, the handle and
+		// no flavors
+		buf := make([]byte, 40)
+		binary.BigEndian.PutUint32(buf, 36|0x80000000)
+		copy(buf[4:], call[:4])
+		binary.BigEndian.PutUint32(buf[8:], 1)
+		binary.BigEndian.PutUint32(buf[32:], uint32(len(fh)))
+		buf = append(buf[:36], fh...)
+		buf = append(buf, 0, 0, 0, 0)
+		conn.Write(buf)
+	}
+}
+
+// test dialing fails over to the next server when the first is down
+func TestFailoverDial(t *testing.T) {
+	mountL, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer mountL.Close()
+	go serveMount(mountL, []byte{1, 2, 3, 4})
+
+	nfsL, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nfsL.Close()
+	go func() {
+		for {
+			conn, err := nfsL.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	// nothing listens at the same ports of 127.0.0.1
+	d := Dialer{
+		MountPort: mountL.Addr().(*net.TCPAddr).Port,
+		NFSPort:   nfsL.Addr().(*net.TCPAddr).Port,
+		NoPortmap: true,
+	}
+
+	var failed []string
+	f := &failover{
+		Failover: Failover{
+			Addrs:   []string{"127.0.0.1", "127.0.0.2"},
+			OnError: func(addr string, err error) { failed = append(failed, addr) },
+		},
+		d:       d,
+		dirpath: "/export",
+	}
+
+	conn, err := f.dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if f.cur != 1 || string(f.fh) != "\x01\x02\x03\x04" {
+		t.Errorf("dialed server %d with root %x, want 1 with 01020304", f.cur, f.fh)
+	}
+	if len(failed) != 1 || failed[0] != "127.0.0.1" {
+		t.Errorf("failed servers = %v, want 127.0.0.1", failed)
+	}
+
+	// the calls timing out try the first server, still down, and come back
+	// to the second one
+	f.down = errTimingOut
+	conn2, err := f.dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.cur != 1 || len(failed) != 2 {
+		t.Errorf("dialed server %d after %v failed, want 1 after both tried", f.cur, failed)
+	}
+
+	// the connections closed are forgotten
+	conn2.Close()
+	if len(f.conns) != 1 {
+		t.Errorf("%d connections tracked, want 1", len(f.conns))
+	}
+}
+
+// test the calls timing out in a row close the connections to the server in
+// use, whether they time out after retransmitting or at their deadline
+func TestFailoverFail(t *testing.T) {
+	f := &failover{Failover: Failover{Timeouts: 3}}
+
+	var remote []net.Conn
+	for i := 0; i < 2; i++ {
+		client, server := net.Pipe()
+		defer server.Close()
+		f.conns = append(f.conns, client)
+		remote = append(remote, server)
+	}
+
+	f.fail(&rpc.TimeoutError{})
+	f.fail(os.ErrDeadlineExceeded)
+	f.fail(errors.New("not a timeout"))
+	f.mu.Lock()
+	if f.down != nil || len(f.conns) != 2 {
+		t.Errorf("failed over after 2 timeouts: down %v, %d connections", f.down, len(f.conns))
+	}
+	f.mu.Unlock()
+
+	f.fail(&os.PathError{Op: "read", Path: "file", Err: os.ErrDeadlineExceeded})
+	for _, conn := range remote {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("connection still open after 3 timeouts: %v", err)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down != errTimingOut || len(f.conns) != 0 {
+		t.Errorf("down %v with %d connections, want %v with none", f.down, len(f.conns), errTimingOut)
+	}
+}
+
+// test mounting over a connection established beforehand
+func TestDialMountConn(t *testing.T) {
+	client, server := net.Pipe()
+	go serveMountConn(server, []byte{1, 2, 3, 4})
+
+	m, err := (&Dialer{}).DialMountConn(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	fh, err := m.mnt(context.Background(), "/export", rpc.AuthNull)
+	if err != nil || string(fh) != "\x01\x02\x03\x04" {
+		t.Errorf("mnt = %x, %v, want 01020304", fh, err)
+	}
+}
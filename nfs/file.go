@@ -5,8 +5,13 @@ package nfs
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	_path "path"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-nfs/nfsv3/nfs/rpc"
 	"github.com/go-nfs/nfsv3/nfs/util"
@@ -15,9 +20,20 @@ import (
 
 // File wraps the NfsProc3Read and NfsProc3Write methods to implement a
 // io.ReadWriteCloser.
+//
+// ReadAt, WriteAt, ReadRanges, Stat, SetAttr, Sync and Commit may be called
+// concurrently, so a worker pool can share a single File for offset based I/O.
+// Concurrent writes to overlapping ranges are applied in no particular order.
+// Read, Write, Writev, Seek, ReadFrom and WriteTo share the current offset and
+// must not be called concurrently with one another, nor must the Set methods
+// configuring the File be called while I/O is in progress.
 type File struct {
 	*Target
 
+	// guards the state shared by concurrent offset based I/O: fattr, the
+	// write buffer, the read-ahead queue and sparseEnd
+	mu sync.Mutex
+
 	// current position
 	curr   uint64
 	fattr  *Fattr
@@ -25,6 +41,143 @@ type File struct {
 
 	// filehandle to the file
 	fh []byte
+
+	// base name of the path the file was opened by
+	name string
+
+	// os.O_* flags the file was opened with
+	flag int
+
+	// stability requested from the server for each WRITE
+	stable StableHow
+
+	// data written with Unstable that is yet to be committed
+	uc uncommitted
+
+	// set when writes are pipelined, with the limits set explicitly
+	pipe      *pipeline
+	maxWrites int
+	maxBytes  int
+
+	// set when small writes are gathered before being written
+	wb *writeBuffer
+
+	// set when reads are prefetched
+	ra *readahead
+
+	// set when Read fills the whole buffer
+	fill bool
+
+	// set when the attributes returned by READs are checked against fattr
+	validate bool
+
+	// the WRITEs issued so far and those in flight, and the order of the one
+	// whose attributes are cached, the latter under mu
+	writeSeq atomic.Uint64
+	writing  atomic.Int64
+	fattrSeq uint64
+
+	// set when blocks of zeros are not written, and the size the file has to
+	// be extended to when they were at its end
+	sparse    bool
+	sparseEnd uint64
+
+	// holder of the byte range locks taken through the file
+	lockOwner *lockOwner
+
+	// deadlines for READs and for WRITEs and COMMITs, as Unix nanoseconds, 0
+	// meaning none.  Accessed atomically since pipelined
+	// WRITEs run in the background.
+	readDeadline  int64
+	writeDeadline int64
+}
+
+// newFile wraps fh in a File with the default settings
+func (v *Target) newFile(fh []byte, fattr *Fattr) *File {
+	return &File{
+		Target: v,
+		fsinfo: v.fsinfo,
+		fattr:  fattr,
+		fh:     fh,
+		flag:   os.O_RDWR,
+		stable: FileSync,
+	}
+}
+
+var (
+	// ErrNotReadable is returned when reading from a File opened with
+	// os.O_WRONLY.
+	ErrNotReadable = errors.New("file not opened for reading")
+
+	// ErrNotWritable is returned when writing to a File opened with
+	// os.O_RDONLY.
+	ErrNotWritable = errors.New("file not opened for writing")
+)
+
+func (f *File) readable() bool {
+	return f.flag&(os.O_RDONLY|os.O_WRONLY|os.O_RDWR) != os.O_WRONLY
+}
+
+func (f *File) writable() bool {
+	return f.flag&(os.O_RDONLY|os.O_WRONLY|os.O_RDWR) != os.O_RDONLY
+}
+
+// SetStability sets how far the server must commit each WRITE before replying.
+// Files default to FileSync.  With Unstable or DataSync, data is only
+// guaranteed to be durable after Commit, Sync or Close.  Data the server
+// accepted as Unstable is kept until then, and written again should the write
+// verifier show that the server lost it.  Once more than four times the
+// amount set by SetMaxInFlightBytes is kept, the file is committed, which
+// bounds the memory it uses.
+func (f *File) SetStability(how StableHow) {
+	f.stable = how
+}
+
+// SetDeadline sets both the read and write deadlines, like net.Conn does.
+func (f *File) SetDeadline(t time.Time) error {
+	f.SetReadDeadline(t)
+	f.SetWriteDeadline(t)
+
+	return nil
+}
+
+// SetReadDeadline sets the time after which READs for this file fail with
+// os.ErrDeadlineExceeded, including one waiting for a reply from a stuck
+// server.  The zero time means no deadline.  A READ abandoned halfway through
+// its reply leaves the connection unusable.
+func (f *File) SetReadDeadline(t time.Time) error {
+	atomic.StoreInt64(&f.readDeadline, unixNano(t))
+
+	return nil
+}
+
+// SetWriteDeadline sets the time after which WRITEs and COMMITs for this file
+// fail with os.ErrDeadlineExceeded.  The zero time means no deadline.
+func (f *File) SetWriteDeadline(t time.Time) error {
+	atomic.StoreInt64(&f.writeDeadline, unixNano(t))
+
+	return nil
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+func loadDeadline(d *int64) time.Time {
+	if n := atomic.LoadInt64(d); n != 0 {
+		return time.Unix(0, n)
+	}
+
+	return time.Time{}
+}
+
+// call is Target.call bound by the write deadline
+func (f *File) call(c interface{}) (io.ReadSeeker, error) {
+	return f.callDeadline(c, loadDeadline(&f.writeDeadline))
 }
 
 // Readlink gets the target of a symlink
@@ -39,7 +192,7 @@ func (f *File) Readlink() (string, error) {
 		data []byte
 	}
 
-	r, err := f.call(&ReadlinkArgs{
+	r, err := f.callDeadline(&ReadlinkArgs{
 		Header: rpc.Header{
 			Rpcvers: 2,
 			Prog:    Nfs3Prog,
@@ -49,7 +202,7 @@ func (f *File) Readlink() (string, error) {
 			Verf:    rpc.AuthNull,
 		},
 		FH: f.fh,
-	})
+	}, loadDeadline(&f.readDeadline))
 
 	if err != nil {
 		util.Debugf("readlink(%x): %s", f.fh, err.Error())
@@ -69,6 +222,112 @@ func (f *File) Readlink() (string, error) {
 }
 
 func (f *File) Read(p []byte) (int, error) {
+	if !f.readable() {
+		return 0, ErrNotReadable
+	}
+
+	if err := f.drain(); err != nil {
+		return 0, err
+	}
+
+	return f.read(p)
+}
+
+// SetReadFull makes Read keep issuing READs until p is full or the end of the
+// file is reached, instead of returning after a single READ of at most the
+// server's preferred size.  This suits readers expecting fixed size records.
+func (f *File) SetReadFull(on bool) {
+	f.fill = on
+}
+
+// read reads from the current offset and advances it
+func (f *File) read(p []byte) (int, error) {
+	if !f.fill {
+		return f.readOnce(p)
+	}
+
+	n := 0
+	for n < len(p) {
+		m, err := f.readOnce(p[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+
+		if m == 0 {
+			return n, io.ErrNoProgress
+		}
+	}
+
+	return n, nil
+}
+
+// readOnce reads at most one chunk from the current offset and advances it
+func (f *File) readOnce(p []byte) (int, error) {
+	if f.ra != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		return f.ra.read(f, p)
+	}
+
+	n, eof, err := f.readAt(p, f.curr)
+	f.curr = f.curr + uint64(n)
+	if err == nil && eof {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// ReadAt reads len(p) bytes starting at byte offset off.  It neither uses nor
+// updates the offset used by Read and Write, so several goroutines may call
+// ReadAt on the same file.  This method implements the ReaderAt interface.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if !f.readable() {
+		return 0, ErrNotReadable
+	}
+
+	if off < 0 {
+		return 0, errors.New("offset cannot be negative")
+	}
+
+	if err := f.drain(); err != nil {
+		return 0, err
+	}
+
+	return f.readFullAt(p, uint64(off))
+}
+
+// readFullAt reads len(p) bytes at off, returning io.EOF when the file ends
+// first
+func (f *File) readFullAt(p []byte, off uint64) (int, error) {
+	n := 0
+	for n < len(p) {
+		m, eof, err := f.readAt(p[n:], off+uint64(n))
+		n += m
+		if err != nil {
+			return n, err
+		}
+
+		if eof {
+			if n < len(p) {
+				return n, io.EOF
+			}
+			break
+		}
+
+		if m == 0 {
+			return n, io.ErrNoProgress
+		}
+	}
+
+	return n, nil
+}
+
+// readAt issues a single READ for at most RTPref bytes of p at offset off and
+// reports whether the server flagged the end of the file.
+func (f *File) readAt(p []byte, off uint64) (int, bool, error) {
 	type ReadArgs struct {
 		rpc.Header
 		FH     []byte
@@ -86,9 +345,13 @@ func (f *File) Read(p []byte) (int, error) {
 	}
 
 	readSize := min(f.fsinfo.RTPref, uint32(len(p)))
-	util.Debugf("read(%x) len=%d offset=%d", f.fh, readSize, f.curr)
+	util.Debugf("read(%x) len=%d offset=%d", f.fh, readSize, off)
 
-	r, err := f.call(&ReadArgs{
+	// the data is read off the connection into p
+	var n int
+	var eof bool
+	seq := f.writes()
+	err := f.callInto(&ReadArgs{
 		Header: rpc.Header{
 			Rpcvers: 2,
 			Prog:    Nfs3Prog,
@@ -98,34 +361,206 @@ func (f *File) Read(p []byte) (int, error) {
 			Verf:    rpc.AuthNull,
 		},
 		FH:     f.fh,
-		Offset: uint64(f.curr),
+		Offset: off,
 		Count:  readSize,
+	}, loadDeadline(&f.readDeadline), func(r io.Reader) (err error) {
+		readres := &ReadRes{}
+		if err = xdr.Read(r, readres); err != nil {
+			return err
+		}
+
+		if f.validate && readres.Attr.IsSet {
+			if err = f.checkChanged(&readres.Attr.Attr, seq); err != nil {
+				return err
+			}
+		}
+
+		if readres.Data.Length > readSize {
+			return fmt.Errorf("read(%x): server returned %d bytes, requested %d", f.fh, readres.Data.Length, readSize)
+		}
+
+		n, err = io.ReadFull(r, p[:readres.Data.Length])
+		eof = readres.EOF != 0
+
+		return err
 	})
 
 	if err != nil {
 		util.Debugf("read(%x): %s", f.fh, err.Error())
-		return 0, err
+		return n, false, err
 	}
 
-	readres := &ReadRes{}
-	if err = xdr.Read(r, readres); err != nil {
-		return 0, err
+	return n, eof, nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	if !f.writable() {
+		return 0, ErrNotWritable
 	}
 
-	f.curr = f.curr + uint64(readres.Data.Length)
-	n, err := r.Read(p[:readres.Data.Length])
-	if err != nil {
-		return n, err
+	f.invalidate()
+
+	n, err := f.output(p, f.curr)
+	f.curr += uint64(n)
+
+	return n, err
+}
+
+// WriteAt writes len(p) bytes starting at byte offset off.  It neither uses
+// nor updates the offset used by Read and Write, which allows regions of the
+// file to be written out of order.  This method implements the WriterAt
+// interface.
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if !f.writable() {
+		return 0, ErrNotWritable
 	}
 
-	if readres.EOF != 0 {
-		err = io.EOF
+	if f.flag&os.O_APPEND != 0 {
+		return 0, errors.New("invalid use of WriteAt on file opened with O_APPEND")
 	}
 
-	return n, err
+	if off < 0 {
+		return 0, errors.New("offset cannot be negative")
+	}
+
+	f.invalidate()
+
+	return f.output(p, uint64(off))
 }
 
-func (f *File) Write(p []byte) (int, error) {
+// Writev writes the contents of buffers one after the other at the current
+// offset.  Small buffers are gathered into WRITEs of the server's preferred size,
+// so callers need not concatenate headers and bodies first.  Data of buffers
+// large enough to fill whole WRITEs is sent without copying.
+func (f *File) Writev(buffers [][]byte) (int64, error) {
+	if !f.writable() {
+		return 0, ErrNotWritable
+	}
+
+	f.invalidate()
+
+	pref := int(f.fsinfo.WTPref)
+	if pref < 1 {
+		pref = 1
+	}
+
+	total := int64(0)
+	flush := func(p []byte) error {
+		n, err := f.output(p, f.curr)
+		f.curr += uint64(n)
+		total += int64(n)
+
+		return err
+	}
+
+	var scratch []byte
+	for _, b := range buffers {
+		for len(b) > 0 {
+			if len(scratch) == 0 && len(b) >= pref {
+				whole := len(b) / pref * pref
+				if err := flush(b[:whole]); err != nil {
+					return total, err
+				}
+				b = b[whole:]
+				continue
+			}
+
+			if scratch == nil {
+				scratch = make([]byte, 0, pref)
+			}
+
+			n := pref - len(scratch)
+			if n > len(b) {
+				n = len(b)
+			}
+			scratch = append(scratch, b[:n]...)
+			b = b[n:]
+
+			if len(scratch) == pref {
+				if err := flush(scratch); err != nil {
+					return total, err
+				}
+				scratch = scratch[:0]
+			}
+		}
+	}
+
+	if len(scratch) > 0 {
+		if err := flush(scratch); err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// output writes p at offset off using the mode the file is set to
+func (f *File) output(p []byte, off uint64) (int, error) {
+	if f.wb != nil {
+		return f.wb.write(f, p, off)
+	}
+
+	return f.emit(p, off)
+}
+
+// emit writes p at offset off, bypassing the write buffer
+func (f *File) emit(p []byte, off uint64) (int, error) {
+	if f.sparse {
+		return f.outputSparse(p, off)
+	}
+
+	return f.issue(p, off)
+}
+
+// issue hands p to the pipeline or writes it right away
+func (f *File) issue(p []byte, off uint64) (int, error) {
+	if f.pipe != nil {
+		return f.pipe.write(f, p, off)
+	}
+
+	return f.writeAt(p, off)
+}
+
+// writeAt writes p at offset off in WTPref sized chunks
+func (f *File) writeAt(p []byte, off uint64) (int, error) {
+	totalToWrite := uint32(len(p))
+	written := uint32(0)
+
+	for written = 0; written < totalToWrite; {
+		writeSize := min(f.fsinfo.WTPref, totalToWrite-written)
+
+		chunk := p[written : written+writeSize]
+		count, committed, verf, err := f.write(chunk, off+uint64(written), f.stable)
+		if err != nil {
+			return int(written), err
+		}
+
+		if committed == Unstable {
+			// keep a copy, p belongs to the caller
+			f.uc.add(off+uint64(written), append([]byte(nil), chunk[:count]...), verf)
+		}
+
+		written += count
+
+		util.Debugf("write(%x) len=%d new_offset=%d written=%d total=%d", f.fh, totalToWrite, off+uint64(written), count, written)
+	}
+
+	if _, maxBytes := f.writeWindow(); f.uc.size() > uncommittedFactor*maxBytes {
+		verf, err := f.commit(0, 0)
+		if err == nil {
+			err = f.uc.committed(f, 0, 0, verf)
+		}
+		if err != nil {
+			return int(written), err
+		}
+	}
+
+	return int(written), nil
+}
+
+// write issues a single WRITE of p at offset off and returns how much the
+// server wrote, how far it committed it and its write verifier
+func (f *File) write(p []byte, off uint64, how StableHow) (uint32, StableHow, uint64, error) {
 	type WriteArgs struct {
 		rpc.Header
 		FH     []byte
@@ -133,66 +568,347 @@ func (f *File) Write(p []byte) (int, error) {
 		Count  uint32
 
 		// UNSTABLE(0), DATA_SYNC(1), FILE_SYNC(2) default
-		How      uint32
+		How      StableHow
 		Contents []byte
 	}
 
 	type WriteRes struct {
 		Wcc       WccData
 		Count     uint32
-		How       uint32
+		How       StableHow
 		WriteVerf uint64
 	}
 
-	totalToWrite := uint32(len(p))
-	written := uint32(0)
+	writeSize := uint32(len(p))
+	seq := f.startWrite()
+	res, err := f.call(&WriteArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    Nfs3Prog,
+			Vers:    Nfs3Vers,
+			Proc:    NFSProc3Write,
+			Cred:    f.auth,
+			Verf:    rpc.AuthNull,
+		},
+		FH:       f.fh,
+		Offset:   off,
+		Count:    writeSize,
+		How:      how,
+		Contents: p,
+	})
 
-	for written = 0; written < totalToWrite; {
-		writeSize := min(f.fsinfo.WTPref, totalToWrite-written)
+	if err != nil {
+		f.endWrite(seq, nil)
+		util.Errorf("write(%x): %s", f.fh, err.Error())
+		return 0, 0, 0, err
+	}
 
-		res, err := f.call(&WriteArgs{
-			Header: rpc.Header{
-				Rpcvers: 2,
-				Prog:    Nfs3Prog,
-				Vers:    Nfs3Vers,
-				Proc:    NFSProc3Write,
-				Cred:    f.auth,
-				Verf:    rpc.AuthNull,
-			},
-			FH:       f.fh,
-			Offset:   f.curr,
-			Count:    writeSize,
-			How:      2,
-			Contents: p[written : written+writeSize],
-		})
+	writeres := &WriteRes{}
+	if err = xdr.Read(res, writeres); err != nil {
+		f.endWrite(seq, nil)
+		util.Errorf("write(%x) failed to parse result: %s", f.fh, err.Error())
+		util.Debugf("write(%x) partial result: %+v", f.fh, writeres)
+		return 0, 0, 0, err
+	}
+
+	if writeres.Wcc.After.IsSet {
+		f.endWrite(seq, &writeres.Wcc.After.Attr)
+	} else {
+		f.endWrite(seq, nil)
+	}
+
+	if writeres.Count != writeSize {
+		util.Debugf("write(%x) did not write full data payload: sent: %d, written: %d", f.fh, writeSize, writeres.Count)
+	}
+
+	if writeres.Count == 0 {
+		return 0, 0, 0, io.ErrShortWrite
+	}
+
+	return writeres.Count, writeres.How, writeres.WriteVerf, nil
+}
+
+// ReadFrom writes the contents of r to the file at the current offset until
+// EOF, filling each WRITE up to the server's preferred size.  This method
+// implements the ReaderFrom interface used by io.Copy.
+func (f *File) ReadFrom(r io.Reader) (int64, error) {
+	if !f.writable() {
+		return 0, ErrNotWritable
+	}
+
+	buf := make([]byte, f.fsinfo.WTPref)
+	total := int64(0)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			written, werr := f.Write(buf[:n])
+			total += int64(written)
+			if werr != nil {
+				return total, werr
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
 
 		if err != nil {
-			util.Errorf("write(%x): %s", f.fh, err.Error())
-			return int(written), err
+			return total, err
 		}
+	}
+}
 
-		writeres := &WriteRes{}
-		if err = xdr.Read(res, writeres); err != nil {
-			util.Errorf("write(%x) failed to parse result: %s", f.fh, err.Error())
-			util.Debugf("write(%x) partial result: %+v", f.fh, writeres)
-			return int(written), err
+// WriteTo writes the file from the current offset to EOF to w, issuing READs
+// of the server's preferred size.  This method implements the WriterTo
+// interface used by io.Copy.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	if !f.readable() {
+		return 0, ErrNotReadable
+	}
+
+	if err := f.drain(); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, f.fsinfo.RTPref)
+	total := int64(0)
+	for {
+		n, err := f.read(buf)
+		if n > 0 {
+			written, werr := w.Write(buf[:n])
+			total += int64(written)
+			if werr != nil {
+				return total, werr
+			}
 		}
 
-		if writeres.Count != writeSize {
-			util.Debugf("write(%x) did not write full data payload: sent: %d, written: %d", writeSize, writeres.Count)
+		if err == io.EOF {
+			return total, nil
 		}
 
-		f.curr += uint64(writeres.Count)
-		written += writeres.Count
+		if err != nil {
+			return total, err
+		}
 
-		util.Debugf("write(%x) len=%d new_offset=%d written=%d total=%d", f.fh, totalToWrite, f.curr, writeres.Count, written)
+		if n == 0 {
+			return total, io.ErrNoProgress
+		}
 	}
+}
 
-	return int(written), nil
+// Stat returns the current attributes of the file, refreshing those cached by
+// the File.
+func (f *File) Stat() (os.FileInfo, error) {
+	if err := f.drain(); err != nil {
+		return nil, err
+	}
+
+	fattr, err := f.GetAttrFh(f.fh)
+	if err != nil {
+		util.Debugf("stat(%x): %s", f.fh, err.Error())
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.fattr = fattr
+	f.mu.Unlock()
+
+	return &fileInfo{name: f.name, Fattr: fattr}, nil
+}
+
+// Access returns which of the ACCESS3_* rights in mode the server grants on the
+// open file
+func (f *File) Access(mode uint32) (uint32, error) {
+	_, mode, err := f.access(f.fh, f.name, mode)
+	return mode, err
+}
+
+// Truncate changes the size of the file.  It does not change the offset used
+// by Read and Write.
+func (f *File) Truncate(size int64) error {
+	if !f.writable() {
+		return ErrNotWritable
+	}
+
+	if size < 0 {
+		return errors.New("size cannot be negative")
+	}
+
+	return f.SetAttr(Sattr3{
+		Size: SetSize{
+			SetIt: true,
+			Size:  uint64(size),
+		},
+	})
 }
 
-// Close commits the file
+// Preallocate extends the file to size bytes by writing zeros past its current
+// end, so that the space is reserved before the file is written at random
+// offsets.  The zeros are written in chunks of the server's preferred size,
+// pipelined when the file is, but a sparse file is merely extended with SETATTR.
+// A file of at least size bytes is left as it is.  The offset used by Read and
+// Write is not changed.
+func (f *File) Preallocate(size int64) error {
+	if !f.writable() {
+		return ErrNotWritable
+	}
+
+	if size < 0 {
+		return errors.New("size cannot be negative")
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() >= size {
+		return nil
+	}
+
+	if f.sparse {
+		return f.Truncate(size)
+	}
+
+	f.invalidate()
+
+	zeros := make([]byte, f.fsinfo.WTPref)
+	for off := uint64(info.Size()); off < uint64(size); {
+		n := uint64(len(zeros))
+		if n > uint64(size)-off {
+			n = uint64(size) - off
+		}
+
+		written, err := f.issue(zeros[:n], off)
+		if err != nil {
+			return err
+		}
+		off += uint64(written)
+	}
+
+	return f.drain()
+}
+
+// SetAttr changes the attributes selected in attr on the open file, without
+// looking its path up again
+func (f *File) SetAttr(attr Sattr3) error {
+	return f.setAttrGuard(attr, Guard{})
+}
+
+// SetAttrGuarded is like SetAttr, but only changes the attributes if the ctime
+// of the file still is ctime, such as from the *Fattr returned by Sys on the
+// result of Stat.  If another client changed the file since, it fails with an
+// error for which IsNotSyncError is true.
+func (f *File) SetAttrGuarded(attr Sattr3, ctime NFS3Time) error {
+	return f.setAttrGuard(attr, Guard{Check: true, Ctime: ctime})
+}
+
+func (f *File) setAttrGuard(attr Sattr3, guard Guard) error {
+	if err := f.drain(); err != nil {
+		return err
+	}
+
+	if attr.Size.SetIt {
+		f.invalidate()
+	}
+
+	fattr, err := f.Target.setAttrGuard(f.fh, attr, guard)
+	if err != nil {
+		util.Debugf("setattr(%x): %s", f.fh, err.Error())
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if fattr != nil {
+		f.fattr = fattr
+	} else if f.fattr != nil && attr.Size.SetIt {
+		// the cached attributes may have been handed out by Stat
+		updated := *f.fattr
+		updated.Filesize = attr.Size.Size
+		f.fattr = &updated
+	}
+
+	return nil
+}
+
+// Chmod changes the mode of the file
+func (f *File) Chmod(mode os.FileMode) error {
+	return f.SetAttr(chmodAttr(mode))
+}
+
+// Chown changes the owner and group of the file.  An id of -1 leaves it as
+// it is.
+func (f *File) Chown(uid, gid int) error {
+	return f.SetAttr(chownAttr(uid, gid))
+}
+
+// Chtimes changes the access and modification times of the file.  A zero time
+// leaves it as it is.
+func (f *File) Chtimes(atime, mtime time.Time) error {
+	return f.SetAttr(chtimesAttr(atime, mtime))
+}
+
+// Close commits the file, unless it was opened for reading only
 func (f *File) Close() error {
+	if !f.writable() {
+		return nil
+	}
+
+	return f.Sync()
+}
+
+// Sync commits data written so far to stable storage on the server.  The file
+// remains open and can be written to afterwards.
+func (f *File) Sync() error {
+	return f.Commit(0, 0)
+}
+
+// Commit asks the server to commit count bytes starting at offset to stable
+// storage.  A count of 0 commits everything from offset to the end of the file.
+// This allows writers using Unstable writes to batch commits.
+func (f *File) Commit(offset uint64, count uint32) error {
+	if err := f.drain(); err != nil {
+		return err
+	}
+
+	verf, err := f.commit(offset, count)
+	if err != nil {
+		return err
+	}
+
+	return f.uc.committed(f, offset, count, verf)
+}
+
+// invalidate drops data prefetched before the file is modified
+func (f *File) invalidate() {
+	if f.ra != nil {
+		f.mu.Lock()
+		f.ra.reset()
+		f.mu.Unlock()
+	}
+}
+
+// drain flushes the write buffer, waits for pipelined WRITEs still in flight
+// and extends a sparse file, so that a following request observes them
+func (f *File) drain() error {
+	if err := f.flush(); err != nil {
+		return err
+	}
+
+	if f.pipe != nil {
+		if err := f.pipe.wait(); err != nil {
+			return err
+		}
+	}
+
+	return f.extendSparse()
+}
+
+// commit issues a COMMIT for count bytes at offset, where a count of 0 means
+// through the end of the file, and returns the server's write verifier
+func (f *File) commit(offset uint64, count uint32) (uint64, error) {
 	type CommitArg struct {
 		rpc.Header
 		FH     []byte
@@ -200,7 +916,12 @@ func (f *File) Close() error {
 		Count  uint32
 	}
 
-	_, err := f.call(&CommitArg{
+	type CommitRes struct {
+		Wcc       WccData
+		WriteVerf uint64
+	}
+
+	res, err := f.call(&CommitArg{
 		Header: rpc.Header{
 			Rpcvers: 2,
 			Prog:    Nfs3Prog,
@@ -209,15 +930,23 @@ func (f *File) Close() error {
 			Cred:    f.auth,
 			Verf:    rpc.AuthNull,
 		},
-		FH: f.fh,
+		FH:     f.fh,
+		Offset: offset,
+		Count:  count,
 	})
 
 	if err != nil {
 		util.Debugf("commit(%x): %s", f.fh, err.Error())
-		return err
+		return 0, err
 	}
 
-	return nil
+	commitres := &CommitRes{}
+	if err = xdr.Read(res, commitres); err != nil {
+		util.Errorf("commit(%x) failed to parse result: %s", f.fh, err.Error())
+		return 0, err
+	}
+
+	return commitres.WriteVerf, nil
 }
 
 // Seek sets the offset for the next Read or Write to offset, interpreted according to whence.
@@ -227,46 +956,127 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	// It would be nice to try to validate the offset here.
 	// However, as we're working with the shared file system, the file
 	// size might even change between NFSPROC3_GETATTR call and
-	// Seek() call, so don't even try to validate it.
+	// Seek() call, so don't even try to validate it.  Only seeking
+	// relative to the end asks the server for the current size.
+	var pos int64
 	switch whence {
 	case io.SeekStart:
-		if offset < 0 {
-			return int64(f.curr), errors.New("offset cannot be negative")
-		}
-		f.curr = uint64(offset)
-		return int64(f.curr), nil
+		pos = offset
 	case io.SeekCurrent:
-		f.curr = uint64(int64(f.curr) + offset)
-		return int64(f.curr), nil
+		pos = int64(f.curr) + offset
 	case io.SeekEnd:
-		if f.curr < f.fattr.Filesize {
-			f.curr = f.fattr.Filesize
+		fi, err := f.Stat()
+		if err != nil {
+			return int64(f.curr), err
 		}
-		return int64(f.curr), nil
+		pos = fi.Size() + offset
 	default:
 		// This indicates serious programming error
 		return int64(f.curr), errors.New("Invalid whence")
 	}
+
+	if pos < 0 {
+		return int64(f.curr), errors.New("offset cannot be negative")
+	}
+
+	f.curr = uint64(pos)
+	return pos, nil
 }
 
-// OpenFile writes to an existing file or creates one
-func (v *Target) OpenFile(path string, perm os.FileMode) (*File, error) {
-	_, fh, err := v.Lookup(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			fh, err = v.Create(path, perm)
+// OpenFile opens the named file with the given os.O_* flags, mirroring
+// os.OpenFile.  With O_CREATE, a missing file is created with mode perm, and
+// adding O_EXCL makes OpenFile fail if the file already exists, using an
+// exclusive create where the server supports it.  O_TRUNC
+// truncates a writable file and O_APPEND positions the offset at the end of
+// the file.  Note that the server has no notion of appending, so concurrent
+// appenders may still overwrite each other.
+func (v *Target) OpenFile(path string, flag int, perm os.FileMode) (*File, error) {
+	var (
+		fattr   *Fattr
+		fh      []byte
+		created bool
+		err     error
+	)
+
+	if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		_, _, name, dirFh, err := v.lookupInner(v.fh, path, false)
+		if err != nil {
+			return nil, err
+		}
+
+		// EXCLUSIVE is safe against retransmitted requests, but is optional
+		// for servers to support.
+		fh, fattr, err = v.createExclusive(dirFh, name, perm, [NFS3_CREATEVERFSIZE]byte{})
+		if isNFS3Error(err, NFS3ErrNotSupp) {
+			fh, fattr, err = v.create(dirFh, name, createGuarded, Sattr3{
+				Mode: SetMode{
+					SetIt: true,
+					Mode:  uint32(perm.Perm()),
+				},
+			})
+		}
+		if err != nil {
+			util.Debugf("create(%s): %s", path, err.Error())
+			return nil, err
+		}
+		created = true
+	} else {
+		fattr, fh, _, _, err = v.lookupInner(v.fh, path, true)
+		if err != nil {
+			if !os.IsNotExist(err) || flag&os.O_CREATE == 0 {
+				return nil, err
+			}
+
+			_, _, name, dirFh, err := v.lookupInner(v.fh, path, false)
 			if err != nil {
 				return nil, err
 			}
-		} else {
+
+			fh, fattr, err = v.create(dirFh, name, createUnchecked, Sattr3{
+				Mode: SetMode{
+					SetIt: true,
+					Mode:  uint32(perm.Perm()),
+				},
+			})
+			if err != nil {
+				util.Debugf("create(%s): %s", path, err.Error())
+				return nil, err
+			}
+			created = true
+		}
+	}
+
+	f, err := v.openFlag(fh, fattr, flag, created)
+	if err != nil {
+		return nil, err
+	}
+	f.name = _path.Base(path)
+
+	return f, nil
+}
+
+// OpenFileByFh opens the file with the handle fh with the given os.O_* flags,
+// like OpenFile does for an existing file.  A File opened with os.O_RDONLY
+// cannot be written and sends no COMMIT when closed.
+func (v *Target) OpenFileByFh(fh []byte, flag int, fattr *Fattr) (*File, error) {
+	return v.openFlag(fh, fattr, flag, false)
+}
+
+// openFlag wraps fh in a File opened with flag, truncating it unless created
+func (v *Target) openFlag(fh []byte, fattr *Fattr, flag int, created bool) (*File, error) {
+	f := v.newFile(fh, fattr)
+	f.flag = flag
+
+	if flag&os.O_TRUNC != 0 && f.writable() && !created {
+		if err := f.Truncate(0); err != nil {
 			return nil, err
 		}
 	}
 
-	f := &File{
-		Target: v,
-		fsinfo: v.fsinfo,
-		fh:     fh,
+	if flag&os.O_APPEND != 0 {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			return nil, err
+		}
 	}
 
 	return f, nil
@@ -274,97 +1084,35 @@ func (v *Target) OpenFile(path string, perm os.FileMode) (*File, error) {
 
 // Open opens a file for reading
 func (v *Target) Open(path string) (*File, error) {
-	fattr, fh, _, _, err := v.lookupInner(v.fh, path, true, nil)
+	fattr, fh, _, _, err := v.lookupInner(v.fh, path, true)
 	if err != nil {
 		return nil, err
 	}
 
-	f := &File{
-		Target: v,
-		fsinfo: v.fsinfo,
-		fattr:  fattr,
-		fh:     fh,
-	}
+	f := v.newFile(fh, fattr)
+	f.flag = os.O_RDONLY
+	f.name = _path.Base(path)
 
 	return f, nil
 }
 
 // OpenByFh opens a file using file handle instead of path
 func (v *Target) OpenByFh(fh []byte, fattr *Fattr) (*File, error) {
-	f := &File{
-		Target: v,
-		fsinfo: v.fsinfo,
-		fattr:  fattr,
-		fh:     fh,
-	}
-
-	return f, nil
+	return v.newFile(fh, fattr), nil
 }
 
-// Symlink creates a symlink as where pointing to symlink
-func (v *Target) Symlink(where, symlink string) (*File, error) {
-	type symlinkdata3 struct {
-		SymlinkAttr Sattr3
-		SymlinkData []byte
-	}
-
-	type SymlinkArgs struct {
-		rpc.Header
-		Where   Diropargs3
-		Symlink symlinkdata3
-	}
-
-	type SymlinkRes struct {
-		obj     PostOpFH3
-		objAttr PostOpAttr
-		Wcc     WccData
-	}
-
-	_, _, symlinkName, fh, err := v.lookupInner(v.fh, symlink, false, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	r, err := v.call(&SymlinkArgs{
-		Header: rpc.Header{
-			Rpcvers: 2,
-			Prog:    Nfs3Prog,
-			Vers:    Nfs3Vers,
-			Proc:    NFSProc3Symlink,
-			Cred:    v.auth,
-			Verf:    rpc.AuthNull,
-		},
-		Where: Diropargs3{
-			FH:       fh,
-			Filename: symlinkName,
-		},
-		Symlink: symlinkdata3{
-			SymlinkAttr: Sattr3{},
-			SymlinkData: []byte(symlink),
-		},
-	})
-
+// SymlinkFile creates a symlink as where pointing to symlink and returns it as
+// a File.
+//
+// Deprecated: Use Symlink, which also takes the attributes of the link and
+// does not pretend that a symlink can be read and written like a file.
+func (v *Target) SymlinkFile(where, symlink string) (*File, error) {
+	fh, fattr, err := v.Symlink(symlink, where, nil)
 	if err != nil {
-		util.Debugf("Symlink(%s): %s", where, err.Error())
-		return nil, err
-	}
-
-	symlinkres := &SymlinkRes{}
-	if err = xdr.Read(r, symlinkres); err != nil {
 		return nil, err
 	}
 
-	if !symlinkres.obj.IsSet {
-		return nil, errors.New("fh not set")
-	}
-
-	symFile := &File{
-		Target: v,
-		fsinfo: v.fsinfo,
-		fh:     symlinkres.obj.FH,
-	}
-
-	return symFile, nil
+	return v.newFile(fh, fattr), nil
 }
 
 func min(x, y uint32) uint32 {
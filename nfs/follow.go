@@ -0,0 +1,97 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+// defaultFollowInterval is how often a TailReader polls by default
+const defaultFollowInterval = time.Second
+
+// TailReader reads a file that is being appended to, like tail -f.  Instead of
+// returning io.EOF it waits for the file to grow.
+type TailReader struct {
+	f        *File
+	ctx      context.Context
+	interval time.Duration
+}
+
+// Follow returns a reader continuing from the current offset of the file.  At
+// the end of the file the reader polls the file size with GETATTR every
+// interval, or every second for a non-positive interval, and blocks until more
+// data is appended or ctx is done, in which case it returns ctx.Err().  When the
+// file shrinks below the offset, as when a log is truncated on rotation,
+// reading starts over from the beginning of the file.
+func (f *File) Follow(ctx context.Context, interval time.Duration) *TailReader {
+	if interval <= 0 {
+		interval = defaultFollowInterval
+	}
+
+	return &TailReader{
+		f:        f,
+		ctx:      ctx,
+		interval: interval,
+	}
+}
+
+func (t *TailReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		if err := t.ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		n, err := t.f.Read(p)
+		if n > 0 {
+			return n, nil
+		}
+
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		if err = t.wait(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// wait polls until the file has grown beyond the current offset
+func (t *TailReader) wait() error {
+	timer := time.NewTimer(t.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		case <-timer.C:
+		}
+
+		fi, err := t.f.Stat()
+		if err != nil {
+			return err
+		}
+
+		size := uint64(fi.Size())
+		if size < t.f.curr {
+			util.Debugf("follow(%x): truncated to %d bytes, reading from the start", t.f.fh, size)
+			t.f.curr = 0
+		}
+
+		if size > t.f.curr {
+			return nil
+		}
+
+		timer.Reset(t.interval)
+	}
+}
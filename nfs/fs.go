@@ -0,0 +1,245 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	_path "path"
+	"sort"
+	"syscall"
+)
+
+// targetFS is the fs.FS returned by FS
+type targetFS struct {
+	v *Target
+}
+
+var (
+	_ fs.ReadDirFS   = (*targetFS)(nil)
+	_ fs.StatFS      = (*targetFS)(nil)
+	_ fs.ReadFileFS  = (*targetFS)(nil)
+	_ fs.GlobFS      = (*targetFS)(nil)
+	_ fs.SubFS       = (*targetFS)(nil)
+	_ fs.ReadDirFile = (*fsDir)(nil)
+	_ fs.File        = (*File)(nil)
+)
+
+// FS returns a read-only fs.FS serving the files of v, so that the export can
+// be handed to anything taking an io/fs file system, like http.FS,
+// template.ParseFS or fs.WalkDir.  Names are resolved with LOOKUP, following
+// symlinks, files are read with READ and directories listed with
+// READDIRPLUS.  The files returned by Open are *File values, and directories
+// implement fs.ReadDirFile.
+//
+// The file system also implements fs.ReadDirFS, fs.StatFS, fs.ReadFileFS,
+// fs.GlobFS and fs.SubFS, so that the helpers of io/fs go through a single
+// READDIRPLUS listing, LOOKUP or sequence of READs instead of opening files,
+// and the ReadLink and Lstat methods of fs.ReadLinkFS.  It passes
+// testing/fstest.TestFS on a well-behaved server, see nfstest.TestFS.
+func FS(v *Target) fs.FS {
+	return &targetFS{v: v}
+}
+
+func (fsys *targetFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	fattr, fh, err := fsys.lookup(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	if fattr.IsDir() {
+		return &fsDir{
+			v:     fsys.v,
+			path:  name,
+			fh:    fh,
+			fattr: fattr,
+			it:    fsys.v.ReadDirPlusIterByFh(fh),
+		}, nil
+	}
+
+	f := fsys.v.newFile(fh, fattr)
+	f.flag = os.O_RDONLY
+	f.name = _path.Base(name)
+
+	return f, nil
+}
+
+// ReadDir lists the directory name with READDIRPLUS, sorted by file name
+func (fsys *targetFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d, ok := f.(*fsDir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	entries, err := d.ReadDir(-1)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, err
+}
+
+// Stat returns the attributes the LOOKUP of name came back with
+func (fsys *targetFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	fattr, _, err := fsys.lookup(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return &fileInfo{name: _path.Base(name), Fattr: fattr}, nil
+}
+
+// Lstat is like Stat, but describes a symlink instead of its target
+func (fsys *targetFS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	fattr, _, err := fsys.v.lstat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: err}
+	}
+
+	return &fileInfo{name: _path.Base(name), Fattr: fattr}, nil
+}
+
+// ReadLink returns the target of the symlink name
+func (fsys *targetFS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	link, err := fsys.v.Readlink(name)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+
+	return link, nil
+}
+
+// ReadFile reads the file name sized by its attributes, see Target.ReadFile
+func (fsys *targetFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+
+	data, err := fsys.v.ReadFile(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	return data, nil
+}
+
+// Glob returns the names matching pattern, see Target.Glob
+func (fsys *targetFS) Glob(pattern string) ([]string, error) {
+	return fsys.v.Glob(pattern)
+}
+
+// Sub returns the file system rooted at dir, see Target.Sub
+func (fsys *targetFS) Sub(dir string) (fs.FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+
+	if dir == "." {
+		return fsys, nil
+	}
+
+	sub, err := fsys.v.Sub(dir)
+	if err != nil {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: err}
+	}
+
+	return FS(sub), nil
+}
+
+// lookup resolves a valid fs.FS name, fetching the attributes of the root
+func (fsys *targetFS) lookup(name string) (*Fattr, []byte, error) {
+	fattr, fh, _, _, err := fsys.v.lookupInner(fsys.v.fh, name, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if fattr == nil {
+		if fattr, err = fsys.v.GetAttrFh(fh); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return fattr, fh, nil
+}
+
+// fsDir is a directory opened through FS
+type fsDir struct {
+	v     *Target
+	path  string
+	fh    []byte
+	fattr *Fattr
+	it    *DirIterator
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return &fileInfo{name: _path.Base(d.path), Fattr: d.fattr}, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: syscall.EISDIR}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory, or all remaining ones
+// for n <= 0, like os.File.ReadDir.  Entries listed without attributes are
+// looked up.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	for n <= 0 || len(entries) < n {
+		if !d.it.Next() {
+			break
+		}
+
+		e := d.it.Entry()
+		if e.FileName == "." || e.FileName == ".." {
+			continue
+		}
+
+		fattr := &e.Attr.Attr
+		if !e.Attr.IsSet {
+			var err error
+			if fattr, _, _, err = d.v.lookup(d.fh, e.FileName); err != nil {
+				return entries, &fs.PathError{Op: "readdir", Path: d.path, Err: err}
+			}
+		}
+
+		entries = append(entries, &dirEntry{name: e.FileName, fattr: fattr})
+	}
+
+	if err := d.it.Err(); err != nil {
+		return entries, &fs.PathError{Op: "readdir", Path: d.path, Err: err}
+	}
+
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+
+	return entries, nil
+}
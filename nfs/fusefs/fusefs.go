@@ -0,0 +1,437 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Package fusefs mounts an NFS target through FUSE, forwarding the operations
+// of the kernel to the NFS client.  It gives containers and other unprivileged
+// environments without mount.nfs a userspace NFS mount.
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/util"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Mount mounts the export of v at dir and returns the FUSE server, which is
+// serving requests once Mount returns.  Unmount the file system with the
+// server's Unmount and Wait for it to exit before closing v.  A nil opts
+// selects the defaults of go-fuse.
+func Mount(dir string, v *nfs.Target, opts *fs.Options) (*fuse.Server, error) {
+	_, fh, err := v.Lookup(".")
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.Mount(dir, Root(v, fh), opts)
+}
+
+// Root returns the node for the directory with the handle fh, to be mounted
+// with fs.Mount
+func Root(v *nfs.Target, fh []byte) fs.InodeEmbedder {
+	return &node{v: v, fh: fh}
+}
+
+// node is a file or directory of the target, identified by its handle
+type node struct {
+	fs.Inode
+
+	v  *nfs.Target
+	fh []byte
+}
+
+var (
+	_ fs.NodeGetattrer  = (*node)(nil)
+	_ fs.NodeSetattrer  = (*node)(nil)
+	_ fs.NodeLookuper   = (*node)(nil)
+	_ fs.NodeReaddirer  = (*node)(nil)
+	_ fs.NodeOpener     = (*node)(nil)
+	_ fs.NodeCreater    = (*node)(nil)
+	_ fs.NodeMkdirer    = (*node)(nil)
+	_ fs.NodeSymlinker  = (*node)(nil)
+	_ fs.NodeLinker     = (*node)(nil)
+	_ fs.NodeReadlinker = (*node)(nil)
+	_ fs.NodeUnlinker   = (*node)(nil)
+	_ fs.NodeRmdirer    = (*node)(nil)
+	_ fs.NodeRenamer    = (*node)(nil)
+	_ fs.NodeStatfser   = (*node)(nil)
+)
+
+// errnos holds the error numbers for the NFS3 status codes, which follow the
+// numbering of old BSD systems rather than that of the host
+var errnos = map[uint32]syscall.Errno{
+	nfs.NFS3ErrPerm:        syscall.EPERM,
+	nfs.NFS3ErrNoEnt:       syscall.ENOENT,
+	nfs.NFS3ErrIO:          syscall.EIO,
+	nfs.NFS3ErrNXIO:        syscall.ENXIO,
+	nfs.NFS3ErrAcces:       syscall.EACCES,
+	nfs.NFS3ErrExist:       syscall.EEXIST,
+	nfs.NFS3ErrXDev:        syscall.EXDEV,
+	nfs.NFS3ErrNoDev:       syscall.ENODEV,
+	nfs.NFS3ErrNotDir:      syscall.ENOTDIR,
+	nfs.NFS3ErrIsDir:       syscall.EISDIR,
+	nfs.NFS3ErrInval:       syscall.EINVAL,
+	nfs.NFS3ErrFBig:        syscall.EFBIG,
+	nfs.NFS3ErrNoSpc:       syscall.ENOSPC,
+	nfs.NFS3ErrROFS:        syscall.EROFS,
+	nfs.NFS3ErrMLink:       syscall.EMLINK,
+	nfs.NFS3ErrNameTooLong: syscall.ENAMETOOLONG,
+	nfs.NFS3ErrNotEmpty:    syscall.ENOTEMPTY,
+	nfs.NFS3ErrDQuot:       syscall.EDQUOT,
+	nfs.NFS3ErrStale:       syscall.ESTALE,
+	nfs.NFS3ErrRemote:      syscall.EREMOTE,
+	nfs.NFS3ErrBadHandle:   syscall.ESTALE,
+	nfs.NFS3ErrNotSupp:     syscall.ENOTSUP,
+	nfs.NFS3ErrTooSmall:    syscall.ERANGE,
+	nfs.NFS3ErrBadType:     syscall.ENOTSUP,
+}
+
+// errno maps an error of the client to the error number reported to the
+// kernel
+func errno(err error) syscall.Errno {
+	var nfsErr *nfs.Error
+	if !errors.As(err, &nfsErr) {
+		return fs.ToErrno(err)
+	}
+
+	if e, ok := errnos[nfsErr.ErrorNum]; ok {
+		return e
+	}
+
+	return syscall.EIO
+}
+
+// fileType returns the S_IF* bits for an NFS file type
+func fileType(ftype uint32) uint32 {
+	switch ftype {
+	case nfs.NF3Dir:
+		return syscall.S_IFDIR
+	case nfs.NF3Blk:
+		return syscall.S_IFBLK
+	case nfs.NF3Chr:
+		return syscall.S_IFCHR
+	case nfs.NF3Lnk:
+		return syscall.S_IFLNK
+	case nfs.NF3Sock:
+		return syscall.S_IFSOCK
+	case nfs.NF3FIFO:
+		return syscall.S_IFIFO
+	}
+
+	return syscall.S_IFREG
+}
+
+// fillAttr converts the attributes of an NFS file to those of the kernel
+func fillAttr(out *fuse.Attr, fattr *nfs.Fattr) {
+	out.Ino = fattr.Fileid
+	out.Size = fattr.Filesize
+	out.Blocks = (fattr.Used + 511) / 512
+	out.Atime, out.Atimensec = uint64(fattr.Atime.Seconds), fattr.Atime.Nseconds
+	out.Mtime, out.Mtimensec = uint64(fattr.Mtime.Seconds), fattr.Mtime.Nseconds
+	out.Ctime, out.Ctimensec = uint64(fattr.Ctime.Seconds), fattr.Ctime.Nseconds
+	out.Mode = fileType(fattr.Type) | fattr.FileMode&0o7777
+	out.Nlink = fattr.Nlink
+	out.Uid, out.Gid = fattr.UID, fattr.GID
+	out.Rdev = uint32(unixDev(fattr.SpecData[0], fattr.SpecData[1]))
+}
+
+// unixDev encodes a device number like makedev(3)
+func unixDev(major, minor uint32) uint64 {
+	return uint64(minor&0xff) | uint64(major&0xfff)<<8 |
+		uint64(minor&^0xff)<<12 | uint64(major&^0xfff)<<32
+}
+
+// child returns the inode for a file found in or created in the directory n
+func (n *node) child(ctx context.Context, fh []byte, fattr *nfs.Fattr, out *fuse.EntryOut) *fs.Inode {
+	fillAttr(&out.Attr, fattr)
+
+	return n.NewInode(ctx, &node{v: n.v, fh: fh}, fs.StableAttr{
+		Mode: fileType(fattr.Type),
+		Ino:  fattr.Fileid,
+	})
+}
+
+// created fetches the attributes of a file just created, when the server did
+// not return them, and returns its inode
+func (n *node) created(ctx context.Context, fh []byte, fattr *nfs.Fattr, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if fattr == nil {
+		var err error
+		if fattr, err = n.v.GetAttrByFh(fh); err != nil {
+			return nil, errno(err)
+		}
+	}
+
+	return n.child(ctx, fh, fattr, out), fs.OK
+}
+
+func (n *node) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	fattr, err := n.v.GetAttrByFh(n.fh)
+	if err != nil {
+		return errno(err)
+	}
+
+	fillAttr(&out.Attr, fattr)
+	return fs.OK
+}
+
+func (n *node) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	var attr nfs.Sattr3
+	if mode, ok := in.GetMode(); ok {
+		attr.Mode = nfs.SetMode{SetIt: true, Mode: mode & 0o7777}
+	}
+	if uid, ok := in.GetUID(); ok {
+		attr.UID = nfs.SetUID{SetIt: true, UID: uid}
+	}
+	if gid, ok := in.GetGID(); ok {
+		attr.GID = nfs.SetUID{SetIt: true, UID: gid}
+	}
+	if size, ok := in.GetSize(); ok {
+		attr.Size = nfs.SetSize{SetIt: true, Size: size}
+	}
+	if atime, ok := in.GetATime(); ok {
+		attr.Atime = setTime(atime)
+	}
+	if mtime, ok := in.GetMTime(); ok {
+		attr.Mtime = setTime(mtime)
+	}
+
+	// a change of size through an open file goes through it, for the data it
+	// buffers to be written before
+	var err error
+	if h, ok := f.(*handle); ok && attr.Size.SetIt {
+		err = h.f.SetAttr(attr)
+	} else {
+		err = n.v.SetAttrByFh(n.fh, attr)
+	}
+	if err != nil {
+		return errno(err)
+	}
+
+	return n.Getattr(ctx, f, out)
+}
+
+func setTime(t time.Time) nfs.SetTime {
+	return nfs.SetTime{
+		SetIt: nfs.SetToClientTime,
+		Time: nfs.NFS3Time{
+			Seconds:  uint32(t.Unix()),
+			Nseconds: uint32(t.Nanosecond()),
+		},
+	}
+}
+
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	fattr, fh, err := n.v.LookupByParentFh(n.fh, name)
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	return n.child(ctx, fh, fattr, out), fs.OK
+}
+
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	var entries []fuse.DirEntry
+
+	it := n.v.ReadDirPlusIterByFh(n.fh)
+	for it.Next() {
+		e := it.Entry()
+		if e.FileName == "." || e.FileName == ".." {
+			continue
+		}
+
+		entry := fuse.DirEntry{Name: e.FileName, Ino: e.FileId}
+		if e.Attr.IsSet {
+			entry.Mode = fileType(e.Attr.Attr.Type)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := it.Err(); err != nil {
+		return nil, errno(err)
+	}
+
+	return fs.NewListDirStream(entries), fs.OK
+}
+
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	return n.open(n.fh, flags)
+}
+
+// open opens the file fh with the access mode of the open flags.  A handle
+// opened for reading only sends no COMMIT, which servers refuse without write
+// access to the file.
+func (n *node) open(fh []byte, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	mode := int(flags & syscall.O_ACCMODE)
+	f, err := n.v.OpenFileByFh(fh, mode, nil)
+	if err != nil {
+		return nil, 0, errno(err)
+	}
+
+	return &handle{f: f, writable: mode != os.O_RDONLY}, 0, fs.OK
+}
+
+func (n *node) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	fh, err := n.v.CreateByFh(n.fh, name, os.FileMode(mode&0o777))
+	if err != nil {
+		return nil, nil, 0, errno(err)
+	}
+
+	inode, e := n.created(ctx, fh, nil, out)
+	if e != fs.OK {
+		return nil, nil, 0, e
+	}
+
+	h, _, e := n.open(fh, flags)
+	if e != fs.OK {
+		return nil, nil, 0, e
+	}
+
+	return inode, h, 0, fs.OK
+}
+
+func (n *node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	fh, err := n.v.MkdirByParentFh(n.fh, name, os.FileMode(mode&0o777))
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	return n.created(ctx, fh, nil, out)
+}
+
+func (n *node) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	fh, fattr, err := n.v.SymlinkByParentFh(n.fh, name, target, nil)
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	return n.created(ctx, fh, fattr, out)
+}
+
+func (n *node) Link(ctx context.Context, target fs.InodeEmbedder, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	t, ok := target.(*node)
+	if !ok {
+		return nil, syscall.EXDEV
+	}
+
+	if err := n.v.LinkByFh(t.fh, n.fh, name); err != nil {
+		return nil, errno(err)
+	}
+
+	return n.created(ctx, t.fh, nil, out)
+}
+
+func (n *node) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	target, err := n.v.ReadlinkByFh(n.fh)
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	return []byte(target), fs.OK
+}
+
+func (n *node) Unlink(ctx context.Context, name string) syscall.Errno {
+	return errno(n.v.RemoveByParentFh(n.fh, name))
+}
+
+func (n *node) Rmdir(ctx context.Context, name string) syscall.Errno {
+	return errno(n.v.RmDirByParentFh(n.fh, name))
+}
+
+// Rename moves name to newName in newParent.  NFS has no notion of the
+// RENAME_NOREPLACE and RENAME_EXCHANGE flags, which are refused.
+func (n *node) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	to, ok := newParent.(*node)
+	if !ok {
+		return syscall.EXDEV
+	}
+
+	if flags != 0 {
+		return syscall.EINVAL
+	}
+
+	return errno(n.v.RenameByFh(n.fh, name, to.fh, newName))
+}
+
+func (n *node) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	st, err := n.v.FSStat()
+	if err != nil {
+		return errno(err)
+	}
+
+	const bsize = 4096
+	out.Bsize, out.Frsize = bsize, bsize
+	out.Blocks = st.TBytes / bsize
+	out.Bfree = st.FBytes / bsize
+	out.Bavail = st.ABytes / bsize
+	out.Files = st.TFiles
+	out.Ffree = st.AFiles
+	out.NameLen = 255
+
+	return fs.OK
+}
+
+// handle is an open file
+type handle struct {
+	f *nfs.File
+
+	// set unless the file was opened for reading only
+	writable bool
+}
+
+var (
+	_ fs.FileReader   = (*handle)(nil)
+	_ fs.FileWriter   = (*handle)(nil)
+	_ fs.FileFlusher  = (*handle)(nil)
+	_ fs.FileFsyncer  = (*handle)(nil)
+	_ fs.FileReleaser = (*handle)(nil)
+)
+
+func (h *handle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := h.f.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, errno(err)
+	}
+
+	return fuse.ReadResultData(dest[:n]), fs.OK
+}
+
+func (h *handle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	n, err := h.f.WriteAt(data, off)
+	if err != nil {
+		return uint32(n), errno(err)
+	}
+
+	return uint32(n), fs.OK
+}
+
+func (h *handle) Flush(ctx context.Context) syscall.Errno {
+	if !h.writable {
+		return fs.OK
+	}
+
+	return errno(h.f.Sync())
+}
+
+func (h *handle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	if !h.writable {
+		return fs.OK
+	}
+
+	return errno(h.f.Sync())
+}
+
+func (h *handle) Release(ctx context.Context) syscall.Errno {
+	if err := h.f.Close(); err != nil {
+		util.Errorf("release: %s", err.Error())
+		return errno(err)
+	}
+
+	return fs.OK
+}
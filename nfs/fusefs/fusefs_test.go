@@ -0,0 +1,135 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package fusefs
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/nfstest"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestUnixDev(t *testing.T) {
+	tests := []struct {
+		major, minor uint32
+		want         uint64
+	}{
+		{0, 0, 0},
+		{8, 1, 0x801},
+		{259, 0x12345, 0x12310345},
+	}
+
+	for _, tt := range tests {
+		if got := unixDev(tt.major, tt.minor); got != tt.want {
+			t.Errorf("unixDev(%d, %d) = %#x, want %#x", tt.major, tt.minor, got, tt.want)
+		}
+	}
+}
+
+func TestErrno(t *testing.T) {
+	tests := []struct {
+		err  error
+		want syscall.Errno
+	}{
+		{nil, 0},
+		{os.ErrNotExist, syscall.ENOENT},
+		{nfs.NFS3Error(nfs.NFS3ErrNotEmpty), syscall.ENOTEMPTY},
+		{nfs.NFS3Error(nfs.NFS3ErrStale), syscall.ESTALE},
+		{&os.PathError{Op: "lookup", Path: "a", Err: nfs.NFS3Error(nfs.NFS3ErrNotSupp)}, syscall.ENOTSUP},
+	}
+
+	for _, tt := range tests {
+		if got := errno(tt.err); got != tt.want {
+			t.Errorf("errno(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// test truncating an open file writes the data it buffers before
+func TestSetattrHandle(t *testing.T) {
+	_, v := nfstest.Mount(t)
+
+	f, err := v.OpenFile("file", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.SetBuffered(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("buffered")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, fh, err := v.Lookup("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := &node{v: v, fh: fh}
+	in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{Valid: fuse.FATTR_SIZE, Size: 3}}
+	if errno := n.Setattr(context.Background(), &handle{f: f}, in, &fuse.AttrOut{}); errno != 0 {
+		t.Fatal(errno)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := v.ReadFile("file"); string(data) != "buf" || err != nil {
+		t.Errorf("file holds %q, %v, want buf", data, err)
+	}
+}
+
+// test closing a file opened for reading only sends no COMMIT, unlike closing
+// one opened for writing
+func TestOpenReadOnly(t *testing.T) {
+	s, v := nfstest.Mount(t)
+
+	if err := v.WriteFile("file", []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, fh, err := v.Lookup("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := &node{v: v, fh: fh}
+	ctx := context.Background()
+	commits := s.Calls(nfs.NFSProc3Commit)
+	tests := []struct {
+		flags   uint32
+		commits int
+	}{
+		{syscall.O_RDONLY, 0},
+		{syscall.O_RDWR, 2},
+	}
+
+	for _, tt := range tests {
+		fh, _, errno := n.Open(ctx, tt.flags)
+		if errno != 0 {
+			t.Fatal(errno)
+		}
+		h := fh.(*handle)
+
+		buf := make([]byte, 4)
+		if _, errno = h.Read(ctx, buf, 0); errno != 0 {
+			t.Fatal(errno)
+		}
+		if errno = h.Flush(ctx); errno != 0 {
+			t.Fatal(errno)
+		}
+		if errno = h.Release(ctx); errno != 0 {
+			t.Fatal(errno)
+		}
+
+		got := s.Calls(nfs.NFSProc3Commit) - commits
+		if got != tt.commits {
+			t.Errorf("flags %#o: %d COMMITs, want %d", tt.flags, got, tt.commits)
+		}
+		commits += got
+	}
+}
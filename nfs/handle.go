@@ -0,0 +1,122 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/go-nfs/nfsv3/nfs/xdr"
+)
+
+// handleVersion is the version of the encoding of Handle
+const handleVersion = 1
+
+// Handle identifies a file along with the export it belongs to.  It can be
+// serialized, stored and given to Target.OpenHandle later, possibly in another
+// process, to reopen the file without looking its path up.
+type Handle struct {
+	// Export is the path the export was mounted by
+	Export string
+	// Root is the filehandle of the root of the export
+	Root []byte
+	// FH is the filehandle of the file
+	FH []byte
+}
+
+type handleEncoding struct {
+	Version uint32
+	Export  string
+	Root    []byte
+	FH      []byte
+}
+
+// Handle returns a token to reopen the file with Target.OpenHandle
+func (f *File) Handle() Handle {
+	return Handle{
+		Export: f.dirPath,
+		Root:   append([]byte(nil), f.Target.fh...),
+		FH:     append([]byte(nil), f.fh...),
+	}
+}
+
+// MarshalBinary encodes the handle.  This method implements the
+// encoding.BinaryMarshaler interface.
+func (h Handle) MarshalBinary() ([]byte, error) {
+	w := new(bytes.Buffer)
+	err := xdr.Write(w, &handleEncoding{
+		Version: handleVersion,
+		Export:  h.Export,
+		Root:    h.Root,
+		FH:      h.FH,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return w.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a handle encoded by MarshalBinary.  This method
+// implements the encoding.BinaryUnmarshaler interface.
+func (h *Handle) UnmarshalBinary(data []byte) error {
+	enc := new(handleEncoding)
+	if err := xdr.Read(bytes.NewReader(data), enc); err != nil {
+		return err
+	}
+
+	if enc.Version != handleVersion {
+		return fmt.Errorf("unsupported handle version %d", enc.Version)
+	}
+
+	if len(enc.FH) == 0 {
+		return fmt.Errorf("handle has no filehandle")
+	}
+
+	h.Export, h.Root, h.FH = enc.Export, enc.Root, enc.FH
+	return nil
+}
+
+// MarshalText encodes the handle as URL safe base64.  This method implements
+// the encoding.TextMarshaler interface.
+func (h Handle) MarshalText() ([]byte, error) {
+	b, err := h.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	text := make([]byte, base64.RawURLEncoding.EncodedLen(len(b)))
+	base64.RawURLEncoding.Encode(text, b)
+
+	return text, nil
+}
+
+// UnmarshalText decodes a handle encoded by MarshalText.  This method
+// implements the encoding.TextUnmarshaler interface.
+func (h *Handle) UnmarshalText(text []byte) error {
+	b := make([]byte, base64.RawURLEncoding.DecodedLen(len(text)))
+	n, err := base64.RawURLEncoding.Decode(b, text)
+	if err != nil {
+		return err
+	}
+
+	return h.UnmarshalBinary(b[:n])
+}
+
+// OpenHandle reopens a file from a Handle.  The handle must come from the same
+// export as the target, and is checked with the server, which fails with
+// NFS3ERR_STALE if the file no longer exists.
+func (v *Target) OpenHandle(h Handle) (*File, error) {
+	if h.Export != v.dirPath || !sameHandle(h.Root, v.fh) {
+		return nil, fmt.Errorf("handle belongs to export %q, not %q", h.Export, v.dirPath)
+	}
+
+	fattr, err := v.GetAttrFh(h.FH)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.newFile(h.FH, fattr), nil
+}
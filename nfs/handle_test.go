@@ -0,0 +1,43 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/go-nfs/nfsv3/nfs/xdr"
+)
+
+func TestHandleRoundTrip(t *testing.T) {
+	h := Handle{
+		Export: "/export/data",
+		Root:   []byte{1, 2, 3, 4, 5},
+		FH:     []byte{6, 7, 8},
+	}
+
+	text, err := h.MarshalText()
+	if err != nil {
+		t.Fatalf("marshal: %s", err.Error())
+	}
+
+	var out Handle
+	if err = out.UnmarshalText(text); err != nil {
+		t.Fatalf("unmarshal: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(h, out) {
+		t.Fatalf("expected %+v, got %+v", h, out)
+	}
+
+	w := new(bytes.Buffer)
+	if err = xdr.Write(w, &handleEncoding{Version: handleVersion + 1, FH: h.FH}); err != nil {
+		t.Fatalf("encode: %s", err.Error())
+	}
+
+	if err = out.UnmarshalBinary(w.Bytes()); err == nil {
+		t.Fatalf("expected an error for an unknown version")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	_path "path"
+	"strings"
+)
+
+// httpHandler is the http.Handler returned by HTTPFileServer
+type httpHandler struct {
+	fsys *targetFS
+	dirs http.Handler
+}
+
+// HTTPFileServer returns a handler serving the files of v over HTTP, like
+// http.FileServer.  Files are served with http.ServeContent, so responses carry
+// Content-Length and Last-Modified, conditional requests are honoured and byte
+// ranges are read with READs at their offsets, read ahead for larger files.
+// The ETag is derived from the file id and modification time of the file.
+// Directories are listed by http.FileServer, which prefers their index.html.
+func HTTPFileServer(v *Target) http.Handler {
+	fsys := &targetFS{v: v}
+
+	return &httpHandler{
+		fsys: fsys,
+		dirs: http.FileServer(http.FS(fsys)),
+	}
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(_path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+
+	fattr, fh, err := h.fsys.lookup(name)
+	if err != nil {
+		msg, code := httpError(err)
+		http.Error(w, msg, code)
+		return
+	}
+
+	if fattr.IsDir() {
+		h.dirs.ServeHTTP(w, r)
+		return
+	}
+
+	if fattr.Type != NF3Reg {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+
+	f := h.fsys.v.newFile(fh, fattr)
+	f.flag = os.O_RDONLY
+	f.name = _path.Base(name)
+	defer f.Close()
+
+	if fattr.Filesize > uint64(f.fsinfo.RTPref) {
+		f.SetReadAhead(readFileAhead)
+	}
+
+	w.Header().Set("Etag", fmt.Sprintf(`"%x-%x.%x"`, fattr.Fileid, fattr.Mtime.Seconds, fattr.Mtime.Nseconds))
+	http.ServeContent(w, r, f.name, fattr.ModTime(), f)
+}
+
+// httpError maps an error looking up a file to an HTTP status, without
+// revealing the details to the client
+func httpError(err error) (string, int) {
+	switch {
+	case os.IsNotExist(err):
+		return "404 page not found", http.StatusNotFound
+	case os.IsPermission(err):
+		return "403 Forbidden", http.StatusForbidden
+	}
+
+	return "500 Internal Server Error", http.StatusInternalServerError
+}
@@ -0,0 +1,167 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	_path "path"
+	"strings"
+
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+// readFileAhead is the read-ahead ReadFile uses for files larger than a READ
+const readFileAhead = 4
+
+// maxReadFileHint is the most ReadFile allocates up front for the size the
+// server reports, the buffer growing past it as the data comes, so that a
+// bogus or sparse size does not allocate everything at once
+const maxReadFileHint = 64 << 20
+
+// ReadFile reads the whole file at path, like os.ReadFile.  Files larger than
+// the server's preferred READ size are read with several READs in flight.
+func (v *Target) ReadFile(path string) ([]byte, error) {
+	f, err := v.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() > int64(f.fsinfo.RTPref) {
+		f.SetReadAhead(readFileAhead)
+	}
+
+	var buf bytes.Buffer
+	size := info.Size()
+	if size < 0 || size > maxReadFileHint {
+		// beyond MaxInt64 for a negative size
+		size = maxReadFileHint
+	}
+	buf.Grow(int(size))
+	if _, err = f.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteFile writes data to the file at path, creating it with perm if needed
+// and truncating it otherwise, like os.WriteFile.  Data fitting into a single
+// WRITE is written synchronously; more is sent as pipelined Unstable WRITEs of
+// the server's preferred size, committed by a single COMMIT.
+func (v *Target) WriteFile(path string, data []byte, perm os.FileMode) error {
+	f, err := v.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > int(f.fsinfo.WTPref) {
+		err = f.SetPipelined(true)
+	}
+
+	if err == nil {
+		_, err = f.Write(data)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// WriteFileAtomic writes the contents of r to a temporary file next to path
+// and renames it over path once everything is committed, so other clients
+// either see the previous file or the complete new one.  The temporary file is
+// removed if anything fails.
+func (v *Target) WriteFileAtomic(path string, r io.Reader, perm os.FileMode) error {
+	dir, name := _path.Split(path)
+
+	f, tmp, err := v.createTemp(dir, "."+name+".tmp*", perm)
+	if err != nil {
+		return err
+	}
+
+	if err = f.SetPipelined(true); err == nil {
+		_, err = io.Copy(f, r)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = v.Rename(tmp, path)
+	}
+
+	if err != nil {
+		if rerr := v.Remove(tmp); rerr != nil {
+			util.Errorf("error removing %s: %s", tmp, rerr.Error())
+		}
+		return err
+	}
+
+	return nil
+}
+
+// createTempAttempts is how many names CreateTemp tries
+const createTempAttempts = 100
+
+// CreateTemp creates a new file in the directory dir, opened for reading and
+// writing, and returns it along with its path, like os.CreateTemp.  The name is
+// pattern with its last "*" replaced by a random string, or with one appended
+// when there is no "*".  Each candidate is created with an exclusive CREATE, so
+// concurrent callers on any client never get the same file.  The file is
+// created with mode 0600; removing it is up to the caller.
+func (v *Target) CreateTemp(dir, pattern string) (*File, string, error) {
+	return v.createTemp(dir, pattern, 0o600)
+}
+
+func (v *Target) createTemp(dir, pattern string, perm os.FileMode) (*File, string, error) {
+	if strings.ContainsRune(pattern, '/') {
+		return nil, "", &os.PathError{Op: "createtemp", Path: pattern, Err: errors.New("pattern contains path separator")}
+	}
+
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+
+	var err error
+	for i := 0; i < createTempAttempts; i++ {
+		name := _path.Join(dir, prefix+randomSuffix()+suffix)
+
+		var f *File
+		f, err = v.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if err == nil {
+			return f, name, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, "", err
+		}
+	}
+
+	return nil, "", &os.PathError{Op: "createtemp", Path: _path.Join(dir, pattern), Err: err}
+}
+
+// randomSuffix returns random characters to tell temporary files apart
+func randomSuffix() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic(err.Error())
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,80 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"io/fs"
+	"iter"
+	"os"
+)
+
+// WalkEntry is a file visited by WalkSeq.  DirEntry is nil when the attributes
+// of the file could not be fetched.
+type WalkEntry struct {
+	Path string
+	fs.DirEntry
+}
+
+// Entries returns an iterator over the entries of the directory at dir, not
+// including "." and "..", in the order the server lists them.  The entries are
+// fetched lazily, a READDIRPLUS per batch, and breaking out of the loop stops
+// listing.  Entries listed without attributes are looked up.  A failure is
+// yielded once with a nil FileInfo and ends the iteration.
+//
+//	for info, err := range v.Entries(dir) {
+//		if err != nil {
+//			...
+//		}
+//		...
+//	}
+func (v *Target) Entries(dir string) iter.Seq2[os.FileInfo, error] {
+	return func(yield func(os.FileInfo, error) bool) {
+		it, err := v.ReadDirPlusIter(dir)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for it.Next() {
+			e := it.Entry()
+			if e.FileName == "." || e.FileName == ".." {
+				continue
+			}
+
+			// entries listed without attributes are looked up
+			if !e.Attr.IsSet {
+				fattr, _, _, err := v.lookup(it.fh, e.FileName)
+				if err != nil {
+					yield(nil, &os.PathError{Op: "readdir", Path: dir, Err: err})
+					return
+				}
+				e.Attr = PostOpAttr{IsSet: true, Attr: *fattr}
+			}
+
+			if !yield(e, nil) {
+				return
+			}
+		}
+
+		if err := it.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// WalkSeq returns an iterator over root and the files and directories below
+// it, in the order WalkDir visits them.  Failures to fetch the attributes of a
+// file or to list a directory are yielded with the entry concerned, and the
+// walk carries on with the rest of the tree.  Breaking out of the loop stops
+// walking.
+func (v *Target) WalkSeq(root string) iter.Seq2[WalkEntry, error] {
+	return func(yield func(WalkEntry, error) bool) {
+		v.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if !yield(WalkEntry{Path: path, DirEntry: d}, err) {
+				return fs.SkipAll
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+// JukeboxRetry is how the calls failing with NFS3ERR_JUKEBOX are sent again.
+// Servers answer so while they bring the file back from tape or another slow
+// tier, the call having done nothing, so that sending it again is safe.
+type JukeboxRetry struct {
+	// Delay is the wait before sending a call again, doubling each time up
+	// to MaxDelay.  Zero never sends the calls again, failing them with the
+	// error.
+	Delay    time.Duration
+	MaxDelay time.Duration
+
+	// Timeout is how long a call is sent again for at most, zero sending it
+	// until the deadline of the call passes
+	Timeout time.Duration
+}
+
+// DefaultJukeboxRetry is the JukeboxRetry of the Targets unless set otherwise
+var DefaultJukeboxRetry = JukeboxRetry{
+	Delay:    time.Second,
+	MaxDelay: 16 * time.Second,
+	Timeout:  5 * time.Minute,
+}
+
+// SetJukeboxRetry sets how the calls failing with NFS3ERR_JUKEBOX are sent
+// again
+func (v *Target) SetJukeboxRetry(r JukeboxRetry) {
+	v.jukebox = r
+}
+
+// retryJukebox makes call again while it fails with NFS3ERR_JUKEBOX, as the
+// JukeboxRetry of v says, until deadline unless zero
+func (v *Target) retryJukebox(deadline time.Time, call func() error) error {
+	r := v.jukebox
+	if r.Timeout > 0 {
+		if stop := time.Now().Add(r.Timeout); deadline.IsZero() || stop.Before(deadline) {
+			deadline = stop
+		}
+	}
+
+	delay := r.Delay
+	for {
+		err := call()
+		if r.Delay <= 0 || !isNFS3Error(err, NFS3ErrJukebox) {
+			return err
+		}
+
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		util.Debugf("nfs: server busy with NFS3ERR_JUKEBOX, calling again in %s", delay)
+		if werr := v.wait(delay); werr != nil {
+			return werr
+		}
+
+		if delay *= 2; r.MaxDelay > 0 && delay > r.MaxDelay {
+			delay = r.MaxDelay
+		}
+	}
+}
+
+// wait waits for d, or until the context of v is done
+func (v *Target) wait(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	if v.ctx == nil {
+		<-timer.C
+		return nil
+	}
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-v.ctx.Done():
+		return v.ctx.Err()
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryJukebox(t *testing.T) {
+	v := &Target{}
+	v.SetJukeboxRetry(JukeboxRetry{Delay: time.Millisecond, MaxDelay: 4 * time.Millisecond, Timeout: time.Second})
+
+	calls := 0
+	err := v.retryJukebox(time.Time{}, func() error {
+		if calls++; calls < 4 {
+			return NFS3Error(NFS3ErrJukebox)
+		}
+		return nil
+	})
+	if err != nil || calls != 4 {
+		t.Errorf("retryJukebox = %v after %d calls, want success after 4", err, calls)
+	}
+
+	// the deadline passes before the server is done
+	calls = 0
+	err = v.retryJukebox(time.Now().Add(10*time.Millisecond), func() error {
+		calls++
+		return NFS3Error(NFS3ErrJukebox)
+	})
+	if !isNFS3Error(err, NFS3ErrJukebox) || calls < 2 {
+		t.Errorf("retryJukebox = %v after %d calls, want NFS3ERR_JUKEBOX after a few", err, calls)
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs/rpc"
+	"github.com/go-nfs/nfsv3/nfs/util"
+	"github.com/go-nfs/nfsv3/nfs/xdr"
+)
+
+// Network Lock Manager protocol, version 4
+const (
+	NLMProg = 100021
+	NLMVers = 4
+
+	NLMProc4Null   = 0
+	NLMProc4Test   = 1
+	NLMProc4Lock   = 2
+	NLMProc4Cancel = 3
+	NLMProc4Unlock = 4
+
+	NLM4Granted           = 0
+	NLM4Denied            = 1
+	NLM4DeniedNoLocks     = 2
+	NLM4Blocked           = 3
+	NLM4DeniedGracePeriod = 4
+	NLM4Deadlck           = 5
+	NLM4ROFS              = 6
+	NLM4StaleFH           = 7
+	NLM4FBig              = 8
+	NLM4Failed            = 9
+)
+
+var nlmStatToName = map[uint32]string{
+	NLM4Granted:           "NLM4_GRANTED",
+	NLM4Denied:            "NLM4_DENIED",
+	NLM4DeniedNoLocks:     "NLM4_DENIED_NOLOCKS",
+	NLM4Blocked:           "NLM4_BLOCKED",
+	NLM4DeniedGracePeriod: "NLM4_DENIED_GRACE_PERIOD",
+	NLM4Deadlck:           "NLM4_DEADLCK",
+	NLM4ROFS:              "NLM4_ROFS",
+	NLM4StaleFH:           "NLM4_STALE_FH",
+	NLM4FBig:              "NLM4_FBIG",
+	NLM4Failed:            "NLM4_FAILED",
+}
+
+var (
+	// ErrLocked is returned by TryLock when another owner holds a
+	// conflicting lock, or while the server only accepts reclaims after
+	// rebooting
+	ErrLocked = errors.New("range is locked")
+
+	errNoLockManager = errors.New("locking needs a target dialed by address")
+)
+
+const (
+	// bounds of the delay between attempts to take the lock for AppendLocked
+	minLockRetry = 10 * time.Millisecond
+	maxLockRetry = time.Second
+)
+
+// lockManager holds the connection to the server's NLM service, dialed on
+// first use
+type lockManager struct {
+	addr   string
+	dialer Dialer
+
+	mu       sync.Mutex
+	client   *rpc.Client
+	hostname string
+	cookie   uint32
+}
+
+// lockOwner identifies the holder of the locks taken through a File
+type lockOwner struct {
+	svid int32
+	oh   []byte
+}
+
+// nlm4Lock is the nlm4_lock structure describing a byte range lock
+type nlm4Lock struct {
+	CallerName string
+	FH         []byte
+	OH         []byte
+	Svid       int32
+	Offset     uint64
+	Length     uint64
+}
+
+// get returns the NLM client, dialing the service within ctx when not
+// connected yet
+func (lm *lockManager) get(ctx context.Context) (*rpc.Client, string, []byte, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lm.client == nil {
+		client, err := lm.dialer.DialService(ctx, lm.addr, rpc.Mapping{
+			Prog: NLMProg,
+			Vers: NLMVers,
+		})
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			client.Close()
+			return nil, "", nil, err
+		}
+
+		lm.client, lm.hostname = client, hostname
+	}
+
+	lm.cookie++
+	cookie := make([]byte, 4)
+	binary.BigEndian.PutUint32(cookie, lm.cookie)
+
+	return lm.client, lm.hostname, cookie, nil
+}
+
+// close closes the connection to the NLM service, if dialed
+func (lm *lockManager) close() error {
+	return lm.shutdown(context.Background())
+}
+
+// shutdown closes the connection to the lock manager once the calls in flight
+// completed, or ctx is done
+func (lm *lockManager) shutdown(ctx context.Context) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lm.client == nil {
+		return nil
+	}
+
+	err := lm.client.Shutdown(ctx)
+	lm.client = nil
+
+	return err
+}
+
+// move points the lock manager at the server at addr, dropping the connection
+// to the former one without waiting for its calls in flight
+func (lm *lockManager) move(addr string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.addr = addr
+	if lm.client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	go lm.client.Shutdown(ctx)
+	lm.client = nil
+}
+
+// owner returns the lock owner of the file, chosen at random on first use so
+// that different Files exclude each other like different processes do
+func (f *File) owner() (*lockOwner, error) {
+	if f.lockOwner != nil {
+		return f.lockOwner, nil
+	}
+
+	oh := make([]byte, 16)
+	if _, err := rand.Read(oh); err != nil {
+		return nil, err
+	}
+
+	f.lockOwner = &lockOwner{
+		svid: int32(binary.BigEndian.Uint32(oh) &^ (1 << 31)),
+		oh:   oh,
+	}
+
+	return f.lockOwner, nil
+}
+
+// nlmCall issues the NLM request built for the byte range and returns the
+// status of the reply
+func (f *File) nlmCall(offset, length uint64, build func(cookie []byte, lock nlm4Lock) interface{}) (uint32, error) {
+	if f.lm == nil {
+		return 0, errNoLockManager
+	}
+
+	owner, err := f.owner()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	client, hostname, cookie, err := f.lm.get(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := f.rpcCall(client, build(cookie, nlm4Lock{
+		CallerName: hostname,
+		FH:         f.fh,
+		OH:         owner.oh,
+		Svid:       owner.svid,
+		Offset:     offset,
+		Length:     length,
+	}), loadDeadline(&f.writeDeadline))
+	if err != nil {
+		return 0, err
+	}
+
+	// nlm4_res: the cookie echoed back, then the status
+	if _, err = xdr.ReadOpaque(res); err != nil {
+		return 0, err
+	}
+
+	return xdr.ReadUint32(res)
+}
+
+func nlmHeader(proc uint32, auth rpc.Auth) rpc.Header {
+	return rpc.Header{
+		Rpcvers: 2,
+		Prog:    NLMProg,
+		Vers:    NLMVers,
+		Proc:    proc,
+		Cred:    auth,
+		Verf:    rpc.AuthNull,
+	}
+}
+
+func nlmError(stat uint32) error {
+	switch stat {
+	case NLM4Granted:
+		return nil
+	case NLM4Denied, NLM4DeniedGracePeriod:
+		return ErrLocked
+	case NLM4StaleFH:
+		return NFS3Error(NFS3ErrStale)
+	case NLM4ROFS:
+		return NFS3Error(NFS3ErrROFS)
+	case NLM4FBig:
+		return NFS3Error(NFS3ErrFBig)
+	}
+
+	if name, ok := nlmStatToName[stat]; ok {
+		return errors.New(name)
+	}
+
+	return fmt.Errorf("unknown nlm stat: %d", stat)
+}
+
+// TryLock takes a byte range lock of length bytes at offset with the server's
+// lock manager, a length of 0 extending the range to the end of the file and
+// beyond.  It does not wait for conflicting locks to be released but fails
+// with ErrLocked.  The lock is held on behalf of the File, so Files opened by
+// the same program exclude each other, and is released by Unlock.
+func (f *File) TryLock(offset, length uint64, exclusive bool) error {
+	type LockArgs struct {
+		rpc.Header
+		Cookie    []byte
+		Block     bool
+		Exclusive bool
+		Lock      nlm4Lock
+		Reclaim   bool
+		State     int32
+	}
+
+	stat, err := f.nlmCall(offset, length, func(cookie []byte, lock nlm4Lock) interface{} {
+		return &LockArgs{
+			Header:    nlmHeader(NLMProc4Lock, f.auth),
+			Cookie:    cookie,
+			Exclusive: exclusive,
+			Lock:      lock,
+		}
+	})
+	if err != nil {
+		util.Debugf("lock(%x) offset=%d len=%d: %s", f.fh, offset, length, err.Error())
+		return err
+	}
+
+	return nlmError(stat)
+}
+
+// Unlock releases the lock taken by TryLock on the byte range
+func (f *File) Unlock(offset, length uint64) error {
+	type UnlockArgs struct {
+		rpc.Header
+		Cookie []byte
+		Lock   nlm4Lock
+	}
+
+	stat, err := f.nlmCall(offset, length, func(cookie []byte, lock nlm4Lock) interface{} {
+		return &UnlockArgs{
+			Header: nlmHeader(NLMProc4Unlock, f.auth),
+			Cookie: cookie,
+			Lock:   lock,
+		}
+	})
+	if err != nil {
+		util.Debugf("unlock(%x) offset=%d len=%d: %s", f.fh, offset, length, err.Error())
+		return err
+	}
+
+	return nlmError(stat)
+}
+
+// AppendLocked appends p to the file so that concurrent appends by other
+// clients do not overwrite each other, provided they take the same lock.  It
+// locks the range from the end of the file onwards, fetches the size again
+// under the lock, writes and commits p there and releases the lock.  Attempts
+// to take the lock are repeated until it is granted or the write deadline has
+// passed.  The offset used by Read and Write is left after the data appended.
+func (f *File) AppendLocked(p []byte) (int, error) {
+	if !f.writable() {
+		return 0, ErrNotWritable
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	offset := uint64(info.Size())
+	for delay := minLockRetry; ; {
+		err = f.TryLock(offset, 0, true)
+		if err != ErrLocked {
+			break
+		}
+
+		deadline := loadDeadline(&f.writeDeadline)
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		time.Sleep(delay)
+		if delay *= 2; delay > maxLockRetry {
+			delay = maxLockRetry
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := f.appendUnderLock(p)
+	if uerr := f.Unlock(offset, 0); err == nil {
+		err = uerr
+	}
+
+	return n, err
+}
+
+// appendUnderLock writes and commits p at the end of the file while the lock
+// is held
+func (f *File) appendUnderLock(p []byte) (int, error) {
+	// another client may have appended before the lock was granted
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	f.invalidate()
+
+	offset := uint64(info.Size())
+	n, err := f.emit(p, offset)
+	f.curr = offset + uint64(n)
+	if err != nil {
+		return n, err
+	}
+
+	return n, f.Commit(0, 0)
+}
@@ -0,0 +1,300 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Package mirror copies directory trees onto NFS targets, from another target
+// or from any fs.FS, with a pool of workers copying the files concurrently.
+package mirror
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	_path "path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+const (
+	// defaultWorkers is the number of files copied at once by default
+	defaultWorkers = 8
+
+	// readAhead is the number of READs kept in flight for files copied from
+	// a target
+	readAhead = 4
+)
+
+// Options tune a copy.  The zero value selects the defaults.
+type Options struct {
+	// Workers is the number of files copied concurrently, 8 by default
+	Workers int
+}
+
+// source is the tree being copied
+type source interface {
+	walk(root string, fn fs.WalkDirFunc) error
+	open(path string) (io.ReadCloser, error)
+	readlink(path string) (string, error)
+}
+
+type targetSource struct {
+	v *nfs.Target
+}
+
+func (s targetSource) walk(root string, fn fs.WalkDirFunc) error {
+	return s.v.WalkDir(root, fn)
+}
+
+func (s targetSource) open(path string) (io.ReadCloser, error) {
+	f, err := s.v.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f.SetReadAhead(readAhead)
+	return f, nil
+}
+
+func (s targetSource) readlink(path string) (string, error) {
+	return s.v.Readlink(path)
+}
+
+type fsSource struct {
+	fsys fs.FS
+}
+
+func (s fsSource) walk(root string, fn fs.WalkDirFunc) error {
+	return fs.WalkDir(s.fsys, root, fn)
+}
+
+func (s fsSource) open(path string) (io.ReadCloser, error) {
+	return s.fsys.Open(path)
+}
+
+func (s fsSource) readlink(path string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: path, Err: fs.ErrInvalid}
+}
+
+// CopyTarget copies the tree at src on from to dst on to, preserving the
+// permissions and modification times of the files, directories and symlinks.
+// Existing files are overwritten.  It carries on past files it fails to copy
+// and returns all failures, wrapped in an *nfs.MultiError when there are
+// several.
+func CopyTarget(to *nfs.Target, dst string, from *nfs.Target, src string, opts *Options) error {
+	return copyTree(to, dst, targetSource{from}, src, opts)
+}
+
+// CopyFS is like CopyTarget for a tree in fsys, such as os.DirFS.  Symlinks
+// are skipped, as they cannot be read through an fs.FS.
+func CopyFS(to *nfs.Target, dst string, fsys fs.FS, src string, opts *Options) error {
+	return copyTree(to, dst, fsSource{fsys}, src, opts)
+}
+
+// job is a file for a worker to copy
+type job struct {
+	src, dst string
+	mode     os.FileMode
+	mtime    time.Time
+}
+
+// dirAttrs are applied to a directory once its contents have been copied
+type dirAttrs struct {
+	path  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+// errorList collects the failures of the walker and the workers
+type errorList struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (l *errorList) add(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errs = append(l.errs, err)
+}
+
+func (l *errorList) err() error {
+	switch len(l.errs) {
+	case 0:
+		return nil
+	case 1:
+		return l.errs[0]
+	}
+
+	return &nfs.MultiError{Errors: l.errs}
+}
+
+func copyTree(to *nfs.Target, dst string, src source, root string, opts *Options) error {
+	workers := defaultWorkers
+	if opts != nil && opts.Workers > 0 {
+		workers = opts.Workers
+	}
+
+	var (
+		errs errorList
+		dirs []dirAttrs
+		wg   sync.WaitGroup
+	)
+
+	jobs := make(chan job)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				if err := copyFile(to, src, j); err != nil {
+					util.Errorf("copy %s: %s", j.src, err.Error())
+					errs.add(err)
+				}
+			}
+		}()
+	}
+
+	// directories are created as the walk reaches them, which is before
+	// any of their files are handed to the workers
+	err := src.walk(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs.add(err)
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			errs.add(err)
+			return nil
+		}
+
+		target := destPath(dst, root, path)
+		switch {
+		case d.IsDir():
+			// keep the directory writable until its contents are copied
+			if err = to.MkdirAll(target, info.Mode().Perm()|0o700); err != nil {
+				errs.add(err)
+				return fs.SkipDir
+			}
+			dirs = append(dirs, dirAttrs{
+				path:  target,
+				mode:  info.Mode().Perm(),
+				mtime: info.ModTime(),
+			})
+
+		case info.Mode()&fs.ModeSymlink != 0:
+			if _, ok := src.(fsSource); ok {
+				return nil
+			}
+			if err = copySymlink(to, src, path, target); err != nil {
+				errs.add(err)
+			}
+
+		case info.Mode().IsRegular():
+			jobs <- job{
+				src:   path,
+				dst:   target,
+				mode:  info.Mode().Perm(),
+				mtime: info.ModTime(),
+			}
+
+		default:
+			util.Infof("copy %s: skipping special file", path)
+		}
+
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+
+	if err != nil {
+		errs.add(err)
+	}
+
+	// deepest first, as setting them on a parent would be undone by the
+	// changes made to its children
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err = to.Chmod(d.path, d.mode); err == nil {
+			err = to.Chtimes(d.path, time.Time{}, d.mtime)
+		}
+		if err != nil {
+			errs.add(err)
+		}
+	}
+
+	return errs.err()
+}
+
+// copyFile streams the contents of a file and applies its mode and mtime
+func copyFile(to *nfs.Target, src source, j job) error {
+	r, err := src.open(j.src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// keep the file writable by its owner until the contents are copied
+	w, err := to.OpenFile(j.dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, j.mode|0o200)
+	if err != nil {
+		return err
+	}
+
+	if err = w.SetPipelined(true); err != nil {
+		w.Close()
+		return err
+	}
+
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	if err = w.Chmod(j.mode); err != nil {
+		return err
+	}
+
+	return w.Chtimes(time.Time{}, j.mtime)
+}
+
+// copySymlink recreates a symlink, replacing what is at target
+func copySymlink(to *nfs.Target, src source, path, target string) error {
+	link, err := src.readlink(path)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err = to.Symlink(link, target, nil); os.IsExist(err) {
+		if err = to.Remove(target); err != nil {
+			return err
+		}
+		_, _, err = to.Symlink(link, target, nil)
+	}
+
+	return err
+}
+
+// destPath maps path in the tree copied from root to the tree at dst
+func destPath(dst, root, path string) string {
+	if path == root {
+		return dst
+	}
+
+	rel := path
+	if root != "" && root != "." {
+		rel = strings.TrimPrefix(strings.TrimPrefix(path, root), "/")
+	}
+
+	return _path.Join(dst, rel)
+}
@@ -0,0 +1,118 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package mirror
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/nfstest"
+)
+
+func TestDestPath(t *testing.T) {
+	tests := []struct {
+		dst, root, path, want string
+	}{
+		{"backup", "data", "data", "backup"},
+		{"backup", "data", "data/a/b", "backup/a/b"},
+		{"backup", ".", "a/b", "backup/a/b"},
+		{"backup", "", "a", "backup/a"},
+		{"/backup", "/", "/a/b", "/backup/a/b"},
+	}
+
+	for _, tt := range tests {
+		if got := destPath(tt.dst, tt.root, tt.path); got != tt.want {
+			t.Errorf("destPath(%q, %q, %q) = %q, want %q", tt.dst, tt.root, tt.path, got, tt.want)
+		}
+	}
+}
+
+// check fails t unless the file at path on v has mode and holds data, or
+// links to data for a symlink
+func check(t *testing.T, v *nfs.Target, path string, mode os.FileMode, data string) {
+	t.Helper()
+
+	info, err := v.Lstat(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if info.Mode() != mode {
+		t.Errorf("%s has mode %v, want %v", path, info.Mode(), mode)
+	}
+
+	var got []byte
+	switch {
+	case mode&os.ModeSymlink != 0:
+		var link string
+		link, err = v.Readlink(path)
+		got = []byte(link)
+	case mode.IsRegular():
+		got, err = v.ReadFile(path)
+	default:
+		return
+	}
+	if string(got) != data || err != nil {
+		t.Errorf("%s holds %q, %v, want %q", path, got, err, data)
+	}
+}
+
+func TestCopyTarget(t *testing.T) {
+	_, from := nfstest.Mount(t)
+	_, to := nfstest.Mount(t)
+
+	if err := from.MkdirAll("src/sub", 0o750); err != nil {
+		t.Fatal(err)
+	}
+	for path, data := range map[string]string{"src/a": "a", "src/sub/b": strings.Repeat("b", 300000)} {
+		if err := from.WriteFile(path, []byte(data), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := from.Symlink("a", "src/link", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Chmod("src/sub", 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CopyTarget(to, "dst", from, "src", &Options{Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	check(t, to, "dst", os.ModeDir|0o750, "")
+	check(t, to, "dst/sub", os.ModeDir|0o700, "")
+	check(t, to, "dst/a", 0o640, "a")
+	check(t, to, "dst/sub/b", 0o640, strings.Repeat("b", 300000))
+	check(t, to, "dst/link", os.ModeSymlink|0o777, "a")
+}
+
+// test the copy carries on past the files the workers fail to copy and
+// returns their errors
+func TestCopyFSFails(t *testing.T) {
+	s, to := nfstest.Mount(t)
+
+	fsys := fstest.MapFS{
+		"src/a":     {Data: []byte("a"), Mode: 0o644},
+		"src/b":     {Data: []byte("b"), Mode: 0o644},
+		"src/empty": {Mode: 0o600},
+		"src/dir":   {Mode: fs.ModeDir | 0o755},
+	}
+
+	s.Fail(nfs.NFSProc3Write, nfs.NFS3ErrNoSpc)
+	err := CopyFS(to, "dst", fsys, "src", nil)
+
+	var merr *nfs.MultiError
+	if !errors.As(err, &merr) || len(merr.Errors) != 2 {
+		t.Fatalf("copy = %v, want the errors of both files written", err)
+	}
+
+	check(t, to, "dst/empty", 0o600, "")
+	check(t, to, "dst/dir", os.ModeDir|0o755, "")
+}
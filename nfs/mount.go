@@ -4,8 +4,10 @@
 package nfs
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
 
 	"github.com/go-nfs/nfsv3/nfs/rpc"
 	"github.com/go-nfs/nfsv3/nfs/xdr"
@@ -37,16 +39,23 @@ type Mount struct {
 	auth    rpc.Auth
 	dirPath string
 	Addr    string
-	priv    bool
+
+	// the settings of the connections of the Targets mounted
+	dialer Dialer
 }
 
 func (m *Mount) Unmount() error {
+	return m.unmount(context.Background(), m.dirPath)
+}
+
+// unmount sends the UMNT of dirpath, giving up once ctx is done
+func (m *Mount) unmount(ctx context.Context, dirpath string) error {
 	type umount struct {
 		rpc.Header
 		Dirpath string
 	}
 
-	_, err := m.Call(&umount{
+	_, err := m.CallContext(ctx, &umount{
 		rpc.Header{
 			Rpcvers: 2,
 			Prog:    MountProg,
@@ -58,7 +67,7 @@ func (m *Mount) Unmount() error {
 			Cred: m.auth,
 			Verf: rpc.AuthNull,
 		},
-		m.dirPath,
+		dirpath,
 	})
 	if err != nil {
 		return err
@@ -69,12 +78,71 @@ func (m *Mount) Unmount() error {
 
 // Mount creates a mount to a filesystem, with a priv flag to use local (un)privileged ports
 func (m *Mount) Mount(dirpath string, auth rpc.Auth) (*Target, error) {
+	return m.MountContext(context.Background(), dirpath, auth)
+}
+
+// MountContext is like Mount, but gives up once ctx is done.  The Target
+// returned is not bound to ctx.
+func (m *Mount) MountContext(ctx context.Context, dirpath string, auth rpc.Auth) (*Target, error) {
+	fh, err := m.mnt(ctx, dirpath, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	m.dirPath = dirpath
+	m.auth = auth
+
+	var vol *Target
+	if m.Addr != "" {
+		vol, err = m.dialer.NewTarget(ctx, m.Addr, auth, fh, dirpath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		vol, err = newTarget(ctx, m.Client, auth, fh, dirpath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vol.mount = m
+	vol.exportPath = dirpath
+
+	return vol, nil
+}
+
+// MountConn is like MountContext, but the Target returned issues its calls
+// over conn, a connection to the NFS service established by other means, as
+// NewTargetFromConn does
+func (m *Mount) MountConn(ctx context.Context, conn net.Conn, dirpath string, auth rpc.Auth) (*Target, error) {
+	fh, err := m.mnt(ctx, dirpath, auth)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	m.dirPath = dirpath
+	m.auth = auth
+
+	vol, err := m.dialer.NewTargetFromConn(ctx, conn, auth, fh, dirpath)
+	if err != nil {
+		return nil, err
+	}
+
+	vol.mount = m
+	vol.exportPath = dirpath
+
+	return vol, nil
+}
+
+// mnt sends the MNT of dirpath, returning the root handle of the export
+func (m *Mount) mnt(ctx context.Context, dirpath string, auth rpc.Auth) ([]byte, error) {
 	type mount struct {
 		rpc.Header
 		Dirpath string
 	}
 
-	res, err := m.Call(&mount{
+	res, err := m.CallContext(ctx, &mount{
 		rpc.Header{
 			Rpcvers: 2,
 			Prog:    MountProg,
@@ -103,23 +171,7 @@ func (m *Mount) Mount(dirpath string, auth rpc.Auth) (*Target, error) {
 
 		_, _ = xdr.ReadUint32List(res)
 
-		m.dirPath = dirpath
-		m.auth = auth
-
-		var vol *Target
-		if m.Addr != "" {
-			vol, err = NewTarget(m.Addr, auth, fh, dirpath, m.priv)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			vol, err = NewTargetWithClient(m.Client, auth, fh, dirpath)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		return vol, nil
+		return fh, nil
 
 	case MNT3ErrPerm:
 		return nil, errors.New("MNT3ERR_PERM")
@@ -138,22 +190,11 @@ func (m *Mount) Mount(dirpath string, auth rpc.Auth) (*Target, error) {
 }
 
 func DialMount(addr string, priv bool) (*Mount, error) {
-	// get MOUNT port
-	m := rpc.Mapping{
-		Prog: MountProg,
-		Vers: MountVers,
-		Prot: rpc.IPProtoTCP,
-		Port: 0,
-	}
-
-	client, err := DialService(addr, m, priv)
-	if err != nil {
-		return nil, err
-	}
+	return DialMountContext(context.Background(), addr, priv)
+}
 
-	return &Mount{
-		Client: client,
-		Addr:   addr,
-		priv:   priv,
-	}, nil
+// DialMountContext is like DialMount, but gives up once ctx is done
+func DialMountContext(ctx context.Context, addr string, priv bool) (*Mount, error) {
+	d := &Dialer{Priv: priv}
+	return d.DialMount(ctx, addr)
 }
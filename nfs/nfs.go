@@ -4,10 +4,11 @@
 package nfs
 
 import (
-	"fmt"
+	"context"
 	"math/rand"
 	"net"
 	"os"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -29,6 +30,10 @@ const (
 	Nfs3Prog = 100003
 	Nfs3Vers = 3
 
+	// NFSPort is the port of the NFS service of the servers, for dialing it
+	// without the portmapper
+	NFSPort = 2049
+
 	// program methods
 	NFSProc3GetAttr     = 1
 	NFSProc3SetAttr     = 2
@@ -40,17 +45,25 @@ const (
 	NFSProc3Create      = 8
 	NFSProc3Mkdir       = 9
 	NFSProc3Symlink     = 10
+	NFSProc3Mknod       = 11
 	NFSProc3Remove      = 12
 	NFSProc3RmDir       = 13
 	NFSProc3Rename      = 14
+	NFSProc3Link        = 15
+	NFSProc3ReadDir     = 16
 	NFSProc3ReadDirPlus = 17
+	NFSProc3FSStat      = 18
 	NFSProc3FSInfo      = 19
+	NFSProc3PathConf    = 20
 	NFSProc3Commit      = 21
 
 	// The size in bytes of the opaque cookie verifier passed by
 	// READDIR and READDIRPLUS.
 	NFS3_COOKIEVERFSIZE = 8
 
+	// The size in bytes of the opaque verifier used for exclusive CREATE.
+	NFS3_CREATEVERFSIZE = 8
+
 	// file types
 	NF3Reg  = 1
 	NF3Dir  = 2
@@ -61,6 +74,19 @@ const (
 	NF3FIFO = 7
 )
 
+// StableHow tells the server how far WRITE must have committed data to stable
+// storage before replying
+type StableHow uint32
+
+const (
+	// Unstable writes may still be cached by the server and need a COMMIT.
+	Unstable StableHow = iota
+	// DataSync writes have committed the data, but not necessarily the metadata.
+	DataSync
+	// FileSync writes have committed the data and the metadata.
+	FileSync
+)
+
 type Diropargs3 struct {
 	FH       []byte
 	Filename string
@@ -117,6 +143,75 @@ type SetTime struct {
 	Time  NFS3Time `xdr:"unioncase=2"` //SetToClientTime
 }
 
+// chmodAttr selects the permission bits of mode to be set
+func chmodAttr(mode os.FileMode) Sattr3 {
+	return Sattr3{
+		Mode: SetMode{
+			SetIt: true,
+			Mode:  unixMode(mode),
+		},
+	}
+}
+
+// unixMode converts the permission and special bits of mode to the mode bits
+// used on the wire
+func unixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 0o4000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 0o2000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 0o1000
+	}
+
+	return m
+}
+
+// chownAttr selects the owner and group to be set, where -1 leaves either as
+// it is
+func chownAttr(uid, gid int) Sattr3 {
+	return Sattr3{
+		UID: SetUID{
+			SetIt: uid != -1,
+			UID:   uint32(uid),
+		},
+		GID: SetUID{
+			SetIt: gid != -1,
+			UID:   uint32(gid),
+		},
+	}
+}
+
+// chtimesAttr selects the access and modification times to be set, where the
+// zero time leaves either as it is
+func chtimesAttr(atime, mtime time.Time) Sattr3 {
+	return Sattr3{
+		Atime: setTime(atime),
+		Mtime: setTime(mtime),
+	}
+}
+
+func setTime(t time.Time) SetTime {
+	if t.IsZero() {
+		return SetTime{}
+	}
+
+	return SetTime{
+		SetIt: SetToClientTime,
+		Time:  nfs3Time(t),
+	}
+}
+
+func nfs3Time(t time.Time) NFS3Time {
+	return NFS3Time{
+		Seconds:  uint32(t.Unix()),
+		Nseconds: uint32(t.Nanosecond()),
+	}
+}
+
 type Sattrguard3 struct {
 	Check int      `xdr:"union"`
 	Time  NFS3Time //SetToClientTime
@@ -149,8 +244,36 @@ func (f *Fattr) Size() int64 {
 	return int64(f.Filesize)
 }
 
+// Mode returns the permission and special bits of the file along with the
+// os.FileMode bits for its type
 func (f *Fattr) Mode() os.FileMode {
-	return os.FileMode(f.FileMode)
+	mode := os.FileMode(f.FileMode & 0o777)
+	if f.FileMode&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if f.FileMode&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if f.FileMode&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	switch f.Type {
+	case NF3Dir:
+		mode |= os.ModeDir
+	case NF3Lnk:
+		mode |= os.ModeSymlink
+	case NF3Blk:
+		mode |= os.ModeDevice
+	case NF3Chr:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case NF3Sock:
+		mode |= os.ModeSocket
+	case NF3FIFO:
+		mode |= os.ModeNamedPipe
+	}
+
+	return mode
 }
 
 func (f *Fattr) ModTime() time.Time {
@@ -161,8 +284,19 @@ func (f *Fattr) IsDir() bool {
 	return f.Type == NF3Dir
 }
 
+// Sys returns the Fattr itself, for callers needing the raw NFS attributes
 func (f *Fattr) Sys() interface{} {
-	return nil
+	return f
+}
+
+// fileInfo gives attributes the name they were looked up by
+type fileInfo struct {
+	name string
+	*Fattr
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
 }
 
 type PostOpFH3 struct {
@@ -238,6 +372,33 @@ type WccData struct {
 	After PostOpAttr
 }
 
+// FSStat holds the capacity of the file system in bytes and in files.  The
+// available amounts are those usable by the credentials of the request, which
+// may be less than the free ones.
+type FSStat struct {
+	Attr   PostOpAttr
+	TBytes uint64
+	FBytes uint64
+	ABytes uint64
+	TFiles uint64
+	FFiles uint64
+	AFiles uint64
+
+	// seconds for which the file system is not expected to change
+	Invarsec uint32
+}
+
+// PathConf holds the POSIX pathconf information of a file
+type PathConf struct {
+	Attr            PostOpAttr
+	LinkMax         uint32
+	NameMax         uint32
+	NoTrunc         bool
+	ChownRestricted bool
+	CaseInsensitive bool
+	CasePreserving  bool
+}
+
 type FSInfo struct {
 	Attr       PostOpAttr
 	RTMax      uint32
@@ -254,74 +415,66 @@ type FSInfo struct {
 
 // DialService Dial an RPC svc after getting the port from the portmapper
 func DialService(addr string, prog rpc.Mapping, priv bool) (*rpc.Client, error) {
-	pm, err := rpc.DialPortmapper("tcp", addr)
-	if err != nil {
-		util.Errorf("Failed to connect to portmapper: %s", err)
-		return nil, err
-	}
-	defer pm.Close()
+	return DialServiceContext(context.Background(), addr, prog, priv)
+}
 
-	port, err := pm.Getport(prog)
-	if err != nil {
-		return nil, err
-	}
+// DialServiceContext is like DialService, but gives up once ctx is done
+func DialServiceContext(ctx context.Context, addr string, prog rpc.Mapping, priv bool) (*rpc.Client, error) {
+	d := &Dialer{Priv: priv, Proto: prog.Prot}
+	return d.DialService(ctx, addr, prog)
+}
 
-	client, err := dialService(addr, port, priv)
-	if err != nil {
-		return nil, err
-	}
+// dialFrom calls dial with the local address to connect to the service at
+// port of addr from, ip unless nil and a random privileged port when priv is
+// set, trying another one while the port is in use
+func dialFrom(network, addr string, port int, priv bool, ip net.IP, dial func(laddr net.Addr, raddr string) error) error {
+	raddr := net.JoinHostPort(addr, strconv.Itoa(port))
 
-	return client, nil
-}
+	if !priv {
+		util.Debugf("Connecting to %s from unprivileged port", raddr)
+		return dial(localAddr(network, ip, 0), raddr)
+	}
 
-func dialService(addr string, port int, priv bool) (*rpc.Client, error) {
-	var (
-		ldr    *net.TCPAddr
-		client *rpc.Client
-		err    error
-	)
+	r1 := rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	if priv {
-		r1 := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var p int
+	for {
+		p = r1.Intn(1024)
+		if p < 1 {
+			continue
+		}
 
-		var p int
-		for {
-			p = r1.Intn(1024)
-			if p < 1 {
-				continue
-			}
+		util.Debugf("Connecting to %s", raddr)
 
-			ldr = &net.TCPAddr{
-				Port: p,
-			}
+		err := dial(localAddr(network, ip, p), raddr)
+		if err == nil {
+			break
+		}
+		// bind error, try again
+		if isAddrInUse(err) {
+			continue
+		}
 
-			raddr := fmt.Sprintf("%s:%d", addr, port)
-			util.Debugf("Connecting to %s", raddr)
+		return err
+	}
 
-			client, err = rpc.DialTCP("tcp", ldr, raddr)
-			if err == nil {
-				break
-			}
-			// bind error, try again
-			if isAddrInUse(err) {
-				continue
-			}
+	util.Debugf("using random port %d -> %d", p, port)
 
-			return nil, err
-		}
+	return nil
+}
 
-		util.Debugf("using random port %d -> %d", p, port)
-	} else {
-		raddr := fmt.Sprintf("%s:%d", addr, port)
-		util.Debugf("Connecting to %s from unprivileged port", raddr)
+// localAddr returns the local address of ip and port over network, nil when
+// neither is set
+func localAddr(network string, ip net.IP, port int) net.Addr {
+	if ip == nil && port == 0 {
+		return nil
+	}
 
-		client, err = rpc.DialTCP("tcp", ldr, raddr)
-		if err != nil {
-			return nil, err
-		}
+	if network == "udp" {
+		return &net.UDPAddr{IP: ip, Port: port}
 	}
 
-	return client, nil
+	return &net.TCPAddr{IP: ip, Port: port}
 }
 
 func isAddrInUse(err error) bool {
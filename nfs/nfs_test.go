@@ -4,10 +4,19 @@
 package nfs
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"math"
 	"net"
+	"os"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs/rpc"
 )
 
 func listenAndServe(t *testing.T, port int) (*net.TCPListener, *sync.WaitGroup, error) {
@@ -41,13 +50,13 @@ func TestDialService(t *testing.T) {
 	}
 	defer listener.Close()
 
-	_, err = dialService("127.0.0.1", 6666, false)
+	_, err = (&Dialer{}).dial(context.Background(), "tcp", "127.0.0.1", 6666, false)
 	if err != nil {
 		t.Logf("error dialing: %s", err.Error())
 		t.FailNow()
 	}
 
-	_, err = dialService("127.0.0.1", 6666, false)
+	_, err = (&Dialer{}).dial(context.Background(), "tcp", "127.0.0.1", 6666, false)
 	if err != nil {
 		t.Logf("error dialing: %s", err.Error())
 		t.FailNow()
@@ -55,3 +64,148 @@ func TestDialService(t *testing.T) {
 
 	wg.Wait()
 }
+
+// test cancelling a context interrupts a call waiting for its reply
+func TestCallContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// never reply
+		io.Copy(io.Discard, conn)
+	}()
+
+	client, err := rpc.DialTCP("tcp", nil, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err = client.CallContext(ctx, &rpc.Header{Rpcvers: 2, Prog: Nfs3Prog, Vers: Nfs3Vers})
+	if err != context.Canceled {
+		t.Fatalf("CallContext() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestFattrMode(t *testing.T) {
+	tests := []struct {
+		fattr Fattr
+		mode  os.FileMode
+	}{
+		{Fattr{Type: NF3Reg, FileMode: 0o644}, 0o644},
+		{Fattr{Type: NF3Dir, FileMode: 0o1777}, os.ModeDir | os.ModeSticky | 0o777},
+		{Fattr{Type: NF3Lnk, FileMode: 0o777}, os.ModeSymlink | 0o777},
+		{Fattr{Type: NF3Reg, FileMode: 0o6755}, os.ModeSetuid | os.ModeSetgid | 0o755},
+		{Fattr{Type: NF3Chr, FileMode: 0o600}, os.ModeDevice | os.ModeCharDevice | 0o600},
+		{Fattr{Type: NF3FIFO, FileMode: 0o600}, os.ModeNamedPipe | 0o600},
+	}
+
+	for _, tt := range tests {
+		if mode := tt.fattr.Mode(); mode != tt.mode {
+			t.Errorf("mode of %+v: got %v, want %v", tt.fattr, mode, tt.mode)
+		}
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	tests := []struct {
+		errnum uint32
+		target error
+		want   bool
+	}{
+		{NFS3ErrAcces, fs.ErrPermission, true},
+		{NFS3ErrInval, fs.ErrInvalid, true},
+		{NFS3ErrIO, fs.ErrPermission, false},
+		{NFS3ErrAcces, fs.ErrNotExist, false},
+	}
+
+	for _, tt := range tests {
+		err := &fs.PathError{Op: "open", Path: "a", Err: NFS3Error(tt.errnum)}
+		if got := errors.Is(err, tt.target); got != tt.want {
+			t.Errorf("errors.Is(%v, %v) = %v, want %v", err, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestVolumeStats(t *testing.T) {
+	got := volumeStats(&FSStat{
+		TBytes: 1000, FBytes: 400, ABytes: 300,
+		TFiles: math.MaxUint64, FFiles: math.MaxUint64, AFiles: math.MaxUint64,
+	}, &PathConf{NameMax: 255, LinkMax: 32000})
+
+	want := &VolumeStats{
+		CapacityBytes: 1000, UsedBytes: 600, AvailableBytes: 300,
+		CapacityInodes: math.MaxInt64, UsedInodes: 0, AvailableInodes: math.MaxInt64,
+		MaxNameLength: 255, MaxLinks: 32000,
+	}
+	if *got != *want {
+		t.Errorf("volumeStats = %+v, want %+v", *got, *want)
+	}
+}
+
+func TestExportRelative(t *testing.T) {
+	v := &Target{dirPath: "/export/data"}
+
+	tests := map[string]string{
+		"/export/data":          "",
+		"/export/data/a/b":      "a/b",
+		"/export/data/a/../b":   "b",
+		"/export/database/a":    "",
+		"/etc/passwd":           "",
+		"/export/data/../other": "",
+	}
+
+	for target, want := range tests {
+		got, err := v.exportRelative(target)
+		if want == "" && target != "/export/data" {
+			if !errors.Is(err, ErrOutsideExport) || !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("exportRelative(%q) = %q, %v, want ErrOutsideExport", target, got, err)
+			}
+		} else if got != want || err != nil {
+			t.Errorf("exportRelative(%q) = %q, %v, want %q", target, got, err, want)
+		}
+	}
+
+	// the whole server is exported
+	v.dirPath = "/"
+	if got, err := v.exportRelative("/etc/passwd"); got != "etc/passwd" || err != nil {
+		t.Errorf("exportRelative(/etc/passwd) of / = %q, %v, want etc/passwd", got, err)
+	}
+}
+
+// test reconnecting resolves the host name of the server again rather than
+// dialing the address first resolved
+func TestRedialResolves(t *testing.T) {
+	var dialed []string
+	d := &Dialer{
+		NFSPort:   NFSPort,
+		NoPortmap: true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return nil, errors.New("unreachable")
+		},
+	}
+
+	redial := d.redial("tcp", "filer.example.com", rpc.Mapping{Prog: Nfs3Prog, Vers: Nfs3Vers}, NFSPort)
+	for i := 0; i < 2; i++ {
+		if _, err := redial(context.Background()); err == nil {
+			t.Fatal("redial succeeded")
+		}
+	}
+
+	if len(dialed) != 2 || dialed[0] != "filer.example.com:2049" || dialed[1] != dialed[0] {
+		t.Errorf("dialed %v, want filer.example.com:2049 twice", dialed)
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Package nfstest checks the behaviour of live exports through this client,
+// and provides an in-memory Server to test the code built on it.
+package nfstest
+
+import (
+	"testing/fstest"
+
+	"github.com/go-nfs/nfsv3/nfs"
+)
+
+// TestFS runs testing/fstest.TestFS over nfs.FS(v), walking the whole export
+// and checking that every file and directory reads, lists and stats
+// consistently, and that the export holds at least the expected files.
+// Failures point at server quirks, such as listings disagreeing with LOOKUP
+// or unstable directory cookies.  The export must not change while it runs.
+func TestFS(v *nfs.Target, expected ...string) error {
+	return fstest.TestFS(nfs.FS(v), expected...)
+}
@@ -0,0 +1,1113 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfstest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/rpc"
+	"github.com/go-nfs/nfsv3/nfs/xdr"
+)
+
+// maxDirEntries is how many entries a listing returns at most per reply, few
+// enough for the listings of the tests to take several calls
+const maxDirEntries = 8
+
+// Server is an in-memory NFSv3 server, serving MOUNT and NFS on the same TCP
+// port of the loopback interface, to test the code built on this client
+// without a live export.  Any directory can be mounted, the credentials are
+// not checked and everyone is granted every access.
+type Server struct {
+	l net.Listener
+
+	// guards the fields below
+	mu sync.Mutex
+
+	nodes map[uint64]*node
+	ids   uint64
+
+	// the last time a file changed, moved forward on every change so that
+	// each one shows
+	now time.Time
+
+	// the status the calls to a procedure fail with, and how many calls to
+	// each were made
+	fail  map[uint32]uint32
+	calls map[uint32]int
+
+	// set when READDIRPLUS leaves out the attributes of the entries
+	bare bool
+
+	conns  map[net.Conn]bool
+	mounts []*nfs.Mount
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// node is a file of a Server
+type node struct {
+	attr nfs.Fattr
+
+	// the contents of a regular file, or the target of a symlink
+	data []byte
+
+	// the entries of a directory, and the cookies they are listed in the
+	// order of
+	entries map[string]uint64
+	cookies map[string]uint64
+	parent  uint64
+}
+
+// NewServer starts a Server exporting an empty directory
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		l:     l,
+		nodes: map[uint64]*node{},
+		now:   time.Now(),
+		fail:  map[uint32]uint32{},
+		calls: map[uint32]int{},
+		conns: map[net.Conn]bool{},
+	}
+	root := s.newNode(nfs.NF3Dir, 0o755)
+	root.entries["."] = root.attr.Fileid
+	root.parent = root.attr.Fileid
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Dialer returns a Dialer connecting to s
+func (s *Server) Dialer() nfs.Dialer {
+	port := s.l.Addr().(*net.TCPAddr).Port
+
+	return nfs.Dialer{MountPort: port, NFSPort: port, NoPortmap: true}
+}
+
+// Addr returns the address to dial s at with its Dialer
+func (s *Server) Addr() string {
+	return "127.0.0.1"
+}
+
+// Mount mounts the directory at dirpath from s.  The connection to its MOUNT
+// service is closed along with s.
+func (s *Server) Mount(dirpath string) (*nfs.Target, error) {
+	d := s.Dialer()
+
+	m, err := d.DialMount(context.Background(), s.Addr())
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.mounts = append(s.mounts, m)
+	s.mu.Unlock()
+
+	return m.Mount(dirpath, rpc.NewAuthUnix("nfstest", 0, 0).Auth())
+}
+
+// Mount starts a Server and mounts its export, failing tb on error.  Both are
+// closed once tb is done.
+func Mount(tb testing.TB) (*Server, *nfs.Target) {
+	tb.Helper()
+
+	s, err := NewServer()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { s.Close() })
+
+	v, err := s.Mount("/")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { v.Close() })
+
+	return s, v
+}
+
+// Fail has the calls to the NFS procedure proc fail with status from now on,
+// or succeed again for a status of 0
+func (s *Server) Fail(proc, status uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if status == 0 {
+		delete(s.fail, proc)
+	} else {
+		s.fail[proc] = status
+	}
+}
+
+// OmitEntryAttrs has READDIRPLUS leave out the attributes of the entries it
+// lists from now on, as servers may, or list them again when off
+func (s *Server) OmitEntryAttrs(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bare = on
+}
+
+// Calls returns how many calls to the NFS procedure proc s answered
+func (s *Server) Calls(proc uint32) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[proc]
+}
+
+// Close stops s, closing the connections to it
+func (s *Server) Close() error {
+	s.mu.Lock()
+	mounts := s.mounts
+	s.mounts = nil
+	s.mu.Unlock()
+
+	for _, m := range mounts {
+		m.Close()
+	}
+
+	err := s.l.Close()
+
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers the calls coming on conn in turn
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		call, err := readRecord(r)
+		if err != nil {
+			return
+		}
+
+		reply := s.handle(call)
+		if reply == nil {
+			continue
+		}
+
+		hdr := make([]byte, 4)
+		binary.BigEndian.PutUint32(hdr, uint32(len(reply))|0x80000000)
+		if _, err := conn.Write(append(hdr, reply...)); err != nil {
+			return
+		}
+	}
+}
+
+// readRecord reads a record made of one or several fragments from r
+func readRecord(r io.Reader) ([]byte, error) {
+	var rec []byte
+	for {
+		var hdr uint32
+		if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+			return nil, err
+		}
+
+		frag := make([]byte, hdr&0x7fffffff)
+		if _, err := io.ReadFull(r, frag); err != nil {
+			return nil, err
+		}
+		rec = append(rec, frag...)
+
+		if hdr&0x80000000 != 0 {
+			return rec, nil
+		}
+	}
+}
+
+// the accept_stat of the replies
+const (
+	success     = 0
+	progUnavail = 1
+	procUnavail = 3
+	garbageArgs = 4
+)
+
+// handle answers call, returning nil for a message which is not one
+func (s *Server) handle(call []byte) []byte {
+	r := bytes.NewReader(call)
+
+	var msg struct {
+		Xid, Mtype uint32
+		rpc.Header
+	}
+	if err := xdr.Read(r, &msg); err != nil || msg.Mtype != 0 {
+		return nil
+	}
+
+	w := new(encoder)
+	w.u32(msg.Xid, 1, rpc.MsgAccepted, 0, 0)
+
+	var stat uint32
+	switch {
+	case msg.Prog == nfs.MountProg && msg.Vers == nfs.MountVers:
+		stat = s.mountProc(msg.Proc, r, w)
+	case msg.Prog == nfs.Nfs3Prog && msg.Vers == nfs.Nfs3Vers:
+		stat = s.nfsProc(msg.Proc, r, w)
+	default:
+		stat = progUnavail
+	}
+
+	if stat != success {
+		w.Truncate(0)
+		w.u32(msg.Xid, 1, rpc.MsgAccepted, 0, 0, stat)
+	}
+
+	return w.Bytes()
+}
+
+// mountProc runs the MOUNT procedure proc with the arguments in r, writing its
+// results to w after the accept_stat it returns
+func (s *Server) mountProc(proc uint32, r io.Reader, w *encoder) uint32 {
+	switch proc {
+	case nfs.MountProc3Null:
+		w.u32(success)
+	case nfs.MountProc3MNT:
+		var dirpath string
+		if err := xdr.Read(r, &dirpath); err != nil {
+			return garbageArgs
+		}
+
+		s.mu.Lock()
+		id, ok := s.resolve(dirpath)
+		s.mu.Unlock()
+
+		w.u32(success)
+		if !ok {
+			w.u32(nfs.MNT3ErrNoEnt)
+			break
+		}
+		w.u32(nfs.MNT3Ok)
+		w.opaque(handle(id))
+		w.u32(1, 1)
+	case nfs.MountProc3UMNT:
+		w.u32(success)
+	default:
+		return procUnavail
+	}
+
+	return success
+}
+
+// resolve looks up the directory at path from the root, without following
+// symlinks
+func (s *Server) resolve(path string) (uint64, bool) {
+	id := uint64(1)
+	for _, name := range strings.Split(path, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+
+		n := s.nodes[id]
+		if n.entries == nil {
+			return 0, false
+		}
+
+		if name == ".." {
+			id = n.parent
+			continue
+		}
+
+		next, ok := n.entries[name]
+		if !ok {
+			return 0, false
+		}
+		id = next
+	}
+
+	return id, s.nodes[id].attr.Type == nfs.NF3Dir
+}
+
+// handle returns the file handle of the node id
+func handle(id uint64) []byte {
+	fh := make([]byte, 8)
+	binary.BigEndian.PutUint64(fh, id)
+
+	return fh
+}
+
+// newNode adds a node of type typ with the permissions mode, returning it
+func (s *Server) newNode(typ, mode uint32) *node {
+	s.ids++
+	now := s.tick()
+
+	n := &node{attr: nfs.Fattr{
+		Type:     typ,
+		FileMode: mode,
+		Nlink:    1,
+		FSID:     1,
+		Fileid:   s.ids,
+		Atime:    now,
+		Mtime:    now,
+		Ctime:    now,
+	}}
+	if typ == nfs.NF3Dir {
+		n.attr.Nlink = 2
+		n.entries = map[string]uint64{}
+		n.cookies = map[string]uint64{}
+	}
+	s.nodes[s.ids] = n
+
+	return n
+}
+
+// tick moves the clock of s forward, returning the new time
+func (s *Server) tick() nfs.NFS3Time {
+	now := time.Now()
+	if !now.After(s.now) {
+		now = s.now.Add(time.Nanosecond)
+	}
+	s.now = now
+
+	return nfs.NFS3Time{Seconds: uint32(now.Unix()), Nseconds: uint32(now.Nanosecond())}
+}
+
+// touch records a change to the contents of n, or only to its attributes
+// unless data is set
+func (s *Server) touch(n *node, data bool) {
+	now := s.tick()
+	n.attr.Ctime = now
+	if data {
+		n.attr.Mtime = now
+	}
+	if n.entries == nil {
+		n.attr.Filesize = uint64(len(n.data))
+		n.attr.Used = n.attr.Filesize
+	}
+}
+
+// link adds the node id to the directory dir as name
+func (s *Server) link(dir *node, name string, id uint64) {
+	s.ids++
+	dir.entries[name] = id
+	dir.cookies[name] = s.ids
+	s.touch(dir, true)
+
+	if n := s.nodes[id]; n.entries != nil {
+		n.parent = dir.attr.Fileid
+		dir.attr.Nlink++
+	}
+}
+
+// unlink removes name from the directory dir, dropping its node once it has
+// no links left
+func (s *Server) unlink(dir *node, name string) {
+	id := dir.entries[name]
+	delete(dir.entries, name)
+	delete(dir.cookies, name)
+	s.touch(dir, true)
+
+	n := s.nodes[id]
+	if n.entries != nil {
+		dir.attr.Nlink--
+		delete(s.nodes, id)
+		return
+	}
+
+	n.attr.Nlink--
+	s.touch(n, false)
+	if n.attr.Nlink == 0 {
+		delete(s.nodes, id)
+	}
+}
+
+// encoder writes the XDR encoding of a reply
+type encoder struct {
+	bytes.Buffer
+}
+
+func (w *encoder) u32(vs ...uint32) {
+	for _, v := range vs {
+		binary.Write(w, binary.BigEndian, v)
+	}
+}
+
+func (w *encoder) u64(v uint64) {
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *encoder) bool(v bool) {
+	if v {
+		w.u32(1)
+	} else {
+		w.u32(0)
+	}
+}
+
+func (w *encoder) opaque(p []byte) {
+	w.u32(uint32(len(p)))
+	w.Write(p)
+	w.Write(make([]byte, (4-len(p)%4)%4))
+}
+
+// attr writes the post_op_attr of n, absent for a nil node
+func (w *encoder) attr(n *node) {
+	w.bool(n != nil)
+	if n != nil {
+		xdr.Write(w, n.attr)
+	}
+}
+
+// wcc writes the wcc_data of n, with the attributes after the call only
+func (w *encoder) wcc(n *node) {
+	w.u32(0)
+	w.attr(n)
+}
+
+// created writes the post_op_fh3, post_op_attr and wcc_data of a call
+// creating the node id in dir
+func (s *Server) created(w *encoder, id uint64, dir *node) {
+	w.u32(1)
+	w.opaque(handle(id))
+	w.attr(s.nodes[id])
+	w.wcc(dir)
+}
+
+// node returns the node with the handle fh, if it still exists
+func (s *Server) node(fh []byte) (*node, uint32) {
+	if len(fh) != 8 {
+		return nil, nfs.NFS3ErrBadHandle
+	}
+
+	n, ok := s.nodes[binary.BigEndian.Uint64(fh)]
+	if !ok {
+		return nil, nfs.NFS3ErrStale
+	}
+
+	return n, nfs.NFS3Ok
+}
+
+// dir returns the directory with the handle fh
+func (s *Server) dir(fh []byte) (*node, uint32) {
+	n, status := s.node(fh)
+	if status == nfs.NFS3Ok && n.entries == nil {
+		return nil, nfs.NFS3ErrNotDir
+	}
+
+	return n, status
+}
+
+// the trailing words of the results of each procedure failing, being unset
+// post_op_attr or wcc_data
+var failWords = map[uint32]int{
+	nfs.NFSProc3GetAttr:     0,
+	nfs.NFSProc3SetAttr:     2,
+	nfs.NFSProc3Lookup:      1,
+	nfs.NFSProc3Access:      1,
+	nfs.NFSProc3Readlink:    1,
+	nfs.NFSProc3Read:        1,
+	nfs.NFSProc3Write:       2,
+	nfs.NFSProc3Create:      2,
+	nfs.NFSProc3Mkdir:       2,
+	nfs.NFSProc3Symlink:     2,
+	nfs.NFSProc3Mknod:       2,
+	nfs.NFSProc3Remove:      2,
+	nfs.NFSProc3RmDir:       2,
+	nfs.NFSProc3Rename:      4,
+	nfs.NFSProc3Link:        3,
+	nfs.NFSProc3ReadDir:     1,
+	nfs.NFSProc3ReadDirPlus: 1,
+	nfs.NFSProc3FSStat:      1,
+	nfs.NFSProc3FSInfo:      1,
+	nfs.NFSProc3PathConf:    1,
+	nfs.NFSProc3Commit:      2,
+}
+
+// nfsProc runs the NFS procedure proc with the arguments in r, writing its
+// results to w after the accept_stat it returns
+func (s *Server) nfsProc(proc uint32, r io.Reader, w *encoder) uint32 {
+	if proc == 0 {
+		w.u32(success)
+		return success
+	}
+
+	words, ok := failWords[proc]
+	if !ok {
+		return procUnavail
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[proc]++
+	status, res := s.fail[proc], new(encoder)
+	if status == nfs.NFS3Ok {
+		var err error
+		if status, err = s.run(proc, r, res); err != nil {
+			return garbageArgs
+		}
+	}
+
+	w.u32(success, status)
+	if status != nfs.NFS3Ok {
+		w.Write(make([]byte, 4*words))
+		return success
+	}
+	w.Write(res.Bytes())
+
+	return success
+}
+
+// diropargs3 names a file in a directory
+type diropargs3 struct {
+	FH   []byte
+	Name string
+}
+
+// run runs the NFS procedure proc with the arguments in r, writing the results
+// following a successful status to w.  It returns an error for arguments it
+// cannot decode.
+func (s *Server) run(proc uint32, r io.Reader, w *encoder) (uint32, error) {
+	switch proc {
+	case nfs.NFSProc3GetAttr:
+		var fh []byte
+		if err := xdr.Read(r, &fh); err != nil {
+			return 0, err
+		}
+
+		n, status := s.node(fh)
+		if status == nfs.NFS3Ok {
+			xdr.Write(w, n.attr)
+		}
+		return status, nil
+
+	case nfs.NFSProc3SetAttr:
+		var args struct {
+			FH    []byte
+			Attr  nfs.Sattr3
+			Check bool
+		}
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+		var guard nfs.NFS3Time
+		if args.Check {
+			if err := xdr.Read(r, &guard); err != nil {
+				return 0, err
+			}
+		}
+
+		n, status := s.node(args.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+		if args.Check && guard != n.attr.Ctime {
+			return nfs.NFS3ErrNotSync, nil
+		}
+		if args.Attr.Size.SetIt && n.entries != nil {
+			return nfs.NFS3ErrIsDir, nil
+		}
+
+		s.setAttr(n, args.Attr)
+		w.wcc(n)
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3Lookup:
+		var args diropargs3
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+
+		dir, status := s.dir(args.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+
+		id, ok := dir.entries[args.Name]
+		if args.Name == ".." {
+			id, ok = dir.parent, true
+		}
+		if !ok {
+			return nfs.NFS3ErrNoEnt, nil
+		}
+
+		w.opaque(handle(id))
+		w.attr(s.nodes[id])
+		w.attr(dir)
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3Access:
+		var args struct {
+			FH     []byte
+			Access uint32
+		}
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+
+		n, status := s.node(args.FH)
+		if status == nfs.NFS3Ok {
+			w.attr(n)
+			w.u32(args.Access)
+		}
+		return status, nil
+
+	case nfs.NFSProc3Readlink:
+		var fh []byte
+		if err := xdr.Read(r, &fh); err != nil {
+			return 0, err
+		}
+
+		n, status := s.node(fh)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+		if n.attr.Type != nfs.NF3Lnk {
+			return nfs.NFS3ErrInval, nil
+		}
+
+		w.attr(n)
+		w.opaque(n.data)
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3Read:
+		var args struct {
+			FH     []byte
+			Offset uint64
+			Count  uint32
+		}
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+
+		n, status := s.node(args.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+		if n.entries != nil {
+			return nfs.NFS3ErrIsDir, nil
+		}
+
+		off := min(args.Offset, uint64(len(n.data)))
+		end := min(off+uint64(args.Count), uint64(len(n.data)))
+
+		w.attr(n)
+		w.u32(uint32(end - off))
+		w.bool(end == uint64(len(n.data)))
+		w.opaque(n.data[off:end])
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3Write:
+		var args struct {
+			FH     []byte
+			Offset uint64
+			Count  uint32
+			Stable uint32
+			Data   []byte
+		}
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+
+		n, status := s.node(args.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+		if n.entries != nil {
+			return nfs.NFS3ErrIsDir, nil
+		}
+
+		if end := args.Offset + uint64(len(args.Data)); end > uint64(len(n.data)) {
+			n.data = append(n.data, make([]byte, end-uint64(len(n.data)))...)
+		}
+		copy(n.data[args.Offset:], args.Data)
+		s.touch(n, true)
+
+		// the data is as durable as asked for, all of it being lost alike
+		w.wcc(n)
+		w.u32(uint32(len(args.Data)), args.Stable)
+		w.u64(1)
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3Create:
+		var args struct {
+			Where diropargs3
+			Mode  uint32
+		}
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+		var attr nfs.Sattr3
+		if args.Mode == 2 {
+			var verf uint64
+			if err := xdr.Read(r, &verf); err != nil {
+				return 0, err
+			}
+		} else if err := xdr.Read(r, &attr); err != nil {
+			return 0, err
+		}
+
+		dir, status := s.dir(args.Where.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+		if id, ok := dir.entries[args.Where.Name]; ok {
+			if args.Mode == 0 && s.nodes[id].entries == nil {
+				s.setAttr(s.nodes[id], attr)
+				s.created(w, id, dir)
+				return nfs.NFS3Ok, nil
+			}
+			return nfs.NFS3ErrExist, nil
+		}
+
+		n := s.newNode(nfs.NF3Reg, 0o644)
+		s.setAttr(n, attr)
+		s.link(dir, args.Where.Name, n.attr.Fileid)
+		s.created(w, n.attr.Fileid, dir)
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3Mkdir, nfs.NFSProc3Symlink:
+		var args struct {
+			Where diropargs3
+			Attr  nfs.Sattr3
+		}
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+		var target string
+		if proc == nfs.NFSProc3Symlink {
+			if err := xdr.Read(r, &target); err != nil {
+				return 0, err
+			}
+		}
+
+		dir, status := s.dir(args.Where.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+		if _, ok := dir.entries[args.Where.Name]; ok {
+			return nfs.NFS3ErrExist, nil
+		}
+
+		var n *node
+		if proc == nfs.NFSProc3Mkdir {
+			n = s.newNode(nfs.NF3Dir, 0o755)
+			n.entries["."] = n.attr.Fileid
+		} else {
+			n = s.newNode(nfs.NF3Lnk, 0o777)
+			n.data = []byte(target)
+		}
+		s.setAttr(n, args.Attr)
+		s.link(dir, args.Where.Name, n.attr.Fileid)
+		s.created(w, n.attr.Fileid, dir)
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3Mknod:
+		return nfs.NFS3ErrNotSupp, nil
+
+	case nfs.NFSProc3Remove, nfs.NFSProc3RmDir:
+		var args diropargs3
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+
+		dir, status := s.dir(args.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+		id, ok := dir.entries[args.Name]
+		if !ok || args.Name == "." {
+			return nfs.NFS3ErrNoEnt, nil
+		}
+
+		n := s.nodes[id]
+		switch {
+		case proc == nfs.NFSProc3Remove && n.entries != nil:
+			return nfs.NFS3ErrIsDir, nil
+		case proc == nfs.NFSProc3RmDir && n.entries == nil:
+			return nfs.NFS3ErrNotDir, nil
+		case len(n.entries) > 1:
+			return nfs.NFS3ErrNotEmpty, nil
+		}
+
+		s.unlink(dir, args.Name)
+		w.wcc(dir)
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3Rename:
+		var args struct {
+			From, To diropargs3
+		}
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+
+		from, status := s.dir(args.From.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+		to, status := s.dir(args.To.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+		id, ok := from.entries[args.From.Name]
+		if !ok || args.From.Name == "." {
+			return nfs.NFS3ErrNoEnt, nil
+		}
+
+		if old, ok := to.entries[args.To.Name]; ok && old != id {
+			if n := s.nodes[old]; len(n.entries) > 1 {
+				return nfs.NFS3ErrNotEmpty, nil
+			} else if (n.entries != nil) != (s.nodes[id].entries != nil) {
+				return nfs.NFS3ErrExist, nil
+			}
+			s.unlink(to, args.To.Name)
+		}
+		if to.entries[args.To.Name] != id {
+			delete(from.entries, args.From.Name)
+			delete(from.cookies, args.From.Name)
+			s.touch(from, true)
+			if s.nodes[id].entries != nil {
+				from.attr.Nlink--
+			}
+			s.link(to, args.To.Name, id)
+		}
+
+		w.wcc(from)
+		w.wcc(to)
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3Link:
+		var args struct {
+			FH   []byte
+			Link diropargs3
+		}
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+
+		n, status := s.node(args.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+		dir, status := s.dir(args.Link.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+		if n.entries != nil {
+			return nfs.NFS3ErrIsDir, nil
+		}
+		if _, ok := dir.entries[args.Link.Name]; ok {
+			return nfs.NFS3ErrExist, nil
+		}
+
+		n.attr.Nlink++
+		s.touch(n, false)
+		s.link(dir, args.Link.Name, n.attr.Fileid)
+
+		w.attr(n)
+		w.wcc(dir)
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3ReadDir, nfs.NFSProc3ReadDirPlus:
+		var args struct {
+			FH     []byte
+			Cookie uint64
+			Verf   uint64
+			Count  uint32
+		}
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+		if proc == nfs.NFSProc3ReadDirPlus {
+			var maxCount uint32
+			if err := xdr.Read(r, &maxCount); err != nil {
+				return 0, err
+			}
+		}
+
+		dir, status := s.dir(args.FH)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+
+		names := s.listing(dir)
+		i := 0
+		if args.Cookie != 0 {
+			i = sort.Search(len(names), func(i int) bool { return dir.cookies[names[i]] > args.Cookie })
+		}
+
+		w.attr(dir)
+		w.u64(0)
+		for j := i; j < len(names) && j < i+maxDirEntries; j++ {
+			id := dir.entries[names[j]]
+			if names[j] == ".." {
+				id = dir.parent
+			}
+
+			w.u32(1)
+			w.u64(id)
+			w.opaque([]byte(names[j]))
+			w.u64(dir.cookies[names[j]])
+			if proc == nfs.NFSProc3ReadDirPlus {
+				if s.bare {
+					w.attr(nil)
+				} else {
+					w.attr(s.nodes[id])
+				}
+				w.u32(1)
+				w.opaque(handle(id))
+			}
+		}
+		w.u32(0)
+		w.bool(i+maxDirEntries >= len(names))
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3FSStat, nfs.NFSProc3FSInfo, nfs.NFSProc3PathConf:
+		var fh []byte
+		if err := xdr.Read(r, &fh); err != nil {
+			return 0, err
+		}
+
+		n, status := s.node(fh)
+		if status != nfs.NFS3Ok {
+			return status, nil
+		}
+
+		w.attr(n)
+		switch proc {
+		case nfs.NFSProc3FSStat:
+			xdr.Write(w, struct {
+				TBytes, FBytes, ABytes, TFiles, FFiles, AFiles uint64
+				Invarsec                                       uint32
+			}{1 << 30, 1 << 29, 1 << 29, 1 << 20, 1 << 19, 1 << 19, 0})
+		case nfs.NFSProc3FSInfo:
+			w.u32(1<<20, 1<<16, 4096, 1<<20, 1<<16, 4096, 1<<16)
+			w.u64(1 << 40)
+			w.u32(0, 1, 0x1b)
+		case nfs.NFSProc3PathConf:
+			w.u32(1<<16, 255)
+			w.bool(true)
+			w.bool(true)
+			w.bool(false)
+			w.bool(true)
+		}
+		return nfs.NFS3Ok, nil
+
+	case nfs.NFSProc3Commit:
+		var args struct {
+			FH     []byte
+			Offset uint64
+			Count  uint32
+		}
+		if err := xdr.Read(r, &args); err != nil {
+			return 0, err
+		}
+
+		n, status := s.node(args.FH)
+		if status == nfs.NFS3Ok {
+			w.wcc(n)
+			w.u64(1)
+		}
+		return status, nil
+	}
+
+	return nfs.NFS3ErrNotSupp, nil
+}
+
+// listing returns the names in dir in the order of their cookies, "." and
+// ".." first
+func (s *Server) listing(dir *node) []string {
+	if _, ok := dir.cookies["."]; !ok {
+		dir.cookies["."], dir.cookies[".."] = 1, 2
+	}
+
+	names := []string{".."}
+	for name := range dir.entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return dir.cookies[names[i]] < dir.cookies[names[j]] })
+
+	return names
+}
+
+// setAttr sets the attributes of attr on n
+func (s *Server) setAttr(n *node, attr nfs.Sattr3) {
+	if attr.Mode.SetIt {
+		n.attr.FileMode = attr.Mode.Mode & 0o7777
+	}
+	if attr.UID.SetIt {
+		n.attr.UID = attr.UID.UID
+	}
+	if attr.GID.SetIt {
+		n.attr.GID = attr.GID.UID
+	}
+	if attr.Size.SetIt {
+		if attr.Size.Size < uint64(len(n.data)) {
+			n.data = n.data[:attr.Size.Size]
+		} else {
+			n.data = append(n.data, make([]byte, attr.Size.Size-uint64(len(n.data)))...)
+		}
+	}
+	s.touch(n, attr.Size.SetIt)
+
+	for _, t := range []struct {
+		set nfs.SetTime
+		to  *nfs.NFS3Time
+	}{{attr.Atime, &n.attr.Atime}, {attr.Mtime, &n.attr.Mtime}} {
+		switch t.set.SetIt {
+		case nfs.SetToServerTime:
+			*t.to = s.tick()
+		case nfs.SetToClientTime:
+			*t.to = t.set.Time
+		}
+	}
+}
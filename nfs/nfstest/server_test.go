@@ -0,0 +1,34 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfstest
+
+import (
+	"fmt"
+	"testing"
+)
+
+// test fstest.TestFS passes over an export, with directories listed in
+// several replies
+func TestServerFS(t *testing.T) {
+	_, v := Mount(t)
+
+	if err := v.MkdirAll("dir/sub", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := v.WriteFile(fmt.Sprintf("dir/file%d", i), []byte(fmt.Sprint(i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := v.WriteFile("dir/sub/empty", nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := v.Symlink("sub/empty", "dir/link", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := TestFS(v, "dir/file0", "dir/file19", "dir/sub/empty"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/nfstest"
+)
+
+// test the error of a listing failing is returned by Err once Events is
+// drained
+func TestWatchListFails(t *testing.T) {
+	s, v := nfstest.Mount(t)
+
+	if _, err := v.Mkdir("dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := v.Watch("dir", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	s.Fail(nfs.NFSProc3ReadDirPlus, nfs.NFS3ErrIO)
+	if err = v.WriteFile("dir/file", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for range w.Events() {
+	}
+	if err = w.Err(); err == nil {
+		t.Fatal("no error once the listing failed")
+	}
+}
+
+// test the absolute symlinks are resolved within the export, the targets
+// outside of it not existing
+func TestAbsoluteSymlink(t *testing.T) {
+	s, root := nfstest.Mount(t)
+
+	if err := root.MkdirAll("export/dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"export/dir/file", "secret"} {
+		if err := root.WriteFile(name, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"export/inside":  "/export/dir/file",
+		"export/outside": "/secret",
+		"export/escape":  "/export/../secret",
+	} {
+		if _, _, err := root.Symlink(target, link, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v, err := s.Mount("/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	if data, err := v.ReadFile("inside"); string(data) != "export/dir/file" || err != nil {
+		t.Errorf("read through inside = %q, %v", data, err)
+	}
+	for _, link := range []string{"outside", "escape"} {
+		if data, err := v.ReadFile(link); !errors.Is(err, nfs.ErrOutsideExport) || !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("read through %s = %q, %v, want ErrOutsideExport", link, data, err)
+		}
+	}
+}
+
+// test the absolute symlinks under a Sub resolve from the root of the export
+func TestSubAbsoluteSymlink(t *testing.T) {
+	_, v := nfstest.Mount(t)
+
+	if err := v.MkdirAll("a/b", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.WriteFile("a/file", []byte("a/file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := v.Symlink("/a/file", "a/b/link", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := v.Sub("a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := sub.ReadFile("link"); string(data) != "a/file" || err != nil {
+		t.Errorf("read through link = %q, %v, want a/file", data, err)
+	}
+	if sub, err = sub.Sub("."); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := sub.ReadFile("link"); string(data) != "a/file" || err != nil {
+		t.Errorf("read through link of a Sub of a Sub = %q, %v, want a/file", data, err)
+	}
+}
+
+// test the reads validated against the attributes of a File are not failed by
+// the pipelined writes of the File itself, but are by those of another one
+func TestValidateOwnWrites(t *testing.T) {
+	_, v := nfstest.Mount(t)
+
+	f, err := v.OpenFile("file", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.SetValidateReads(true)
+	if err = f.SetPipelined(true); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	for i := 0; i < 16; i++ {
+		if _, err = f.WriteAt(buf, int64(i)*4096); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.ReadAt(buf[:1], 0); err != nil {
+			t.Fatalf("read after write %d: %v", i, err)
+		}
+	}
+	if err = f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.ReadAt(buf[:1], 0); err != nil {
+		t.Fatalf("read after sync: %v", err)
+	}
+
+	if err = v.WriteFile("file", []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.ReadAt(buf[:1], 0); !nfs.IsChangedError(err) {
+		t.Errorf("read after another writer = %v, want a ChangedError", err)
+	}
+}
+
+// test a File both reading ahead and validating its reads reads the file
+func TestValidateReadAhead(t *testing.T) {
+	_, v := nfstest.Mount(t)
+
+	data := bytes.Repeat([]byte("data"), 100000)
+	if err := v.WriteFile("file", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := v.Open("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.SetReadAhead(4)
+	f.SetValidateReads(true)
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes, want %d", len(got), len(data))
+	}
+}
+
+// test a failed pipelined WRITE is reported once, the writes after it
+// succeeding
+func TestPipelineError(t *testing.T) {
+	s, v := nfstest.Mount(t)
+
+	f, err := v.OpenFile("file", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err = f.SetPipelined(true); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Fail(nfs.NFSProc3Write, nfs.NFS3ErrNoSpc)
+	if _, err = f.Write([]byte("lost")); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Sync(); err == nil {
+		t.Fatal("sync after a failed write succeeded")
+	}
+
+	s.Fail(nfs.NFSProc3Write, 0)
+	if _, err = f.WriteAt([]byte("kept"), 0); err != nil {
+		t.Errorf("write after the error was reported: %v", err)
+	}
+	if err = f.Sync(); err != nil {
+		t.Errorf("sync after the error was reported: %v", err)
+	}
+}
+
+// test the data written with Unstable and kept for resending is committed
+// once it grows too large, rather than at Close only
+func TestUnstableBound(t *testing.T) {
+	s, v := nfstest.Mount(t)
+
+	f, err := v.OpenFile("file", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.SetStability(nfs.Unstable)
+	f.SetMaxInFlightBytes(1)
+
+	buf := make([]byte, 1<<16)
+	for i := 0; i < 16; i++ {
+		if _, err = f.Write(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := s.Calls(nfs.NFSProc3Commit); n < 3 {
+		t.Errorf("%d COMMITs while writing 16 times the preferred size, want 3 or more", n)
+	}
+}
+
+// test the files opened through nfs.FS are read-only and send no COMMIT when
+// closed
+func TestFSOpenReadOnly(t *testing.T) {
+	s, v := nfstest.Mount(t)
+
+	if err := v.WriteFile("file", []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	commits := s.Calls(nfs.NFSProc3Commit)
+	f, err := nfs.FS(v).Open("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(f); string(data) != "data" || err != nil {
+		t.Errorf("read %q, %v, want data", data, err)
+	}
+	if _, err = f.(io.Writer).Write([]byte("x")); err != nfs.ErrNotWritable {
+		t.Errorf("write = %v, want %v", err, nfs.ErrNotWritable)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.Calls(nfs.NFSProc3Commit) - commits; n != 0 {
+		t.Errorf("%d COMMITs, want none", n)
+	}
+}
+
+// test serving a file over HTTP sends no COMMIT
+func TestHTTPFileServerReadOnly(t *testing.T) {
+	s, v := nfstest.Mount(t)
+
+	if err := v.WriteFile("file", []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	commits := s.Calls(nfs.NFSProc3Commit)
+	w := httptest.NewRecorder()
+	nfs.HTTPFileServer(v).ServeHTTP(w, httptest.NewRequest("GET", "/file", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "data" {
+		t.Errorf("GET = %d %q, want 200 data", w.Code, w.Body.String())
+	}
+
+	if n := s.Calls(nfs.NFSProc3Commit) - commits; n != 0 {
+		t.Errorf("%d COMMITs, want none", n)
+	}
+}
+
+// test the entries listed without attributes are looked up
+func TestEntriesLookup(t *testing.T) {
+	s, v := nfstest.Mount(t)
+
+	if _, err := v.Mkdir("dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.WriteFile("file", []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.OmitEntryAttrs(true)
+	got := map[string]bool{}
+	for info, err := range v.Entries(".") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[info.Name()] = info.IsDir()
+		if info.Name() == "file" && info.Size() != 4 {
+			t.Errorf("file has size %d, want 4", info.Size())
+		}
+	}
+
+	if len(got) != 2 || !got["dir"] || got["file"] {
+		t.Errorf("entries = %v, want dir a directory and file not", got)
+	}
+}
+
+// test DiskUsage adds up the files of a tree wider than the directories it
+// lists at once, with the entries listed with or without attributes
+func TestDiskUsage(t *testing.T) {
+	s, v := nfstest.Mount(t)
+
+	for i := 0; i < 20; i++ {
+		dir := fmt.Sprintf("top/d%02d/sub", i)
+		if err := v.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.WriteFile(dir+"/file", []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, bare := range []bool{false, true} {
+		s.OmitEntryAttrs(bare)
+		u, err := v.DiskUsage("top")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u.Files != 20 || u.Dirs != 41 || u.Size < 80 || len(u.Children) != 20 {
+			t.Fatalf("bare %v: %d files, %d dirs, size %d, %d children, want 20, 41, at least 80 and 20",
+				bare, u.Files, u.Dirs, u.Size, len(u.Children))
+		}
+		if sub := u.Children[3]; sub.Path != "top/d03" || sub.Files != 1 || sub.Dirs != 2 {
+			t.Errorf("bare %v: child %s has %d files, %d dirs, want top/d03 with 1 and 2",
+				bare, sub.Path, sub.Files, sub.Dirs)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"sync"
+)
+
+const (
+	// defaultWriteWindow is the number of pipelined WRITEs of the preferred
+	// size a File keeps in flight by default
+	defaultWriteWindow = 8
+
+	// maxDefaultWindowBytes caps the default amount of data in flight
+	maxDefaultWindowBytes = 16 << 20
+
+	// uncommittedFactor times the bytes in flight is the amount of Unstable
+	// data kept for resending before a File commits early
+	uncommittedFactor = 4
+)
+
+// pipeline issues Unstable WRITEs for a File in the background, keeping a
+// bounded number of them and of bytes in flight
+type pipeline struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// the first WRITE error since the last one reported
+	err error
+
+	maxWrites int
+	maxBytes  int
+	writes    int
+	bytes     int
+}
+
+func newPipeline(maxWrites, maxBytes int) *pipeline {
+	p := &pipeline{
+		maxWrites: maxWrites,
+		maxBytes:  maxBytes,
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	return p
+}
+
+// SetPipelined switches the file to writing asynchronously.  Write then copies
+// the data, issues Unstable WRITEs of the server's preferred size, keeping
+// several of them in flight, and returns without waiting for the replies.  A
+// failed WRITE is reported once, by the first Write, Sync, Commit or Close
+// after it, which returns the error of the earliest WRITE failed since the
+// previous error was reported.  The calls after that succeed unless further
+// WRITEs fail, the data of the failed ones being lost.  Sync, Commit and Close
+// wait for the outstanding WRITEs before committing them.
+// Switching pipelining off flushes and commits the pending data.
+func (f *File) SetPipelined(on bool) error {
+	if on {
+		if f.pipe == nil {
+			maxWrites, maxBytes := f.writeWindow()
+			f.pipe = newPipeline(maxWrites, maxBytes)
+		}
+		return nil
+	}
+
+	if f.pipe == nil {
+		return nil
+	}
+
+	err := f.Sync()
+	f.pipe = nil
+
+	return err
+}
+
+// SetMaxInFlightWrites bounds the number of pipelined WRITEs in flight.  It
+// defaults to as many WRITEs of the server's preferred size as fit in the
+// default for SetMaxInFlightBytes.
+func (f *File) SetMaxInFlightWrites(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	f.maxWrites = n
+	if f.pipe != nil {
+		f.pipe.setLimits(f.writeWindow())
+	}
+}
+
+// SetMaxInFlightBytes bounds the amount of data held by pipelined WRITEs in
+// flight.  It defaults to 8 WRITEs of the server's preferred size, but at most
+// 16MiB.  Data accepted as Unstable is kept for resending until committed, so
+// the file is also committed whenever that exceeds four times this amount,
+// which bounds the memory a pipelined File uses.
+func (f *File) SetMaxInFlightBytes(n int) {
+	f.maxBytes = n
+	if f.pipe != nil {
+		f.pipe.setLimits(f.writeWindow())
+	}
+}
+
+// writeWindow returns the limits for pipelined WRITEs, derived from the
+// preferred WRITE size unless set explicitly
+func (f *File) writeWindow() (int, int) {
+	pref := int(f.fsinfo.WTPref)
+	if pref < 1 {
+		pref = 1
+	}
+
+	maxBytes := f.maxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultWriteWindow * pref
+		if maxBytes > maxDefaultWindowBytes {
+			maxBytes = maxDefaultWindowBytes
+		}
+	}
+
+	// a single WRITE of the preferred size always has to fit
+	if maxBytes < pref {
+		maxBytes = pref
+	}
+
+	maxWrites := f.maxWrites
+	if maxWrites <= 0 {
+		maxWrites = maxBytes / pref
+	}
+
+	return maxWrites, maxBytes
+}
+
+func (p *pipeline) setLimits(maxWrites, maxBytes int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxWrites, p.maxBytes = maxWrites, maxBytes
+	p.cond.Broadcast()
+}
+
+// acquire blocks until a WRITE of size bytes fits into the window
+func (p *pipeline) acquire(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.writes > 0 && (p.writes >= p.maxWrites || p.bytes+size > p.maxBytes) {
+		p.cond.Wait()
+	}
+
+	p.writes++
+	p.bytes += size
+}
+
+func (p *pipeline) release(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.writes--
+	p.bytes -= size
+	p.cond.Broadcast()
+}
+
+// write queues p to be written at off and returns once every chunk of it has
+// been handed to a WRITE in flight
+func (p *pipeline) write(f *File, b []byte, off uint64) (int, error) {
+	if err := p.take(); err != nil {
+		return 0, err
+	}
+
+	total := uint32(len(b))
+	for queued := uint32(0); queued < total; {
+		size := min(f.fsinfo.WTPref, total-queued)
+
+		// the caller is free to reuse b once Write returns
+		chunk := make([]byte, size)
+		copy(chunk, b[queued:queued+size])
+
+		p.acquire(len(chunk))
+		go func(chunk []byte, off uint64) {
+			defer p.release(len(chunk))
+
+			committed, verf, err := f.writeFull(chunk, off, Unstable)
+			if err != nil {
+				p.fail(err)
+				return
+			}
+
+			if committed == Unstable {
+				f.uc.add(off, chunk, verf)
+			}
+		}(chunk, off+uint64(queued))
+
+		queued += size
+	}
+
+	p.mu.Lock()
+	limit := uncommittedFactor * p.maxBytes
+	p.mu.Unlock()
+
+	if f.uc.size() > limit {
+		if err := f.Commit(0, 0); err != nil {
+			return len(b), err
+		}
+	}
+
+	return len(b), nil
+}
+
+// writeFull writes all of b at off, retrying the remainder of short writes
+func (f *File) writeFull(b []byte, off uint64, how StableHow) (StableHow, uint64, error) {
+	var (
+		committed StableHow
+		verf      uint64
+	)
+	for written := uint32(0); written < uint32(len(b)); {
+		count, c, v, err := f.write(b[written:], off+uint64(written), how)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		committed, verf = c, v
+		written += count
+	}
+
+	return committed, verf, nil
+}
+
+// fail records the error a WRITE failed with
+func (p *pipeline) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// take returns the first error a WRITE failed with since the last one
+// returned, reporting it
+func (p *pipeline) take() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := p.err
+	p.err = nil
+
+	return err
+}
+
+// wait blocks until all WRITEs in flight have completed, and takes the error
+// of those failed.  Unlike a WaitGroup it may be used while other goroutines
+// keep issuing WRITEs.
+func (p *pipeline) wait() error {
+	p.mu.Lock()
+	for p.writes > 0 {
+		p.cond.Wait()
+	}
+	p.mu.Unlock()
+
+	return p.take()
+}
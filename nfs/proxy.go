@@ -0,0 +1,38 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"golang.org/x/net/proxy"
+)
+
+var errProxyOverUDP = errors.New("nfs: SOCKS5 proxies need TCP")
+
+// dialProxy opens a connection to addr through the SOCKS5 proxy of d
+func (d *Dialer) dialProxy(ctx context.Context, network, addr string) (net.Conn, error) {
+	pd, err := proxy.SOCKS5("tcp", d.SOCKS5, d.SOCKS5Auth, forwarder{d})
+	if err != nil {
+		return nil, err
+	}
+
+	return pd.(proxy.ContextDialer).DialContext(ctx, network, addr)
+}
+
+// forwarder dials the SOCKS5 proxy of a Dialer, with its DialContext or
+// NetDialer when set and its socket options
+type forwarder struct {
+	d *Dialer
+}
+
+func (f forwarder) Dial(network, addr string) (net.Conn, error) {
+	return f.DialContext(context.Background(), network, addr)
+}
+
+func (f forwarder) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f.d.dialSocket(ctx, network, nil, addr)
+}
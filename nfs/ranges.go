@@ -0,0 +1,112 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxRangeReads bounds the number of READs ReadRanges keeps in flight
+const maxRangeReads = 16
+
+// Range is a byte range of a file
+type Range struct {
+	Offset int64
+	Length int
+}
+
+// ReadRanges reads several, possibly discontiguous, byte ranges of the file and
+// returns a buffer for each of them.  The READs for all ranges are issued
+// concurrently instead of one after the other.  A buffer is shorter than its
+// range when the range extends past the end of the file.  The current offset
+// is not changed.
+func (f *File) ReadRanges(ranges []Range) ([][]byte, error) {
+	if !f.readable() {
+		return nil, ErrNotReadable
+	}
+
+	for _, r := range ranges {
+		if r.Offset < 0 || r.Length < 0 {
+			return nil, errors.New("range cannot be negative")
+		}
+	}
+
+	if err := f.drain(); err != nil {
+		return nil, err
+	}
+
+	type chunk struct {
+		buf   []byte
+		off   uint64
+		n     int
+		err   error
+		index int
+	}
+
+	pref := int(f.fsinfo.RTPref)
+	if pref < 1 {
+		pref = 1
+	}
+
+	bufs := make([][]byte, len(ranges))
+	chunks := []*chunk{}
+	for i, r := range ranges {
+		bufs[i] = make([]byte, r.Length)
+		for pos := 0; pos < r.Length; pos += pref {
+			end := pos + pref
+			if end > r.Length {
+				end = r.Length
+			}
+
+			chunks = append(chunks, &chunk{
+				buf:   bufs[i][pos:end],
+				off:   uint64(r.Offset) + uint64(pos),
+				index: i,
+			})
+		}
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxRangeReads)
+	for _, c := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(c *chunk) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			c.n, c.err = f.readFullAt(c.buf, c.off)
+		}(c)
+	}
+	wg.Wait()
+
+	// chunks are in offset order within a range, so a range ends with its
+	// first short chunk
+	short := make([]bool, len(ranges))
+	lengths := make([]int, len(ranges))
+	for _, c := range chunks {
+		if c.err != nil && c.err != io.EOF {
+			return nil, c.err
+		}
+
+		if short[c.index] {
+			continue
+		}
+
+		lengths[c.index] += c.n
+		if c.n < len(c.buf) {
+			short[c.index] = true
+		}
+	}
+
+	for i := range bufs {
+		bufs[i] = bufs[i][:lengths[i]]
+	}
+
+	return bufs, nil
+}
@@ -0,0 +1,127 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"io"
+)
+
+// readahead keeps READs for the chunks following the current offset in flight
+// while the caller consumes the data of the current one.  The queue holds
+// contiguous chunks in offset order.
+type readahead struct {
+	depth int
+	queue []*prefetch
+}
+
+// prefetch is a READ issued ahead of time
+type prefetch struct {
+	off  uint64
+	done chan struct{}
+
+	// valid once done is closed
+	buf []byte
+	eof bool
+	err error
+
+	// how much of buf was handed to the caller
+	pos int
+}
+
+// SetReadAhead makes Read and WriteTo issue READs for the next n chunks of the
+// server's preferred size while the current one is being consumed.  This helps
+// streaming large files over high latency links.  Seeking or writing discards
+// the prefetched data.  A value of 0 disables read-ahead.
+func (f *File) SetReadAhead(n int) {
+	if n <= 0 {
+		f.ra = nil
+		return
+	}
+
+	f.ra = &readahead{depth: n}
+}
+
+// read reads sequentially at the current offset from the prefetched chunks.
+// It is called with f.mu held, which is released while waiting for a READ
+// since the READ takes it to validate the attributes returned.
+func (ra *readahead) read(f *File, p []byte) (int, error) {
+	var head *prefetch
+	for head == nil {
+		if len(ra.queue) == 0 || ra.queue[0].off+uint64(ra.queue[0].pos) != f.curr {
+			ra.reset()
+			ra.fill(f, f.curr)
+		}
+
+		head = ra.queue[0]
+		f.mu.Unlock()
+		<-head.done
+		f.mu.Lock()
+
+		// the queue was dropped while waiting, the file being modified
+		if len(ra.queue) == 0 || ra.queue[0] != head {
+			head = nil
+		}
+	}
+
+	if head.err != nil {
+		ra.reset()
+		return 0, head.err
+	}
+
+	if len(head.buf) == 0 && !head.eof {
+		ra.reset()
+		return 0, io.ErrNoProgress
+	}
+
+	n := copy(p, head.buf[head.pos:])
+	head.pos += n
+	f.curr += uint64(n)
+
+	if head.pos < len(head.buf) {
+		return n, nil
+	}
+
+	ra.queue = ra.queue[1:]
+	if head.eof {
+		ra.reset()
+		return n, io.EOF
+	}
+
+	// a short READ leaves a gap in front of the chunks queued after it
+	if len(ra.queue) > 0 && ra.queue[0].off != head.off+uint64(len(head.buf)) {
+		ra.reset()
+	}
+	ra.fill(f, f.curr)
+
+	return n, nil
+}
+
+// fill queues READs until depth chunks are in flight, starting at off when the
+// queue is empty
+func (ra *readahead) fill(f *File, off uint64) {
+	for len(ra.queue) < ra.depth {
+		if n := len(ra.queue); n > 0 {
+			off = ra.queue[n-1].off + uint64(f.fsinfo.RTPref)
+		}
+
+		pf := &prefetch{
+			off:  off,
+			done: make(chan struct{}),
+		}
+		go func() {
+			buf := make([]byte, f.fsinfo.RTPref)
+			n, eof, err := f.readAt(buf, pf.off)
+			pf.buf, pf.eof, pf.err = buf[:n], eof, err
+			close(pf.done)
+		}()
+
+		ra.queue = append(ra.queue, pf)
+	}
+}
+
+// reset drops the prefetched chunks.  READs still in flight complete in the
+// background and their results are discarded.
+func (ra *readahead) reset() {
+	ra.queue = nil
+}
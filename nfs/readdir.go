@@ -0,0 +1,410 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"io"
+
+	"github.com/go-nfs/nfsv3/nfs/rpc"
+	"github.com/go-nfs/nfsv3/nfs/util"
+	"github.com/go-nfs/nfsv3/nfs/xdr"
+)
+
+const (
+	// size of the READDIRPLUS replies asked for when the server does not
+	// state a preference
+	defaultDirReplySize = 4096
+
+	// minDirCount is the least amount of directory information asked for
+	minDirCount = 512
+
+	// maxDirRestarts is how often a listing starts over after the server
+	// rejected its cookie
+	maxDirRestarts = 3
+)
+
+// DirIterator lists a directory lazily, issuing the READDIRPLUS for the next
+// batch of entries once the previous batch has been consumed, so that huge
+// directories can be listed without holding all their entries in memory.
+// Against servers which do not support READDIRPLUS, or cannot fit a single
+// entry with its attributes into a reply, it falls back to READDIR, whose
+// entries carry neither attributes nor handles.
+//
+// When the server rejects the cookie to continue from with NFS3ERR_BAD_COOKIE,
+// because the directory changed too much in the meantime, the listing starts
+// over, skipping the entries whose cookies are not past the highest cookie
+// returned so far.  This relies on the cookies growing along the directory, as
+// they do on most servers, and spares remembering every entry returned.
+//
+// Cursor returns the position after the last entry returned, which can be
+// saved to resume the listing later on with ReadDirPlusIterAt, for instance
+// when a crawler of a huge directory restarts.  A listing resumed that way
+// cannot start over and fails with NFS3ERR_BAD_COOKIE instead.
+//
+//	it := v.ReadDirPlusIterByFh(fh)
+//	for it.Next() {
+//		e := it.Entry()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type DirIterator struct {
+	v  *Target
+	fh []byte
+
+	cookie     uint64
+	cookieVerf uint64
+	eof        bool
+
+	// set once listing with READDIR
+	plain bool
+
+	// the highest cookie returned so far, and how often the listing started
+	// over
+	mark     uint64
+	restarts int
+
+	// where the listing was resumed, and the position after the last entry
+	// returned
+	start DirCursor
+	pos   DirCursor
+
+	batch []*EntryPlus
+	entry *EntryPlus
+	err   error
+}
+
+// ReadDirPlusIter returns an iterator over the entries of the directory at
+// path
+func (v *Target) ReadDirPlusIter(dir string) (*DirIterator, error) {
+	_, fh, err := v.Lookup(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.ReadDirPlusIterByFh(fh), nil
+}
+
+// ReadDirPlusIterByFh returns an iterator over the entries of the directory
+// with the handle fh
+func (v *Target) ReadDirPlusIterByFh(fh []byte) *DirIterator {
+	return v.ReadDirPlusIterAt(fh, DirCursor{})
+}
+
+// Next advances to the next entry, returning false once the listing is
+// complete or failed
+func (it *DirIterator) Next() bool {
+	for {
+		for len(it.batch) == 0 {
+			if it.eof || it.err != nil {
+				it.entry = nil
+				return false
+			}
+
+			it.fetch()
+		}
+
+		it.entry, it.batch = it.batch[0], it.batch[1:]
+		if it.restarts == 0 || it.entry.Cookie > it.mark {
+			break
+		}
+	}
+
+	if it.entry.Cookie > it.mark {
+		it.mark = it.entry.Cookie
+	}
+	it.pos = DirCursor{Cookie: it.entry.Cookie, CookieVerf: it.cookieVerf}
+
+	return true
+}
+
+// DirCursor is a position in a directory listing: the cookie of the last
+// entry read together with the verifier of the reply it came with
+type DirCursor struct {
+	Cookie     uint64
+	CookieVerf uint64
+}
+
+// ReadDirPlusIterAt returns an iterator over the entries of the directory with
+// the handle fh following the position c, as returned by Cursor.  The zero
+// DirCursor lists the directory from the start.
+func (v *Target) ReadDirPlusIterAt(fh []byte, c DirCursor) *DirIterator {
+	return &DirIterator{
+		v:          v,
+		fh:         fh,
+		cookie:     c.Cookie,
+		cookieVerf: c.CookieVerf,
+		start:      c,
+		pos:        c,
+	}
+}
+
+// Cursor returns the position after the entry Next advanced to
+func (it *DirIterator) Cursor() DirCursor {
+	return it.pos
+}
+
+// Entry returns the entry Next advanced to
+func (it *DirIterator) Entry() *EntryPlus {
+	return it.entry
+}
+
+// Err returns the error the listing failed with, if any
+func (it *DirIterator) Err() error {
+	return it.err
+}
+
+// fetch reads the next batch of entries, continuing after the cookie of the
+// last entry returned using the verifier of the previous reply
+func (it *DirIterator) fetch() {
+	var (
+		entries    []*EntryPlus
+		cookieVerf uint64
+		eof        bool
+		err        error
+	)
+
+	if !it.plain {
+		entries, cookieVerf, eof, err = it.v.readDirPlus(it.fh, it.cookie, it.cookieVerf)
+		if isNFS3Error(err, NFS3ErrNotSupp) || isNFS3Error(err, NFS3ErrTooSmall) {
+			util.Debugf("readdir(%x): falling back to READDIR: %s", it.fh, err.Error())
+			it.plain = true
+		}
+	}
+
+	if it.plain {
+		entries, cookieVerf, eof, err = it.v.readDir(it.fh, it.cookie, it.cookieVerf)
+	}
+
+	if isNFS3Error(err, NFS3ErrBadCookie) && it.cookie != 0 && it.start.Cookie == 0 && it.restarts < maxDirRestarts {
+		util.Debugf("readdir(%x): cookie %d rejected, starting over", it.fh, it.cookie)
+		it.restarts++
+		it.cookie, it.cookieVerf = 0, 0
+		return
+	}
+
+	if err != nil {
+		it.err = err
+		return
+	}
+
+	if len(entries) == 0 && !eof {
+		util.Errorf("readdir(%x): empty reply before the end of the directory", it.fh)
+		it.err = io.ErrNoProgress
+		return
+	}
+
+	if len(entries) > 0 {
+		it.cookie = entries[len(entries)-1].Cookie
+	}
+	it.cookieVerf = cookieVerf
+	it.eof = eof
+	it.batch = entries
+}
+
+// dirCounts returns the dircount and maxcount of READDIRPLUS requests
+func (v *Target) dirCounts() (uint32, uint32) {
+	maxCount := uint32(defaultDirReplySize)
+	if v.fsinfo != nil && v.fsinfo.DTPref > 0 {
+		maxCount = v.fsinfo.DTPref
+	}
+
+	dirCount := maxCount / 8
+	if dirCount < minDirCount {
+		dirCount = minDirCount
+	}
+
+	return dirCount, maxCount
+}
+
+// readDirPlus issues a single READDIRPLUS starting after cookie and returns the
+// entries, the cookie verifier and whether the end of the directory was reached
+func (v *Target) readDirPlus(fh []byte, cookie, cookieVerf uint64) ([]*EntryPlus, uint64, bool, error) {
+	type ReadDirPlus3Args struct {
+		rpc.Header
+		FH         []byte
+		Cookie     uint64
+		CookieVerf uint64
+		DirCount   uint32
+		MaxCount   uint32
+	}
+
+	type DirListPlus3 struct {
+		IsSet bool      `xdr:"union"`
+		Entry EntryPlus `xdr:"unioncase=1"`
+	}
+
+	type DirListOK struct {
+		DirAttrs   PostOpAttr
+		CookieVerf uint64
+	}
+
+	dirCount, maxCount := v.dirCounts()
+	res, err := v.call(&ReadDirPlus3Args{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    Nfs3Prog,
+			Vers:    Nfs3Vers,
+			Proc:    NFSProc3ReadDirPlus,
+			Cred:    v.auth,
+			Verf:    rpc.AuthNull,
+		},
+		FH:         fh,
+		Cookie:     cookie,
+		CookieVerf: cookieVerf,
+		DirCount:   dirCount,
+		MaxCount:   maxCount,
+	})
+
+	if err != nil {
+		util.Debugf("readdir(%x): %s", fh, err.Error())
+		return nil, 0, false, err
+	}
+	defer rpc.Release(res)
+
+	// The dir list entries are so-called "optional-data".  We need to check
+	// the Follows fields before continuing down the array.  Effectively, it's
+	// an encoding used to flatten a linked list into an array where the
+	// Follows field is set when the next idx has data. See
+	// https://tools.ietf.org/html/rfc4506.html#section-4.19 for details.
+	dirlistOK := new(DirListOK)
+	if err = xdr.Read(res, dirlistOK); err != nil {
+		util.Errorf("readdir failed to parse result (%x): %s", fh, err.Error())
+		util.Debugf("partial dirlist: %+v", dirlistOK)
+		return nil, 0, false, err
+	}
+
+	var entries []*EntryPlus
+	for {
+		var item DirListPlus3
+		if err = xdr.Read(res, &item); err != nil {
+			util.Errorf("readdir failed to parse directory entry, aborting")
+			util.Debugf("partial dirent: %+v", item)
+			return nil, 0, false, err
+		}
+
+		if !item.IsSet {
+			break
+		}
+
+		entries = append(entries, &item.Entry)
+	}
+
+	var eof bool
+	if err = xdr.Read(res, &eof); err != nil {
+		util.Errorf("readdir failed to determine presence of more data to read, aborting")
+		return nil, 0, false, err
+	}
+
+	return entries, dirlistOK.CookieVerf, eof, nil
+}
+
+// ReadDirNames returns the names of the entries of the directory at path,
+// listing it with READDIR, which is cheaper than READDIRPLUS when neither the
+// attributes nor the handles are needed
+func (v *Target) ReadDirNames(dir string) ([]string, error) {
+	_, fh, err := v.Lookup(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	it := v.ReadDirPlusIterByFh(fh)
+	it.plain = true
+
+	var names []string
+	for it.Next() {
+		names = append(names, it.Entry().FileName)
+	}
+
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// readDir issues a single READDIR starting after cookie.  The entries returned
+// only have their FileId, FileName and Cookie set.
+func (v *Target) readDir(fh []byte, cookie, cookieVerf uint64) ([]*EntryPlus, uint64, bool, error) {
+	type ReadDir3Args struct {
+		rpc.Header
+		FH         []byte
+		Cookie     uint64
+		CookieVerf uint64
+		Count      uint32
+	}
+
+	type Entry3 struct {
+		FileId   uint64
+		FileName string
+		Cookie   uint64
+	}
+
+	type DirList3 struct {
+		IsSet bool   `xdr:"union"`
+		Entry Entry3 `xdr:"unioncase=1"`
+	}
+
+	type DirListOK struct {
+		DirAttrs   PostOpAttr
+		CookieVerf uint64
+	}
+
+	_, count := v.dirCounts()
+	res, err := v.call(&ReadDir3Args{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    Nfs3Prog,
+			Vers:    Nfs3Vers,
+			Proc:    NFSProc3ReadDir,
+			Cred:    v.auth,
+			Verf:    rpc.AuthNull,
+		},
+		FH:         fh,
+		Cookie:     cookie,
+		CookieVerf: cookieVerf,
+		Count:      count,
+	})
+
+	if err != nil {
+		util.Debugf("readdir(%x): %s", fh, err.Error())
+		return nil, 0, false, err
+	}
+	defer rpc.Release(res)
+
+	dirlistOK := new(DirListOK)
+	if err = xdr.Read(res, dirlistOK); err != nil {
+		util.Errorf("readdir failed to parse result (%x): %s", fh, err.Error())
+		return nil, 0, false, err
+	}
+
+	var entries []*EntryPlus
+	for {
+		var item DirList3
+		if err = xdr.Read(res, &item); err != nil {
+			util.Errorf("readdir failed to parse directory entry, aborting")
+			util.Debugf("partial dirent: %+v", item)
+			return nil, 0, false, err
+		}
+
+		if !item.IsSet {
+			break
+		}
+
+		entries = append(entries, &EntryPlus{
+			FileId:   item.Entry.FileId,
+			FileName: item.Entry.FileName,
+			Cookie:   item.Entry.Cookie,
+		})
+	}
+
+	var eof bool
+	if err = xdr.Read(res, &eof); err != nil {
+		util.Errorf("readdir failed to determine presence of more data to read, aborting")
+		return nil, 0, false, err
+	}
+
+	return entries, dirlistOK.CookieVerf, eof, nil
+}
@@ -0,0 +1,238 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// the largest payload of the packets a pcap capture splits the traffic into
+const maxCapturePayload = 65000
+
+// Capture writes the traffic of the connections it wraps to a writer, as a
+// pcap file Wireshark opens or as a hex dump, for analyzing the exchanges
+// with a server without the privileges tcpdump needs.  The bytes are written
+// as they go over the connections, with the record marking of TCP, but after
+// TLS has decrypted them.  Failures writing the capture are ignored, not to
+// fail the calls.
+type Capture struct {
+	mu   sync.Mutex
+	w    io.Writer
+	pcap bool
+}
+
+// NewPcapCapture returns a Capture writing a pcap file of raw IP packets to w,
+// the traffic of the connections being made into TCP segments or UDP
+// datagrams between their addresses
+func NewPcapCapture(w io.Writer) (*Capture, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr, 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], 262144)
+	// LINKTYPE_RAW
+	binary.LittleEndian.PutUint32(hdr[20:], 101)
+
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+
+	return &Capture{w: w, pcap: true}, nil
+}
+
+// NewHexCapture returns a Capture writing a hex dump of the traffic to w,
+// each read or write under a line telling its direction
+func NewHexCapture(w io.Writer) *Capture {
+	return &Capture{w: w}
+}
+
+// Conn returns nc, capturing its traffic from now on
+func (c *Capture) Conn(nc net.Conn) net.Conn {
+	cc := &capturedConn{Conn: nc, c: c, local: endpointOf(nc.LocalAddr()), remote: endpointOf(nc.RemoteAddr())}
+
+	if pc, ok := nc.(net.PacketConn); ok {
+		cc.udp = true
+		return &capturedPacketConn{capturedConn: cc, pc: pc}
+	}
+
+	return cc
+}
+
+// endpoint is an address of a captured connection
+type endpoint struct {
+	ip   net.IP
+	port int
+}
+
+// endpointOf returns the endpoint of addr, the unspecified address when it is
+// not an IP one
+func endpointOf(addr net.Addr) endpoint {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return endpoint{a.IP, a.Port}
+	case *net.UDPAddr:
+		return endpoint{a.IP, a.Port}
+	}
+
+	return endpoint{net.IPv4zero, 0}
+}
+
+// capturedConn is a connection whose traffic goes to a Capture
+type capturedConn struct {
+	net.Conn
+	c *Capture
+
+	local, remote endpoint
+	udp           bool
+
+	// the next TCP sequence numbers sent and received, under the lock of c
+	seq, ack uint32
+}
+
+func (cc *capturedConn) Read(p []byte) (int, error) {
+	n, err := cc.Conn.Read(p)
+	if n > 0 {
+		cc.c.record(cc, false, p[:n])
+	}
+
+	return n, err
+}
+
+func (cc *capturedConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	if n > 0 {
+		cc.c.record(cc, true, p[:n])
+	}
+
+	return n, err
+}
+
+// capturedPacketConn is a captured UDP connection, still telling the
+// transports it carries datagrams
+type capturedPacketConn struct {
+	*capturedConn
+	pc net.PacketConn
+}
+
+func (cc *capturedPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	return cc.pc.ReadFrom(p)
+}
+
+func (cc *capturedPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return cc.pc.WriteTo(p, addr)
+}
+
+// record writes p, sent over cc when out is set or received from it
+// otherwise, to the capture
+func (c *Capture) record(cc *capturedConn, out bool, p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	src, dst := cc.remote, cc.local
+	if out {
+		src, dst = dst, src
+	}
+
+	if !c.pcap {
+		fmt.Fprintf(c.w, "%s %s > %s %d bytes\n", time.Now().Format(time.RFC3339Nano),
+			net.JoinHostPort(src.ip.String(), fmt.Sprint(src.port)),
+			net.JoinHostPort(dst.ip.String(), fmt.Sprint(dst.port)), len(p))
+		io.WriteString(c.w, hex.Dump(p))
+		return
+	}
+
+	for len(p) > 0 {
+		n := min(len(p), maxCapturePayload)
+		if cc.udp {
+			n = len(p)
+		}
+
+		c.packet(cc, out, src, dst, p[:n])
+		p = p[n:]
+	}
+}
+
+// packet writes a pcap record of an IP packet carrying p from src to dst
+func (c *Capture) packet(cc *capturedConn, out bool, src, dst endpoint, p []byte) {
+	var l4 []byte
+	if cc.udp {
+		l4 = make([]byte, 8)
+		binary.BigEndian.PutUint16(l4, uint16(src.port))
+		binary.BigEndian.PutUint16(l4[2:], uint16(dst.port))
+		binary.BigEndian.PutUint16(l4[4:], uint16(8+len(p)))
+	} else {
+		seq, ack := &cc.seq, &cc.ack
+		if !out {
+			seq, ack = ack, seq
+		}
+
+		l4 = make([]byte, 20)
+		binary.BigEndian.PutUint16(l4, uint16(src.port))
+		binary.BigEndian.PutUint16(l4[2:], uint16(dst.port))
+		binary.BigEndian.PutUint32(l4[4:], *seq)
+		binary.BigEndian.PutUint32(l4[8:], *ack)
+		l4[12] = 5 << 4
+		// PSH and ACK
+		l4[13] = 0x18
+		binary.BigEndian.PutUint16(l4[14:], 65535)
+
+		*seq += uint32(len(p))
+	}
+
+	proto := byte(6)
+	if cc.udp {
+		proto = 17
+	}
+
+	var ip []byte
+	if src.ip.To4() != nil && dst.ip.To4() != nil {
+		ip = make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(l4)+len(p)))
+		ip[6] = 0x40
+		ip[8] = 64
+		ip[9] = proto
+		copy(ip[12:], src.ip.To4())
+		copy(ip[16:], dst.ip.To4())
+		binary.BigEndian.PutUint16(ip[10:], ipChecksum(ip))
+	} else {
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(l4)+len(p)))
+		ip[6] = proto
+		ip[7] = 64
+		copy(ip[8:], src.ip.To16())
+		copy(ip[24:], dst.ip.To16())
+	}
+
+	now := time.Now()
+	size := len(ip) + len(l4) + len(p)
+	rec := make([]byte, 16, 16+size)
+	binary.LittleEndian.PutUint32(rec, uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(size))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(size))
+
+	rec = append(append(append(rec, ip...), l4...), p...)
+	c.w.Write(rec)
+}
+
+// ipChecksum returns the checksum of the IPv4 header hdr
+func ipChecksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(hdr); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,65 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 4)
+		if _, err := conn.Read(buf); err == nil {
+			conn.Write([]byte("pong"))
+		}
+	}()
+
+	nc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pcap, hexdump := new(bytes.Buffer), new(bytes.Buffer)
+	pc, err := NewPcapCapture(pcap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewHexCapture(hexdump).Conn(pc.Conn(nc))
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(hexdump.String(), "> "+l.Addr().String()+" 4 bytes") || !strings.Contains(hexdump.String(), "|pong|") {
+		t.Errorf("hex dump:\n%s", hexdump)
+	}
+
+	// the file header, then two records of IPv4 and TCP headers and 4 bytes
+	b := pcap.Bytes()
+	if len(b) != 24+2*(16+44) {
+		t.Fatalf("pcap of %d bytes", len(b))
+	}
+	if ack := binary.BigEndian.Uint32(b[24+16+60+20+8:]); ack != 4 {
+		t.Errorf("ack of the reply = %d, want 4", ack)
+	}
+}
@@ -6,12 +6,17 @@ package rpc
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
+	"os"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/go-nfs/nfsv3/nfs/util"
@@ -47,29 +52,217 @@ func init() {
 // added by zema1
 var DefaultReadTimeout = time.Second * 5
 
+// ErrClosed is the error of the calls made on a closed Client
+var ErrClosed = errors.New("rpc: client is closed")
+
+// Client issues calls over connections to an RPC server.  Calls may be made
+// from several goroutines at once: they are all in flight together, and their
+// replies are matched to them by XID.  The calls are striped across the
+// connections added by AddConn.
 type Client struct {
-	*tcpTransport
+	// guards the fields below
 	sync.Mutex
+
+	retrans   Retrans
+	timeout   time.Duration
+	maxRecord int
+	reconnect *Reconnect
+
+	// replaced rather than appended to, as the calls read it unlocked
+	interceptors []Interceptor
+
+	// bound the calls in flight on the client, and on each connection
+	slots     chan struct{}
+	connSlots int
+
+	// the calls in flight, and those waiting for their turn
+	inFlight, queued atomic.Int64
+
+	// the counters of the calls, by procedure
+	procs map[Proc]*ProcStats
+
+	// the connections, and the one taking the next call
+	conns []*conn
+	next  int
+
+	// the calls waiting for their reply, by XID
+	pending map[uint32]*pendingCall
+
+	// set once a connection failed without reconnecting, failing the calls
+	err error
+
+	// set by Drain, which waits for the calls in flight
+	draining bool
+
+	// set by Close, which waits for the calls in flight
+	closed bool
+	quit   chan struct{}
+	calls  sync.WaitGroup
+}
+
+// conn is a connection of a Client, replaced when it fails and the client
+// reconnects
+type conn struct {
+	// nil while reconnecting
+	t transport
+
+	// closed once reconnected
+	ready chan struct{}
+
+	// bounds the calls in flight on the connection
+	slots chan struct{}
+}
+
+// pendingCall is a call waiting for its reply
+type pendingCall struct {
+	ch chan reply
+
+	// the connection the call was last sent on
+	t transport
+
+	// reads the results of the call off the connection when set, under mu
+	// until the call ends
+	decode func(r io.Reader) error
+	mu     sync.Mutex
+	ended  bool
+}
+
+// reply is what a call waiting for its reply gets from the receive loop
+type reply struct {
+	res io.ReadSeeker
+	err error
+
+	// set when the connection failed before the reply came
+	lost bool
+
+	// the bytes of the reply
+	size int
+}
+
+// newClient returns a Client over t, receiving the replies until the
+// connection fails
+func newClient(t transport, retrans Retrans) *Client {
+	cn := &conn{t: t}
+	c := &Client{
+		retrans:   retrans,
+		timeout:   DefaultReadTimeout,
+		maxRecord: DefaultMaxRecord,
+		conns:     []*conn{cn},
+		pending:   make(map[uint32]*pendingCall),
+		quit:      make(chan struct{}),
+	}
+
+	go c.receive(cn, t)
+
+	return c
+}
+
+// newTransport returns the transport over conn, and the retransmission
+// policy it needs by default
+func newTransport(conn net.Conn) (transport, Retrans) {
+	if _, ok := conn.(net.PacketConn); ok {
+		t := &udpTransport{netConn: netConn{wc: conn}}
+		t.SetTimeout(DefaultReadTimeout)
+		return t, DefaultUDPRetrans
+	}
+
+	t := &tcpTransport{
+		netConn: netConn{wc: conn},
+		r:       bufio.NewReader(conn),
+	}
+	t.SetTimeout(DefaultReadTimeout)
+	t.setMaxRecord(DefaultMaxRecord)
+
+	return t, Retrans{}
 }
 
 func DialTCP(network string, ldr *net.TCPAddr, addr string) (*Client, error) {
-	a, err := net.ResolveTCPAddr(network, addr)
+	return DialTCPContext(context.Background(), network, ldr, addr)
+}
+
+// DialTCPContext is like DialTCP, but gives up connecting once ctx is done
+func DialTCPContext(ctx context.Context, network string, ldr *net.TCPAddr, addr string) (*Client, error) {
+	var laddr net.Addr
+	if ldr != nil {
+		laddr = ldr
+	}
+
+	return DialContext(ctx, network, laddr, addr)
+}
+
+// DialUDP connects to the RPC server at addr over UDP, retransmitting the
+// calls as DefaultUDPRetrans says
+func DialUDP(network string, ldr *net.UDPAddr, addr string) (*Client, error) {
+	return DialUDPContext(context.Background(), network, ldr, addr)
+}
+
+// DialUDPContext is like DialUDP, but gives up once ctx is done
+func DialUDPContext(ctx context.Context, network string, ldr *net.UDPAddr, addr string) (*Client, error) {
+	var laddr net.Addr
+	if ldr != nil {
+		laddr = ldr
+	}
+
+	return DialContext(ctx, network, laddr, addr)
+}
+
+// DialContext connects to the RPC server at addr over network, one of the TCP
+// or UDP networks of net.Dial, from laddr unless nil
+func DialContext(ctx context.Context, network string, laddr net.Addr, addr string) (*Client, error) {
+	d := &net.Dialer{LocalAddr: laddr}
+
+	nc, err := d.DialContext(ctx, network, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := net.DialTCP(a.Network(), ldr, a)
+	return NewClientFromConn(nc), nil
+}
+
+// NewClientFromConn returns a Client issuing its calls over conn, which must
+// be a UDP connection or a stream carrying records over TCP or TLS, such as
+// one upgraded by StartTLS
+func NewClientFromConn(conn net.Conn) *Client {
+	return newClient(newTransport(conn))
+}
+
+// AddConn dials another connection to the RPC server at addr like
+// DialContext, for c to stripe its calls across, like the nconnect mount
+// option of Linux does to get past the throughput of a single TCP stream.
+func (c *Client) AddConn(ctx context.Context, network string, laddr net.Addr, addr string) error {
+	d := &net.Dialer{LocalAddr: laddr}
+
+	nc, err := d.DialContext(ctx, network, addr)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	t := &tcpTransport{
-		r:       bufio.NewReader(conn),
-		wc:      conn,
-		timeout: DefaultReadTimeout,
+	return c.AddNetConn(nc)
+}
+
+// AddNetConn is like AddConn, over a connection already established as for
+// NewClientFromConn
+func (c *Client) AddNetConn(nc net.Conn) error {
+	t, _ := newTransport(nc)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed || c.err != nil {
+		nc.Close()
+		if c.err != nil {
+			return c.err
+		}
+		return ErrClosed
 	}
 
-	return &Client{t, sync.Mutex{}}, nil
+	t.SetTimeout(c.timeout)
+	t.setMaxRecord(c.maxRecord)
+	cn := &conn{t: t, slots: newSlots(c.connSlots)}
+	c.conns = append(c.conns, cn)
+	go c.receive(cn, t)
+
+	return nil
 }
 
 type message struct {
@@ -78,46 +271,119 @@ type message struct {
 	Body    interface{}
 }
 
-func (c *Client) Call(call interface{}) (io.ReadSeeker, error) {
+// SetRetrans sets the retransmission policy of the calls made after it
+// returns
+func (c *Client) SetRetrans(r Retrans) {
 	c.Lock()
 	defer c.Unlock()
+
+	c.retrans = r
+}
+
+func (c *Client) Call(call interface{}) (io.ReadSeeker, error) {
+	return c.CallDeadline(call, time.Time{})
+}
+
+// CallDeadline is like Call, but gives up with os.ErrDeadlineExceeded once
+// deadline has passed.  The zero deadline only applies the client's timeout.
+func (c *Client) CallDeadline(call interface{}, deadline time.Time) (io.ReadSeeker, error) {
+	return c.roundTrip(context.Background(), call, deadline, nil)
+}
+
+// CallContext is like Call, but gives up once ctx is done, returning the error
+// of the context.  The deadline of ctx bounds the call like the one passed to
+// CallDeadline.  The reply to a call given up is discarded when it arrives.
+func (c *Client) CallContext(ctx context.Context, call interface{}) (io.ReadSeeker, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+
+	res, err := c.roundTrip(ctx, call, deadline, nil)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return res, err
+}
+
+// CallInto is like CallContext, but has decode read the results of a
+// successful call straight from the connection as they arrive, rather than
+// from a buffer holding the whole reply, such as to read the data of an NFS
+// READ into the buffer of the caller without copying it through another.
+// decode runs on the goroutine receiving the replies of the connection, which
+// waits for it, and is not called anymore once CallInto returned.
+func (c *Client) CallInto(ctx context.Context, call interface{}, decode func(r io.Reader) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+
+	_, err := c.roundTrip(ctx, call, deadline, decode)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// errAbandoned is the error of a call given up once its done channel closed
+var errAbandoned = errors.New("rpc: call abandoned")
+
+// roundTrip sends call and waits for its reply through the interceptors of
+// c, giving up once deadline has passed or ctx is done.  The results of the
+// call are read by decode instead of returned when set.
+func (c *Client) roundTrip(ctx context.Context, call interface{}, deadline time.Time, decode func(r io.Reader) error) (io.ReadSeeker, error) {
+	c.Lock()
+	interceptors := c.interceptors
+	c.Unlock()
+
+	id := atomic.AddUint32(&xid, 1)
+	if len(interceptors) == 0 {
+		return c.send(ctx.Done(), id, call, deadline, decode)
+	}
+
+	info := &CallInfo{
+		Xid:    id,
+		Header: headerOf(call),
+		Call:   call,
+		Start:  time.Now(),
+	}
+
+	return intercept(ctx, interceptors, info, func() (io.ReadSeeker, error) {
+		return c.send(ctx.Done(), id, call, deadline, decode)
+	})
+}
+
+// send sends call as xid and waits for its reply, giving up once deadline
+// has passed or done is closed
+func (c *Client) send(done <-chan struct{}, xid uint32, call interface{}, deadline time.Time, decode func(r io.Reader) error) (io.ReadSeeker, error) {
 	retries := 5
 
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return nil, os.ErrDeadlineExceeded
+	}
+
 	msg := &message{
-		Xid:  atomic.AddUint32(&xid, 1),
+		Xid:  xid,
 		Body: call,
 	}
 
 retry:
-	w := new(bytes.Buffer)
+	w := new(encoder)
 	if err := xdr.Write(w, msg); err != nil {
+		w.release()
 		return nil, err
 	}
 
-	if _, err := c.Write(w.Bytes()); err != nil {
-		return nil, err
-	}
-
-	res, err := c.recv()
+	res, err := c.exchange(done, w.buf, msg.Xid, deadline, decode)
+	w.release()
 	if err != nil {
 		return nil, err
 	}
 
-	xid, err := xdr.ReadUint32(res)
-	if err != nil {
-		return nil, err
-	}
-
-	if xid != msg.Xid {
-		// emulate Linux behaviour for xid mismatch
-		if retries > 0 {
-			util.Debugf("Retrying on xid mismatch")
-			retries--
-			goto retry
-		}
-		return nil, fmt.Errorf("xid did not match, expected: %x, received: %x", msg.Xid, xid)
-	}
-
 	mtype, err := xdr.ReadUint32(res)
 	if err != nil {
 		return nil, err
@@ -191,6 +457,506 @@ retry:
 	default:
 		return nil, fmt.Errorf("rejectedStatus was not valid: %d", status)
 	}
+}
+
+// exchange sends the call in buf and returns the reply to xid past its XID,
+// sending the call again when the reply is late as the retransmission policy
+// asks, or when its connection failed and the client reconnected
+func (c *Client) exchange(done <-chan struct{}, buf []byte, xid uint32, deadline time.Time, decode func(r io.Reader) error) (io.ReadSeeker, error) {
+	c.Lock()
+	slots := c.slots
+	c.Unlock()
+
+	release, err := c.acquire(slots, done, deadline)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	cn, p, retrans, reconnect, err := c.register(decode)
+	if err != nil {
+		return nil, err
+	}
+	defer c.unregister(xid, p)
+
+	c.Lock()
+	slots = cn.slots
+	c.Unlock()
+
+	releaseConn, err := c.acquire(slots, done, deadline)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseConn()
+
+	c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+
+	start := time.Now()
+	timeo := retrans.Timeout
+
+	for attempts := 1; ; attempts++ {
+		t, err := c.connected(cn, p, xid, done, deadline)
+		if err != nil {
+			return nil, err
+		}
+
+		sent := time.Now()
+		if _, err := t.write(buf, deadline); errors.Is(err, syscall.EMSGSIZE) {
+			// a call too large for a datagram, which only fails it
+			return nil, err
+		} else if err != nil {
+			// the connection is left halfway through a record, its
+			// receive loop then reports the call lost
+			t.Close()
+		}
+
+		c.count(buf, func(s *ProcStats) {
+			if attempts == 1 {
+				s.Calls++
+			} else {
+				s.Retransmits++
+			}
+			s.BytesSent += uint64(len(buf))
+		})
+
+		var d time.Time
+		if timeo == 0 {
+			d = t.deadline(deadline)
+		} else {
+			d = time.Now().Add(timeo)
+			if !deadline.IsZero() && deadline.Before(d) {
+				d = deadline
+			}
+		}
+
+		r, expired := await(p.ch, done, d)
+		switch {
+		case !expired && !r.lost:
+			c.count(buf, func(s *ProcStats) {
+				s.BytesReceived += uint64(r.size)
+				s.RTT += time.Since(sent)
+			})
+			return r.res, r.err
+
+		case !expired:
+			if reconnect == nil {
+				return nil, r.err
+			}
+			if !reconnect.idempotent(buf) {
+				return nil, &ConnResetError{Xid: xid, Err: r.err}
+			}
+
+			util.Debugf("rpc: reissuing xid %x once reconnected", xid)
+			continue
+		}
+
+		if timeo == 0 || (!deadline.IsZero() && !time.Now().Before(deadline)) {
+			return nil, os.ErrDeadlineExceeded
+		}
+
+		if attempts > retrans.Retries {
+			c.count(buf, func(s *ProcStats) { s.Timeouts++ })
+			return nil, &TimeoutError{Xid: xid, Attempts: attempts, Elapsed: time.Since(start)}
+		}
+
+		util.Debugf("rpc: retransmitting xid %x, no reply after %s", xid, timeo)
+		timeo = retrans.backoff(timeo)
+	}
+}
+
+// await waits for the reply on ch until d, the zero time waiting for as long
+// as it takes, or until done is closed
+func await(ch <-chan reply, done <-chan struct{}, d time.Time) (r reply, expired bool) {
+	var timeout <-chan time.Time
+	if !d.IsZero() {
+		timer := time.NewTimer(time.Until(d))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case r = <-ch:
+	case <-done:
+		r.err = errAbandoned
+	case <-timeout:
+		expired = true
+	}
+
+	return r, expired
+}
+
+// register returns the connection taking the next call, the call waiting
+// for its reply decoded by decode, and the retransmission and reconnection
+// policies of the call
+func (c *Client) register(decode func(r io.Reader) error) (*conn, *pendingCall, Retrans, *Reconnect, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed || c.draining {
+		return nil, nil, Retrans{}, nil, ErrClosed
+	}
+	if c.err != nil {
+		return nil, nil, Retrans{}, nil, c.err
+	}
+
+	cn := c.conns[c.next]
+	c.next = (c.next + 1) % len(c.conns)
+	c.calls.Add(1)
+
+	return cn, &pendingCall{ch: make(chan reply, 1), decode: decode}, c.retrans, c.reconnect, nil
+}
+
+// connected returns the transport of cn, waiting for the client to reconnect
+// it when it failed, and makes the receive loop hand the reply to xid coming
+// on it to p
+func (c *Client) connected(cn *conn, p *pendingCall, xid uint32, done <-chan struct{}, deadline time.Time) (transport, error) {
+	for {
+		c.Lock()
+		if c.closed {
+			c.Unlock()
+			return nil, ErrClosed
+		}
+		if c.err != nil {
+			c.Unlock()
+			return nil, c.err
+		}
+
+		if cn.t != nil {
+			p.t = cn.t
+			c.pending[xid] = p
+			c.Unlock()
+
+			return p.t, nil
+		}
+
+		ready := cn.ready
+		d := deadline
+		if c.timeout != 0 {
+			if t := time.Now().Add(c.timeout); d.IsZero() || t.Before(d) {
+				d = t
+			}
+		}
+		c.Unlock()
+
+		if err := c.awaitReconnect(ready, done, d); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// awaitReconnect waits for ready to be closed until d, the zero time waiting
+// for as long as it takes, or until done is closed or the client is
+func (c *Client) awaitReconnect(ready, done <-chan struct{}, d time.Time) error {
+	var timeout <-chan time.Time
+	if !d.IsZero() {
+		timer := time.NewTimer(time.Until(d))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ready:
+		return nil
+	case <-done:
+		return errAbandoned
+	case <-c.quit:
+		return ErrClosed
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// unregister ends p, the call to xid, whose reply is discarded from now on,
+// waiting for the results being decoded
+func (c *Client) unregister(xid uint32, p *pendingCall) {
+	c.Lock()
+	delete(c.pending, xid)
+	c.Unlock()
+
+	p.mu.Lock()
+	p.ended = true
+	p.mu.Unlock()
+
+	c.calls.Done()
+}
+
+// receive hands the replies coming on t, the transport of cn, to the calls
+// waiting for them until the connection fails.  Replies to no call in flight
+// are late or duplicate ones to calls given up, and are discarded like Linux
+// does.
+func (c *Client) receive(cn *conn, t transport) {
+	for {
+		rec, err := t.recv()
+		if err != nil {
+			c.lost(cn, t, err)
+			return
+		}
+
+		rxid, err := xdr.ReadUint32(rec)
+		if err != nil {
+			if rec.err != nil {
+				c.lost(cn, t, rec.err)
+				return
+			}
+			continue
+		}
+
+		c.Lock()
+		p, ok := c.pending[rxid]
+		delete(c.pending, rxid)
+		c.Unlock()
+
+		if !ok {
+			util.Debugf("rpc: discarding reply to xid %x", rxid)
+			continue
+		}
+
+		if p.decode != nil {
+			c.decodeReply(p, rec)
+		} else if buf, err := rec.readAll(); err == nil {
+			res := &results{buf: buf}
+			res.Reset(buf)
+			p.ch <- reply{res: res, size: rec.size}
+		}
+
+		if rec.err != nil {
+			// the call lost its reply halfway through
+			select {
+			case p.ch <- reply{err: rec.err, lost: true}:
+			default:
+			}
+
+			c.lost(cn, t, rec.err)
+			return
+		}
+	}
+}
+
+// decodeReply reads the header of the reply in rec for p, and has p decode
+// its results when the call succeeded, unless p already ended
+func (c *Client) decodeReply(p *pendingCall, rec *record) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ended {
+		return
+	}
+
+	hdr, ok, err := readReplyHeader(rec)
+	if err == nil && ok {
+		err = p.decode(rec)
+	}
+	if rec.err != nil {
+		return
+	}
+
+	// a reply sent again may come on another connection as well
+	select {
+	case p.ch <- reply{res: bytes.NewReader(hdr), err: err, size: rec.size}:
+	default:
+	}
+}
+
+// maxAuthBody is the largest body of a credential or verifier
+const maxAuthBody = 400
+
+// readReplyHeader reads the reply in rec past its XID up to the results of
+// an accepted call, and reports whether the call succeeded.  The rest of the
+// reply to a failed call is read along.
+func readReplyHeader(rec *record) ([]byte, bool, error) {
+	// mtype and reply_stat, then the verifier flavor and length of an
+	// accepted reply
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(rec, hdr[:8]); err != nil {
+		return nil, false, err
+	}
+
+	mtype := binary.BigEndian.Uint32(hdr)
+	status := binary.BigEndian.Uint32(hdr[4:])
+	if mtype != 1 || status != MsgAccepted {
+		rest, err := rec.readAll()
+		return append(hdr[:8], rest...), false, err
+	}
+
+	if _, err := io.ReadFull(rec, hdr[8:]); err != nil {
+		return nil, false, err
+	}
+
+	n := binary.BigEndian.Uint32(hdr[12:])
+	if n > maxAuthBody {
+		return nil, false, fmt.Errorf("rpc: verifier of %d bytes", n)
+	}
+
+	// the verifier body and accept_stat
+	hdr = append(hdr, make([]byte, n+4)...)
+	if _, err := io.ReadFull(rec, hdr[16:]); err != nil {
+		return nil, false, err
+	}
+
+	if binary.BigEndian.Uint32(hdr[len(hdr)-4:]) == Success {
+		return hdr, true, nil
+	}
+
+	rest, err := rec.readAll()
+	return append(hdr, rest...), false, err
+}
+
+// lost handles the failure of t, the transport of cn, with err.  Without a
+// reconnection policy the calls in flight and the ones made later fail with
+// err, otherwise the calls sent on t are told they lost their reply and the
+// client dials another connection.
+func (c *Client) lost(cn *conn, t transport, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if cn.t != t {
+		return
+	}
+	cn.t = nil
+	t.Close()
+
+	if c.reconnect == nil || c.closed {
+		if c.err == nil {
+			c.err = err
+		}
+
+		for xid, p := range c.pending {
+			p.ch <- reply{err: c.err}
+			delete(c.pending, xid)
+		}
+
+		return
+	}
+
+	for xid, p := range c.pending {
+		if p.t == t {
+			p.ch <- reply{err: err, lost: true}
+			delete(c.pending, xid)
+		}
+	}
+
+	util.Errorf("rpc: connection lost: %v, reconnecting", err)
+	cn.ready = make(chan struct{})
+	go c.redial(cn, c.reconnect)
+}
+
+// Write writes buf as a call on the first connection, without waiting for a
+// reply
+func (c *Client) Write(buf []byte) (int, error) {
+	c.Lock()
+	t := c.conns[0].t
+	c.Unlock()
+
+	if t == nil {
+		return 0, errReconnecting
+	}
+
+	return t.Write(buf)
+}
+
+// SetTimeout sets how long the calls wait for a reply and to be sent, zero
+// waiting for as long as it takes
+func (c *Client) SetTimeout(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.timeout = d
+	for _, cn := range c.conns {
+		if cn.t != nil {
+			cn.t.SetTimeout(d)
+		}
+	}
+}
+
+// SetMaxRecord sets the size of the largest reply the connections receive
+// over TCP, failing with a *RecordTooLargeError on those getting a longer one
+func (c *Client) SetMaxRecord(n int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.maxRecord = n
+	for _, cn := range c.conns {
+		if cn.t != nil {
+			cn.t.setMaxRecord(n)
+		}
+	}
+}
+
+// Close waits for the calls in flight to complete and closes the connections.
+// The calls made from then on, and those waiting for the client to reconnect,
+// fail with ErrClosed.
+func (c *Client) Close() error {
+	c.stop()
+	c.calls.Wait()
+
+	return c.closeConns()
+}
+
+// Drain stops taking calls, failing those made from then on with ErrClosed,
+// and waits for the calls made before to complete, or for ctx to be done,
+// returning its error.  The calls waiting for a slot, as bounded by
+// SetMaxInFlight, are not made.
+func (c *Client) Drain(ctx context.Context) error {
+	c.Lock()
+	c.draining = true
+	c.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		c.calls.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown drains the client and closes the connections.  When ctx is done
+// before the calls in flight complete, the connections are closed from under
+// them, failing them, and the error of ctx is returned.
+func (c *Client) Shutdown(ctx context.Context) error {
+	if err := c.Drain(ctx); err != nil {
+		c.stop()
+		c.closeConns()
+		c.calls.Wait()
+
+		return err
+	}
+
+	return c.Close()
+}
+
+// stop fails the calls made from now on, and those waiting for the client to
+// reconnect, with ErrClosed
+func (c *Client) stop() {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.quit)
+	}
+}
+
+// closeConns closes the connections of the client
+func (c *Client) closeConns() error {
+	c.Lock()
+	defer c.Unlock()
+
+	var err error
+	for _, cn := range c.conns {
+		if cn.t == nil {
+			continue
+		}
+		if cerr := cn.t.Close(); err == nil {
+			err = cerr
+		}
+	}
 
-	panic("unreachable")
+	return err
 }
@@ -0,0 +1,310 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// readCall reads a call from r, returning its XID
+func readCall(r io.Reader) (uint32, error) {
+	var hdr uint32
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, hdr&0x7fffffff)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint32(buf), nil
+}
+
+// writeReply writes an accepted reply to xid with a null verifier and
+// SUCCESS, then res as the results
+func writeReply(w io.Writer, xid, res uint32) error {
+	buf := make([]byte, 32)
+	binary.BigEndian.PutUint32(buf, 28|0x80000000)
+	binary.BigEndian.PutUint32(buf[4:], xid)
+	binary.BigEndian.PutUint32(buf[8:], 1)
+	binary.BigEndian.PutUint32(buf[28:], res)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// test calls in flight together get their replies when they come out of order
+func TestCallsInFlight(t *testing.T) {
+	const calls = 4
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// read all the calls, then answer them last first
+		r := bufio.NewReader(conn)
+		var xids []uint32
+		for len(xids) < calls {
+			xid, err := readCall(r)
+			if err != nil {
+				return
+			}
+			xids = append(xids, xid)
+		}
+
+		for i := len(xids) - 1; i >= 0; i-- {
+			writeReply(conn, xids[i], uint32(i))
+		}
+	}()
+
+	c, err := DialContext(context.Background(), "tcp", nil, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = make(map[uint32]bool)
+	)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, err := c.Call(&Header{Rpcvers: 2})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			var n uint32
+			if err := binary.Read(res, binary.BigEndian, &n); err != nil {
+				t.Error(err)
+				return
+			}
+
+			mu.Lock()
+			seen[n] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != calls {
+		t.Errorf("replies = %v, want one for each of the %d calls", seen, calls)
+	}
+
+	s := c.Stats().Procs[Proc{}]
+	if s.Calls != calls || s.BytesReceived != calls*28 || s.RTT <= 0 {
+		t.Errorf("stats = %+v, want %d calls and their replies", s, calls)
+	}
+}
+
+// test a call in flight when its connection fails is sent again on the new
+// connection only when idempotent
+func TestReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// every other connection fails once it got a call
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn, fail bool) {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				for {
+					xid, err := readCall(r)
+					if err != nil || fail {
+						return
+					}
+					writeReply(conn, xid, 42)
+				}
+			}(conn, i%2 == 0)
+		}
+	}()
+
+	for _, idempotent := range []bool{true, false} {
+		c, err := DialContext(context.Background(), "tcp", nil, l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.SetReconnect(&Reconnect{
+			Dial: func(ctx context.Context) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "tcp", l.Addr().String())
+			},
+			Idempotent: func(prog, vers, proc uint32) bool { return idempotent },
+		})
+
+		_, err = c.Call(&Header{Rpcvers: 2})
+		var reset *ConnResetError
+		switch {
+		case idempotent && err != nil:
+			t.Errorf("idempotent call: %v", err)
+		case !idempotent && !errors.As(err, &reset):
+			t.Errorf("non-idempotent call: %v, want a *ConnResetError", err)
+		}
+
+		c.Close()
+	}
+}
+
+// test the results of a call decoded off the connection span the fragments
+// of its reply, and leave the connection at the next reply once read
+func TestCallInto(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		xid, err := readCall(r)
+		if err != nil {
+			return
+		}
+
+		// the reply header and "hello, ", then "world" and some padding
+		buf := make([]byte, 35)
+		binary.BigEndian.PutUint32(buf, 31)
+		binary.BigEndian.PutUint32(buf[4:], xid)
+		binary.BigEndian.PutUint32(buf[8:], 1)
+		copy(buf[28:], "hello, ")
+		conn.Write(buf)
+		conn.Write([]byte{0x80, 0, 0, 8, 'w', 'o', 'r', 'l', 'd', 0, 0, 0})
+
+		if xid, err = readCall(r); err == nil {
+			writeReply(conn, xid, 42)
+		}
+	}()
+
+	c, err := DialContext(context.Background(), "tcp", nil, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	p := make([]byte, 12)
+	err = c.CallInto(context.Background(), &Header{Rpcvers: 2}, func(r io.Reader) error {
+		_, err := io.ReadFull(r, p)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "hello, world" {
+		t.Errorf("results = %q, want %q", p, "hello, world")
+	}
+
+	res, err := c.Call(&Header{Rpcvers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n uint32
+	if err := binary.Read(res, binary.BigEndian, &n); err != nil || n != 42 {
+		t.Errorf("results = %d, %v, want 42", n, err)
+	}
+}
+
+// test a client shutting down fails the new calls but waits for those in
+// flight
+func TestShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		xid, err := readCall(conn)
+		if err != nil {
+			return
+		}
+		close(received)
+
+		<-release
+		writeReply(conn, xid, 1)
+	}()
+
+	c, err := DialContext(context.Background(), "tcp", nil, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inFlight := make(chan error, 1)
+	go func() {
+		_, err := c.Call(&Header{Rpcvers: 2})
+		inFlight <- err
+	}()
+	<-received
+
+	// stops taking calls, without waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = c.Drain(ctx); err != context.Canceled {
+		t.Fatalf("Drain = %v, want %v", err, context.Canceled)
+	}
+
+	if _, err = c.Call(&Header{Rpcvers: 2}); err != ErrClosed {
+		t.Fatalf("call while draining = %v, want %v", err, ErrClosed)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Shutdown(context.Background())
+	}()
+	close(release)
+
+	if err = <-inFlight; err != nil {
+		t.Errorf("call in flight = %v", err)
+	}
+	if err = <-done; err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+}
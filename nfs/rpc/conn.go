@@ -0,0 +1,60 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// transport carries the calls and replies of a Client over a connection
+type transport interface {
+	// recv returns the next reply, waiting for as long as it takes, which
+	// is read from until the next call
+	recv() (*record, error)
+
+	write(buf []byte, deadline time.Time) (int, error)
+	Write(buf []byte) (int, error)
+
+	// deadline returns the time a call waits for its reply until
+	deadline(deadline time.Time) time.Time
+
+	SetTimeout(d time.Duration)
+
+	// setMaxRecord sets the largest reply recv accepts
+	setMaxRecord(n int)
+
+	Close() error
+}
+
+// netConn is the connection under a transport, with the timeout of its calls,
+// which SetTimeout changes while they read it
+type netConn struct {
+	wc      net.Conn
+	timeout atomic.Int64
+}
+
+// deadline returns the earlier of the one derived from the timeout and the
+// given one, or the zero time when neither applies
+func (t *netConn) deadline(deadline time.Time) time.Time {
+	if timeout := time.Duration(t.timeout.Load()); timeout != 0 {
+		d := time.Now().Add(timeout)
+		if deadline.IsZero() || d.Before(deadline) {
+			return d
+		}
+	}
+
+	return deadline
+}
+
+func (t *netConn) Close() error {
+	return t.wc.Close()
+}
+
+// SetTimeout sets how long the calls wait for a reply and to be sent, zero
+// waiting for as long as it takes
+func (t *netConn) SetTimeout(d time.Duration) {
+	t.timeout.Store(int64(d))
+}
@@ -0,0 +1,134 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"time"
+)
+
+// CallInfo describes a call going through the interceptors of a Client
+type CallInfo struct {
+	Xid uint32
+
+	// Header is the header of the call, which BeforeCall may change, such as
+	// to set other credentials, or nil when the call does not start with one
+	Header *Header
+
+	// Call is the call as passed to the Client
+	Call interface{}
+
+	// Start is when the call was made
+	Start time.Time
+}
+
+// Interceptor observes, and may alter, the calls made on a Client, to log
+// them, measure them, inject credentials or fail them on purpose.  The
+// methods are called from the goroutine making the call.
+type Interceptor interface {
+	// BeforeCall is called before the call is sent, and fails it with the
+	// error it returns unless nil
+	BeforeCall(ctx context.Context, info *CallInfo) error
+
+	// AfterReply is called once the call succeeded
+	AfterReply(ctx context.Context, info *CallInfo)
+
+	// OnError is called with the error the call failed with, and fails it
+	// with the error it returns instead
+	OnError(ctx context.Context, info *CallInfo, err error) error
+}
+
+// InterceptorFuncs is an Interceptor made of the functions set in it, the
+// others doing nothing
+type InterceptorFuncs struct {
+	Before func(ctx context.Context, info *CallInfo) error
+	After  func(ctx context.Context, info *CallInfo)
+	Error  func(ctx context.Context, info *CallInfo, err error) error
+}
+
+func (f *InterceptorFuncs) BeforeCall(ctx context.Context, info *CallInfo) error {
+	if f.Before == nil {
+		return nil
+	}
+
+	return f.Before(ctx, info)
+}
+
+func (f *InterceptorFuncs) AfterReply(ctx context.Context, info *CallInfo) {
+	if f.After != nil {
+		f.After(ctx, info)
+	}
+}
+
+func (f *InterceptorFuncs) OnError(ctx context.Context, info *CallInfo, err error) error {
+	if f.Error == nil {
+		return err
+	}
+
+	return f.Error(ctx, info, err)
+}
+
+// AddInterceptor has i intercept the calls made on c after it returns, after
+// the interceptors added before it
+func (c *Client) AddInterceptor(i Interceptor) {
+	c.Lock()
+	defer c.Unlock()
+
+	interceptors := make([]Interceptor, len(c.interceptors), len(c.interceptors)+1)
+	copy(interceptors, c.interceptors)
+	c.interceptors = append(interceptors, i)
+}
+
+// intercept makes the call described by info with do, through interceptors
+func intercept(ctx context.Context, interceptors []Interceptor, info *CallInfo, do func() (io.ReadSeeker, error)) (io.ReadSeeker, error) {
+	var res io.ReadSeeker
+	var err error
+
+	for _, i := range interceptors {
+		if err = i.BeforeCall(ctx, info); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		res, err = do()
+	}
+
+	if err != nil {
+		for _, i := range interceptors {
+			err = i.OnError(ctx, info, err)
+		}
+		return nil, err
+	}
+
+	for _, i := range interceptors {
+		i.AfterReply(ctx, info)
+	}
+
+	return res, nil
+}
+
+// headerOf returns the header call starts with, when it is a Header or a
+// pointer to one, or to a struct whose first field is one
+func headerOf(call interface{}) *Header {
+	v := reflect.ValueOf(call)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return nil
+	}
+
+	v = v.Elem()
+	if v.Type() == reflect.TypeOf(Header{}) {
+		return v.Addr().Interface().(*Header)
+	}
+
+	if v.Kind() == reflect.Struct && v.NumField() > 0 {
+		if f := v.Type().Field(0); f.IsExported() && f.Type == reflect.TypeOf(Header{}) {
+			return v.Field(0).Addr().Interface().(*Header)
+		}
+	}
+
+	return nil
+}
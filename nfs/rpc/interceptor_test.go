@@ -0,0 +1,52 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	type args struct {
+		Header
+		Arg uint32
+	}
+
+	nc, peer := net.Pipe()
+	defer peer.Close()
+
+	c := NewClientFromConn(nc)
+	defer c.Close()
+
+	errChaos := errors.New("chaos")
+	var seen []string
+
+	c.AddInterceptor(&InterceptorFuncs{
+		Before: func(ctx context.Context, info *CallInfo) error {
+			seen = append(seen, "before")
+			if info.Header == nil || info.Header.Proc != 3 {
+				t.Errorf("header = %+v, want that of the call", info.Header)
+			}
+			return errChaos
+		},
+	})
+	c.AddInterceptor(&InterceptorFuncs{
+		Error: func(ctx context.Context, info *CallInfo, err error) error {
+			seen = append(seen, "error")
+			return err
+		},
+	})
+
+	_, err := c.Call(&args{Header: Header{Rpcvers: 2, Proc: 3}})
+	if err != errChaos {
+		t.Errorf("err = %v, want %v", err, errChaos)
+	}
+
+	if len(seen) != 2 || seen[0] != "before" || seen[1] != "error" {
+		t.Errorf("interceptors called %v, want before then error", seen)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"os"
+	"time"
+)
+
+// SetMaxInFlight bounds the calls in flight on c to n, and those on each of
+// its connections to perConn, so that bursts of calls do not flood a small
+// server.  Zero leaves them unbounded.  The calls over the bounds wait for
+// their turn, for as long as their deadline allows.
+func (c *Client) SetMaxInFlight(n, perConn int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.slots = newSlots(n)
+	c.connSlots = perConn
+	for _, cn := range c.conns {
+		cn.slots = newSlots(perConn)
+	}
+}
+
+// newSlots returns the semaphore letting n calls in flight, nil when n is zero
+func newSlots(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+
+	return make(chan struct{}, n)
+}
+
+// acquire takes one of slots, waiting for one to be released until deadline
+// unless zero, or until done or c is closed, and returns the function
+// releasing it
+func (c *Client) acquire(slots chan struct{}, done <-chan struct{}, deadline time.Time) (func(), error) {
+	if slots == nil {
+		return func() {}, nil
+	}
+
+	release := func() { <-slots }
+
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	c.queued.Add(1)
+	defer c.queued.Add(-1)
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-done:
+		return nil, errAbandoned
+	case <-c.quit:
+		return nil, ErrClosed
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
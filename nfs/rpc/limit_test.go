@@ -0,0 +1,73 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMaxInFlight(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// answers each call once told to
+	answer := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		for {
+			xid, err := readCall(r)
+			if err != nil {
+				return
+			}
+			<-answer
+			writeReply(conn, xid, 0)
+		}
+	}()
+
+	c, err := DialContext(context.Background(), "tcp", nil, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.SetMaxInFlight(1, 0)
+
+	errs := make(chan error)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := c.Call(&Header{Rpcvers: 2})
+			errs <- err
+		}()
+	}
+
+	for start := time.Now(); ; {
+		if s := c.Stats(); s.InFlight == 1 && s.Queued == 2 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("stats = %+v, want 1 call in flight and 2 queued", c.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		answer <- struct{}{}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"math/bits"
+	"sync"
+)
+
+// the sizes of the pooled buffers are powers of two from 1<<minPoolClass to
+// 1<<maxPoolClass bytes, the larger ones being left to the garbage collector
+const (
+	minPoolClass = 9
+	maxPoolClass = 22
+)
+
+// pools holds the buffers of each size class
+var pools [maxPoolClass - minPoolClass + 1]sync.Pool
+
+// poolClass returns the index in pools of the class of the buffers of n bytes,
+// or -1 when they are too large to be pooled
+func poolClass(n int) int {
+	if n <= 1<<minPoolClass {
+		return 0
+	}
+
+	c := bits.Len(uint(n-1)) - minPoolClass
+	if c >= len(pools) {
+		return -1
+	}
+
+	return c
+}
+
+// getBuf returns a buffer of n bytes, from the pool of its size class
+func getBuf(n int) []byte {
+	c := poolClass(n)
+	if c < 0 {
+		return make([]byte, n)
+	}
+
+	if b, ok := pools[c].Get().(*[]byte); ok {
+		return (*b)[:n]
+	}
+
+	return make([]byte, n, 1<<(c+minPoolClass))
+}
+
+// putBuf returns buf, which must not be used anymore, to the pool of its size
+// class
+func putBuf(buf []byte) {
+	c := poolClass(cap(buf))
+	if c < 0 || cap(buf) != 1<<(c+minPoolClass) {
+		return
+	}
+
+	buf = buf[:0]
+	pools[c].Put(&buf)
+}
+
+// grow returns buf with room for n more bytes, moving it to a pooled buffer
+// of twice its size or more when it is full
+func grow(buf []byte, n int) []byte {
+	if len(buf)+n <= cap(buf) {
+		return buf
+	}
+
+	nb := getBuf(max(2*cap(buf), len(buf)+n))[:len(buf)]
+	copy(nb, buf)
+	putBuf(buf)
+
+	return nb
+}
+
+// encoder is the io.Writer the calls are encoded into, over pooled buffers
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) Write(p []byte) (int, error) {
+	e.buf = append(grow(e.buf, len(p)), p...)
+	return len(p), nil
+}
+
+// release returns the buffer of e to its pool
+func (e *encoder) release() {
+	putBuf(e.buf)
+	e.buf = nil
+}
+
+// results are the results of a call read from a pooled buffer
+type results struct {
+	bytes.Reader
+	buf []byte
+}
+
+// Release returns the buffer holding res, the results of a call, to the pool
+// the replies are read into, saving the garbage collector the work when the
+// results are large.  res must not be read from anymore.
+func Release(res io.ReadSeeker) {
+	if r, ok := res.(*results); ok && r.buf != nil {
+		r.Reset(nil)
+		putBuf(r.buf)
+		r.buf = nil
+	}
+}
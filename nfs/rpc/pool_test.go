@@ -0,0 +1,33 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"testing"
+)
+
+func TestPoolClasses(t *testing.T) {
+	for _, tt := range []struct{ n, cap int }{
+		{1, 512},
+		{512, 512},
+		{513, 1024},
+		{1 << 20, 1 << 20},
+		{4<<20 + 1, 4<<20 + 1},
+	} {
+		buf := getBuf(tt.n)
+		if len(buf) != tt.n || cap(buf) != tt.cap {
+			t.Errorf("getBuf(%d) = %d bytes of %d, want %d", tt.n, len(buf), cap(buf), tt.cap)
+		}
+		putBuf(buf)
+	}
+
+	e := new(encoder)
+	for i := 0; i < 1000; i++ {
+		e.Write([]byte{byte(i)})
+	}
+	if len(e.buf) != 1000 || cap(e.buf) != 1024 || e.buf[999] != byte(999%256) {
+		t.Errorf("encoder holds %d bytes of %d", len(e.buf), cap(e.buf))
+	}
+	e.release()
+}
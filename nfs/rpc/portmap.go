@@ -4,8 +4,10 @@
 package rpc
 
 import (
-	"fmt"
+	"context"
 	"io"
+	"net"
+	"strconv"
 
 	"github.com/go-nfs/nfsv3/nfs/xdr"
 )
@@ -48,7 +50,12 @@ type Portmapper struct {
 }
 
 func (p *Portmapper) Getport(mapping Mapping) (int, error) {
-	res, err := p.call(PmapProcGetPort, mapping)
+	return p.GetportContext(context.Background(), mapping)
+}
+
+// GetportContext is like Getport, but gives up once ctx is done
+func (p *Portmapper) GetportContext(ctx context.Context, mapping Mapping) (int, error) {
+	res, err := p.callContext(ctx, PmapProcGetPort, mapping)
 	if err != nil {
 		return 0, err
 	}
@@ -78,7 +85,11 @@ func (p *Portmapper) Unsetport(mapping Mapping) (bool, error) {
 }
 
 func (p *Portmapper) call(proc uint32, mapping Mapping) (io.ReadSeeker, error) {
-	return p.Call(struct {
+	return p.callContext(context.Background(), proc, mapping)
+}
+
+func (p *Portmapper) callContext(ctx context.Context, proc uint32, mapping Mapping) (io.ReadSeeker, error) {
+	return p.CallContext(ctx, struct {
 		Header
 		Mapping
 	}{
@@ -95,7 +106,13 @@ func (p *Portmapper) call(proc uint32, mapping Mapping) (io.ReadSeeker, error) {
 }
 
 func DialPortmapper(net, host string) (*Portmapper, error) {
-	client, err := DialTCP(net, nil, fmt.Sprintf("%s:%d", host, PmapPort))
+	return DialPortmapperContext(context.Background(), net, host)
+}
+
+// DialPortmapperContext is like DialPortmapper, but gives up connecting once
+// ctx is done
+func DialPortmapperContext(ctx context.Context, network, host string) (*Portmapper, error) {
+	client, err := DialContext(ctx, network, nil, net.JoinHostPort(host, strconv.Itoa(PmapPort)))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,128 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+const (
+	// bounds of the delay between attempts to reconnect
+	minRedialDelay = 100 * time.Millisecond
+	maxRedialDelay = 5 * time.Second
+)
+
+// errReconnecting is the error of writing to a connection being replaced
+var errReconnecting = errors.New("rpc: reconnecting")
+
+// Reconnect is how a Client recovers from the failure of a connection.  The
+// client dials another one until it succeeds, the calls made meanwhile waiting
+// for it as long as their deadline and the timeout of the client allow.  The
+// calls in flight on the failed connection are sent again on the new one when
+// Idempotent allows it, and fail with a *ConnResetError otherwise.
+type Reconnect struct {
+	// Dial opens the connection replacing a failed one
+	Dial func(ctx context.Context) (net.Conn, error)
+
+	// Idempotent reports whether the server may execute a call to proc of
+	// version vers of prog twice without harm.  Nil means none may.
+	Idempotent func(prog, vers, proc uint32) bool
+}
+
+// idempotent reports whether the call in buf may be sent again
+func (r *Reconnect) idempotent(buf []byte) bool {
+	// the xid and message type, then the rpcvers, prog, vers and proc of
+	// the call header
+	if r.Idempotent == nil || len(buf) < 24 {
+		return false
+	}
+
+	return r.Idempotent(binary.BigEndian.Uint32(buf[12:]), binary.BigEndian.Uint32(buf[16:]), binary.BigEndian.Uint32(buf[20:]))
+}
+
+// ConnResetError is the error of a call whose connection failed before its
+// reply came, and that was not sent again on the new connection as the server
+// may have executed it already
+type ConnResetError struct {
+	Xid uint32
+	Err error
+}
+
+func (e *ConnResetError) Error() string {
+	return fmt.Sprintf("rpc: connection lost before the reply to xid %x: %v", e.Xid, e.Err)
+}
+
+func (e *ConnResetError) Unwrap() error {
+	return e.Err
+}
+
+// SetReconnect sets the policy by which c replaces its failed connections,
+// nil making a failed connection fail the client
+func (c *Client) SetReconnect(r *Reconnect) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.reconnect = r
+}
+
+// redial dials the connection replacing the failed one of cn with r until it
+// succeeds or the client is closed
+func (c *Client) redial(cn *conn, r *Reconnect) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-c.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	delay := minRedialDelay
+	for {
+		nc, err := r.Dial(ctx)
+		if err == nil {
+			t, _ := newTransport(nc)
+
+			c.Lock()
+			if c.closed {
+				c.Unlock()
+				nc.Close()
+				return
+			}
+
+			t.SetTimeout(c.timeout)
+			t.setMaxRecord(c.maxRecord)
+			cn.t = t
+			close(cn.ready)
+			c.Unlock()
+
+			util.Infof("rpc: reconnected to %s", nc.RemoteAddr())
+			go c.receive(cn, t)
+
+			return
+		}
+
+		util.Debugf("rpc: reconnecting: %v", err)
+
+		select {
+		case <-c.quit:
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxRedialDelay {
+			delay = maxRedialDelay
+		}
+	}
+}
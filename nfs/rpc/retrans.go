@@ -0,0 +1,58 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Retrans is the retransmission policy of a Client, after the timeo and
+// retrans mount options of Linux.  A call left without a reply for Timeout is
+// sent again with the same XID, so that a server keeping a duplicate request
+// cache answers it once, and the wait doubles for each retransmission up to
+// MaxTimeout.  Once Retries retransmissions went unanswered the call fails
+// with a *TimeoutError.
+//
+// The zero Retrans disables retransmission, calls then wait for a reply for
+// the timeout of the client instead.
+type Retrans struct {
+	Timeout    time.Duration
+	MaxTimeout time.Duration
+	Retries    int
+}
+
+// backoff returns the wait for the reply to the transmission following one
+// that waited for timeo
+func (r *Retrans) backoff(timeo time.Duration) time.Duration {
+	timeo *= 2
+	if r.MaxTimeout != 0 && timeo > r.MaxTimeout {
+		timeo = r.MaxTimeout
+	}
+
+	return timeo
+}
+
+// TimeoutError is the error of a call that got no reply to any of the
+// transmissions allowed by the retransmission policy of the client.  It
+// matches os.ErrDeadlineExceeded.
+type TimeoutError struct {
+	Xid      uint32
+	Attempts int
+	Elapsed  time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("rpc: no reply to xid %x after %d transmissions in %s", e.Xid, e.Attempts, e.Elapsed.Round(time.Millisecond))
+}
+
+// Timeout reports that the error is a timeout, like a net.Error
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == os.ErrDeadlineExceeded
+}
@@ -0,0 +1,59 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRetransBackoff(t *testing.T) {
+	r := &Retrans{Timeout: time.Second, MaxTimeout: 5 * time.Second}
+
+	var timeouts []time.Duration
+	for timeo, i := r.Timeout, 0; i < 5; i++ {
+		timeouts = append(timeouts, timeo)
+		timeo = r.backoff(timeo)
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i := range want {
+		if timeouts[i] != want[i] {
+			t.Fatalf("timeouts = %v, want %v", timeouts, want)
+		}
+	}
+
+	if !errors.Is(&TimeoutError{}, os.ErrDeadlineExceeded) {
+		t.Errorf("TimeoutError does not match os.ErrDeadlineExceeded")
+	}
+}
+
+// test a UDP client keeps retransmitting to a port nothing listens at, the
+// ICMP port unreachable errors failing neither the call nor the client
+func TestUDPPortUnreachable(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	c, err := DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetRetrans(Retrans{Timeout: 20 * time.Millisecond, Retries: 2})
+
+	for i := 0; i < 2; i++ {
+		_, err = c.Call(&Header{Rpcvers: 2})
+		var te *TimeoutError
+		if !errors.As(err, &te) {
+			t.Fatalf("call %d = %v, want a *TimeoutError", i, err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-nfs/nfsv3/nfs/xdr"
+)
+
+// RPCBIND
+// RFC 1833
+
+const (
+	RpcbVers3 = 3
+	RpcbVers4 = 4
+
+	RpcbProcGetAddr = 3
+)
+
+// Binding is the rpcb argument of the rpcbind procedures
+type Binding struct {
+	Prog  uint32
+	Vers  uint32
+	Netid string
+	Addr  string
+	Owner string
+}
+
+// NewPortmapper returns the Portmapper of host over client, a connection to
+// its portmapper
+func NewPortmapper(client *Client, host string) *Portmapper {
+	return &Portmapper{client, host}
+}
+
+// GetaddrContext asks rpcbind for the universal address of the program
+// described by b, with the RPCBPROC_GETADDR procedure of vers, RpcbVers3 or
+// RpcbVers4.  The address is empty when the program is not registered.
+func (p *Portmapper) GetaddrContext(ctx context.Context, vers uint32, b Binding) (string, error) {
+	res, err := p.CallContext(ctx, &struct {
+		Header
+		Binding
+	}{
+		Header: Header{
+			Rpcvers: 2,
+			Prog:    PmapProg,
+			Vers:    vers,
+			Proc:    RpcbProcGetAddr,
+			Cred:    AuthNull,
+			Verf:    AuthNull,
+		},
+		Binding: b,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	uaddr, err := xdr.ReadOpaque(res)
+	if err != nil {
+		return "", err
+	}
+
+	return string(uaddr), nil
+}
+
+// LookupContext returns the port of mapping, asking rpcbind v4 then v3 for
+// its address, which the services only registered with rpcbind, such as
+// those over IPv6, need, and falling back to the GETPORT of the portmapper
+// of version 2 the older servers only speak
+func (p *Portmapper) LookupContext(ctx context.Context, mapping Mapping) (int, error) {
+	b := Binding{
+		Prog:  mapping.Prog,
+		Vers:  mapping.Vers,
+		Netid: p.netid(mapping.Prot),
+	}
+
+	for _, vers := range []uint32{RpcbVers4, RpcbVers3} {
+		uaddr, err := p.GetaddrContext(ctx, vers, b)
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if err != nil || uaddr == "" {
+			continue
+		}
+
+		if _, port, err := ParseUniversalAddr(uaddr); err == nil {
+			return port, nil
+		}
+	}
+
+	return p.GetportContext(ctx, mapping)
+}
+
+// netid returns the rpcbind network id of prot, IPProtoTCP or IPProtoUDP, to
+// the host of p
+func (p *Portmapper) netid(prot uint32) string {
+	netid := "tcp"
+	if prot == IPProtoUDP {
+		netid = "udp"
+	}
+
+	if ip := net.ParseIP(p.host); ip != nil && ip.To4() == nil {
+		netid += "6"
+	}
+
+	return netid
+}
+
+// ParseUniversalAddr returns the IP address and port of uaddr, the universal
+// address of a TCP or UDP service, such as 192.0.2.1.8.1 for port 2049 of
+// 192.0.2.1
+func ParseUniversalAddr(uaddr string) (net.IP, int, error) {
+	hi := strings.LastIndexByte(uaddr, '.')
+	if hi < 0 {
+		return nil, 0, fmt.Errorf("rpc: malformed universal address %q", uaddr)
+	}
+	lo := strings.LastIndexByte(uaddr[:hi], '.')
+	if lo < 0 {
+		return nil, 0, fmt.Errorf("rpc: malformed universal address %q", uaddr)
+	}
+
+	p1, err1 := strconv.ParseUint(uaddr[lo+1:hi], 10, 8)
+	p2, err2 := strconv.ParseUint(uaddr[hi+1:], 10, 8)
+	ip := net.ParseIP(uaddr[:lo])
+	if err1 != nil || err2 != nil || ip == nil {
+		return nil, 0, fmt.Errorf("rpc: malformed universal address %q", uaddr)
+	}
+
+	return ip, int(p1<<8 | p2), nil
+}
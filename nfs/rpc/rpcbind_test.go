@@ -0,0 +1,31 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"testing"
+)
+
+func TestParseUniversalAddr(t *testing.T) {
+	for _, tt := range []struct {
+		uaddr string
+		ip    string
+		port  int
+	}{
+		{"192.0.2.1.8.1", "192.0.2.1", 2049},
+		{"::1.0.111", "::1", 111},
+		{"fe80::1:2.3.4", "fe80::1:2", 772},
+	} {
+		ip, port, err := ParseUniversalAddr(tt.uaddr)
+		if err != nil || ip.String() != tt.ip || port != tt.port {
+			t.Errorf("ParseUniversalAddr(%q) = %v, %d, %v, want %s, %d", tt.uaddr, ip, port, err, tt.ip, tt.port)
+		}
+	}
+
+	for _, uaddr := range []string{"", "192.0.2.1", "192.0.2.1.8.256", "host.8.1"} {
+		if _, _, err := ParseUniversalAddr(uaddr); err == nil {
+			t.Errorf("ParseUniversalAddr(%q) succeeded", uaddr)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Stats are the counters of the calls of a Client, like those nfsstat -c
+// reports
+type Stats struct {
+	// InFlight is the number of calls sent and waiting for their reply, and
+	// Queued the number of calls waiting for their turn to be sent as the
+	// bounds of SetMaxInFlight say
+	InFlight, Queued int
+
+	// Procs holds the counters of each procedure called
+	Procs map[Proc]ProcStats
+}
+
+// Proc is a procedure of an RPC program
+type Proc struct {
+	Prog, Vers, Proc uint32
+}
+
+// ProcStats are the counters of the calls to a procedure
+type ProcStats struct {
+	Calls uint64
+
+	// Retransmits counts the calls sent again, as their reply was late or
+	// their connection failed, and Timeouts the calls failing with a
+	// *TimeoutError as all the retransmissions went unanswered
+	Retransmits uint64
+	Timeouts    uint64
+
+	// the bytes of the calls sent, retransmissions included, and of their
+	// replies
+	BytesSent     uint64
+	BytesReceived uint64
+
+	// RTT is the sum of the times between sending the calls, the last time
+	// for those sent again, and receiving their reply
+	RTT time.Duration
+}
+
+// Stats returns the counters of the calls of c
+func (c *Client) Stats() Stats {
+	c.Lock()
+	defer c.Unlock()
+
+	procs := make(map[Proc]ProcStats, len(c.procs))
+	for p, s := range c.procs {
+		procs[p] = *s
+	}
+
+	return Stats{
+		InFlight: int(c.inFlight.Load()),
+		Queued:   int(c.queued.Load()),
+		Procs:    procs,
+	}
+}
+
+// count updates with f the counters of the procedure of the call in buf
+func (c *Client) count(buf []byte, f func(s *ProcStats)) {
+	// the xid and message type, then the rpcvers, prog, vers and proc of
+	// the call header
+	if len(buf) < 24 {
+		return
+	}
+
+	p := Proc{binary.BigEndian.Uint32(buf[12:]), binary.BigEndian.Uint32(buf[16:]), binary.BigEndian.Uint32(buf[20:])}
+
+	c.Lock()
+	defer c.Unlock()
+
+	s, ok := c.procs[p]
+	if !ok {
+		if c.procs == nil {
+			c.procs = make(map[Proc]*ProcStats)
+		}
+		s = new(ProcStats)
+		c.procs[p] = s
+	}
+
+	f(s)
+}
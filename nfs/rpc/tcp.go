@@ -4,69 +4,184 @@
 package rpc
 
 import (
-	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultMaxRecord is the largest record the clients receive unless
+// SetMaxRecord says otherwise, room for the largest READ and READDIR replies
+// of the servers
+const DefaultMaxRecord = 4 << 20
+
+// lastFragment is the bit of a fragment header marking the end of a record,
+// the other bits holding the length of the fragment
+const lastFragment = 0x80000000
+
+// RecordTooLargeError is the error of a connection receiving a record longer
+// than the maximum set for it.  The connection is unusable afterwards, as the
+// rest of the record cannot be told from the next one without reading it.
+type RecordTooLargeError struct {
+	Size, Max int
+}
+
+func (e *RecordTooLargeError) Error() string {
+	return fmt.Sprintf("rpc: record of %d bytes or more exceeds the maximum of %d", e.Size, e.Max)
+}
+
+// tcpTransport carries the calls and replies as records over a stream
 type tcpTransport struct {
-	r       io.Reader
-	wc      net.Conn
-	timeout time.Duration
+	netConn
+	r io.Reader
 
-	rlock, wlock sync.Mutex
+	// the largest record recv accepts
+	maxRecord atomic.Int64
+
+	// the last record recv returned
+	rec *record
+
+	wlock sync.Mutex
 }
 
-// Get the response from the conn, buffer the contents, and return a reader to
-// it.
-func (t *tcpTransport) recv() (io.ReadSeeker, error) {
-	t.rlock.Lock()
-	defer t.rlock.Unlock()
-	if t.timeout != 0 {
-		deadline := time.Now().Add(t.timeout)
-		t.wc.SetReadDeadline(deadline)
+// recv returns the next record once its first fragment header came, after
+// skipping the rest of the previous one, reading its fragments as they are
+// read from rather than trusting their headers with the memory to hold them.
+func (t *tcpTransport) recv() (*record, error) {
+	if t.rec != nil {
+		if _, err := io.Copy(io.Discard, t.rec); err != nil {
+			return nil, err
+		}
 	}
 
-	var hdr uint32
-	if err := binary.Read(t.r, binary.BigEndian, &hdr); err != nil {
+	t.rec = &record{r: t.r, max: int(t.maxRecord.Load())}
+	if err := t.rec.next(); err != nil {
 		return nil, err
 	}
 
-	buf := make([]byte, hdr&0x7fffffff)
-	if _, err := io.ReadFull(t.r, buf); err != nil {
-		return nil, err
+	return t.rec, nil
+}
+
+// setMaxRecord sets the largest record recv accepts
+func (t *tcpTransport) setMaxRecord(n int) {
+	t.maxRecord.Store(int64(n))
+}
+
+// record is a record being received, read from as it arrives until its end
+// and no further
+type record struct {
+	r   io.Reader
+	max int
+
+	// the bytes left in the current fragment, and whether it is the last
+	// one of the record
+	n    int
+	last bool
+
+	// the bytes of the record so far
+	size int
+
+	// set once the stream failed, which makes the connection unusable
+	err error
+}
+
+// next reads the header of the next fragment
+func (rec *record) next() error {
+	var hdr uint32
+	if err := binary.Read(rec.r, binary.BigEndian, &hdr); err != nil {
+		if err == io.EOF && rec.size > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		rec.err = err
+		return err
+	}
+
+	n := int(hdr &^ lastFragment)
+	if n > rec.max-rec.size {
+		rec.err = &RecordTooLargeError{Size: rec.size + n, Max: rec.max}
+		return rec.err
 	}
 
-	return bytes.NewReader(buf), nil
+	rec.n = n
+	rec.last = hdr&lastFragment != 0
+	rec.size += n
+
+	return nil
 }
 
-func (t *tcpTransport) Write(buf []byte) (int, error) {
-	t.wlock.Lock()
-	defer t.wlock.Unlock()
+func (rec *record) Read(p []byte) (int, error) {
+	if rec.err != nil {
+		return 0, rec.err
+	}
 
-	var hdr uint32 = uint32(len(buf)) | 0x80000000
-	b := make([]byte, 4)
-	binary.BigEndian.PutUint32(b, hdr)
-	if t.timeout != 0 {
-		deadline := time.Now().Add(t.timeout)
-		t.wc.SetWriteDeadline(deadline)
+	for rec.n == 0 {
+		if rec.last {
+			return 0, io.EOF
+		}
+		if err := rec.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(p) > rec.n {
+		p = p[:rec.n]
+	}
+
+	n, err := rec.r.Read(p)
+	rec.n -= n
+	if err == io.EOF && rec.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		rec.err = err
 	}
-	n, err := t.wc.Write(append(b, buf...))
 
 	return n, err
 }
 
-func (t *tcpTransport) Close() error {
-	return t.wc.Close()
-}
+// readAll reads the rest of the record into a pooled buffer of its size
+func (rec *record) readAll() ([]byte, error) {
+	buf := getBuf(rec.n)[:0]
+	for {
+		if rec.n == 0 {
+			if rec.last {
+				return buf, nil
+			}
+			if err := rec.next(); err != nil {
+				putBuf(buf)
+				return nil, err
+			}
+			buf = grow(buf, rec.n)
+			continue
+		}
 
-func (t *tcpTransport) SetTimeout(d time.Duration) {
-	t.timeout = d
-	if d == 0 {
-		var zeroTime time.Time
-		t.wc.SetDeadline(zeroTime)
+		n := len(buf)
+		buf = buf[:n+rec.n]
+		if _, err := io.ReadFull(rec, buf[n:]); err != nil {
+			putBuf(buf)
+			return nil, err
+		}
 	}
 }
+
+func (t *tcpTransport) Write(buf []byte) (int, error) {
+	return t.write(buf, time.Time{})
+}
+
+func (t *tcpTransport) write(buf []byte, deadline time.Time) (int, error) {
+	t.wlock.Lock()
+	defer t.wlock.Unlock()
+
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(buf))|lastFragment)
+	t.wc.SetWriteDeadline(t.deadline(deadline))
+
+	// the header and the call go out together without being copied
+	bufs := net.Buffers{hdr[:], buf}
+	n, err := bufs.WriteTo(t.wc)
+
+	return int(n), err
+}
@@ -0,0 +1,61 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRecvFragments(t *testing.T) {
+	// records as a sequence of fragments, the last one flagged
+	records := func(frags ...string) io.Reader {
+		b := new(bytes.Buffer)
+		for i, f := range frags {
+			hdr := uint32(len(f))
+			if i == len(frags)-1 {
+				hdr |= lastFragment
+			}
+			binary.Write(b, binary.BigEndian, hdr)
+			b.WriteString(f)
+		}
+		return b
+	}
+
+	tr := &tcpTransport{r: records("hello, ", "fragmented ", "world")}
+	tr.setMaxRecord(64)
+
+	rec, err := tr.recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := rec.readAll(); string(got) != "hello, fragmented world" {
+		t.Errorf("recv = %q", got)
+	}
+
+	tr = &tcpTransport{r: records("0123456789", "0123456789")}
+	tr.setMaxRecord(16)
+
+	var tooLarge *RecordTooLargeError
+	if rec, err = tr.recv(); err == nil {
+		_, err = rec.readAll()
+	}
+	if !errors.As(err, &tooLarge) || tooLarge.Size != 20 {
+		t.Errorf("recv = %v, want a RecordTooLargeError of 20 bytes", err)
+	}
+
+	// a header whose length does not come
+	tr = &tcpTransport{r: bytes.NewReader([]byte{0x80, 0, 0, 8, 'x'})}
+	tr.setMaxRecord(16)
+
+	if rec, err = tr.recv(); err == nil {
+		_, err = io.ReadAll(rec)
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("recv = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
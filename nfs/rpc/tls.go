@@ -0,0 +1,153 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs/xdr"
+)
+
+// RPC-with-TLS
+// RFC 9289
+
+// AuthTLS is the flavor of the credential probing a server for RPC-with-TLS
+const AuthTLS = 7
+
+// ALPN is the application protocol negotiated by RPC-with-TLS
+const ALPN = "sunrpc"
+
+// maxProbeReply is the longest reply to the STARTTLS probe
+const maxProbeReply = 7*4 + maxAuthBody + 2*4
+
+// ErrNoTLS is the error of StartTLS against a server not offering TLS
+var ErrNoTLS = errors.New("rpc: server does not support RPC-with-TLS")
+
+// StartTLS upgrades conn, a TCP connection to vers of the RPC program prog,
+// to TLS: it probes the server with a NULL call carrying AUTH_TLS
+// credentials, then does the TLS handshake under config once the server
+// answered STARTTLS.  config negotiates the sunrpc protocol unless it sets
+// NextProtos.
+func StartTLS(ctx context.Context, conn net.Conn, prog, vers uint32, config *tls.Config) (*tls.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	if err := probeTLS(conn, prog, vers); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	if len(config.NextProtos) == 0 {
+		config = config.Clone()
+		config.NextProtos = []string{ALPN}
+	}
+
+	tc := tls.Client(conn, config)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+// probeTLS makes the NULL call asking the server at the other end of conn to
+// start TLS, reading no more than the reply from conn
+func probeTLS(conn net.Conn, prog, vers uint32) error {
+	msg := &message{
+		Xid: atomic.AddUint32(&xid, 1),
+		Body: Header{
+			Rpcvers: 2,
+			Prog:    prog,
+			Vers:    vers,
+			Cred:    Auth{Flavor: AuthTLS},
+			Verf:    AuthNull,
+		},
+	}
+
+	w := new(bytes.Buffer)
+	w.Write(make([]byte, 4))
+	if err := xdr.Write(w, msg); err != nil {
+		return err
+	}
+
+	buf := w.Bytes()
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4)|0x80000000)
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	var hdr uint32
+	if err := binary.Read(conn, binary.BigEndian, &hdr); err != nil {
+		return err
+	}
+
+	// a reply to the probe is a single fragment holding at most the header,
+	// a verifier and the versions of a PROG_MISMATCH
+	if hdr&lastFragment == 0 {
+		return fmt.Errorf("rpc: STARTTLS reply in several fragments")
+	}
+	if n := hdr &^ lastFragment; n > maxProbeReply {
+		return &RecordTooLargeError{Size: int(n), Max: maxProbeReply}
+	}
+
+	rec := make([]byte, hdr&^lastFragment)
+	if _, err := io.ReadFull(conn, rec); err != nil {
+		return err
+	}
+
+	// xid, mtype, reply_stat, then the verifier and accept_stat of an
+	// accepted reply
+	res := bytes.NewReader(rec)
+	var fields [3]uint32
+	for i := range fields {
+		v, err := xdr.ReadUint32(res)
+		if err != nil {
+			return err
+		}
+		fields[i] = v
+	}
+
+	if fields[0] != msg.Xid || fields[1] != 1 {
+		return fmt.Errorf("rpc: unexpected reply to the STARTTLS probe")
+	}
+	if fields[2] != MsgAccepted {
+		return ErrNoTLS
+	}
+
+	if _, err := xdr.ReadUint32(res); err != nil {
+		return err
+	}
+	verf, err := xdr.ReadOpaque(res)
+	if err != nil {
+		return err
+	}
+
+	acceptStatus, err := xdr.ReadUint32(res)
+	if err != nil {
+		return err
+	}
+
+	if acceptStatus != Success || string(verf) != "STARTTLS" {
+		return ErrNoTLS
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+// test the STARTTLS probe refuses the replies longer than it can be, and
+// those in several fragments, before reading them
+func TestProbeTLSReplySize(t *testing.T) {
+	for _, hdr := range []uint32{lastFragment | 1<<30, 16} {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+
+			if _, err := readCall(server); err != nil {
+				return
+			}
+			binary.Write(server, binary.BigEndian, hdr)
+		}()
+
+		err := probeTLS(client, 100003, 3)
+		var rerr *RecordTooLargeError
+		if err == nil || (hdr&lastFragment != 0 && !errors.As(err, &rerr)) {
+			t.Errorf("probe with a record header of %x = %v", hdr, err)
+		}
+		client.Close()
+	}
+}
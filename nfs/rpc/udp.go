@@ -0,0 +1,78 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxDatagram is the largest payload of a UDP datagram
+const maxDatagram = 65535
+
+// DefaultUDPRetrans is the retransmission policy of the clients dialed over
+// UDP, which has to recover the datagrams lost on the way, the defaults of
+// Linux for the udp mount option.
+var DefaultUDPRetrans = Retrans{
+	Timeout:    1100 * time.Millisecond,
+	MaxTimeout: 60 * time.Second,
+	Retries:    3,
+}
+
+// udpTransport carries each call and reply in a datagram of its own, without
+// record marking
+type udpTransport struct {
+	netConn
+
+	// the datagram last received
+	buf []byte
+
+	wlock sync.Mutex
+}
+
+// recv returns the next datagram as a record of a single fragment, read from
+// a buffer the next call reuses
+func (t *udpTransport) recv() (*record, error) {
+	if t.buf == nil {
+		t.buf = make([]byte, maxDatagram)
+	}
+
+	n, err := t.wc.Read(t.buf)
+	for errors.Is(err, syscall.ECONNREFUSED) {
+		// an ICMP port unreachable, as while the server restarts, which
+		// the calls recover from by retransmitting like Linux does
+		n, err = t.wc.Read(t.buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &record{r: bytes.NewReader(t.buf[:n]), max: n, n: n, last: true, size: n}, nil
+}
+
+// setMaxRecord does nothing, as a datagram is never larger than maxDatagram
+func (t *udpTransport) setMaxRecord(n int) {}
+
+func (t *udpTransport) Write(buf []byte) (int, error) {
+	return t.write(buf, time.Time{})
+}
+
+func (t *udpTransport) write(buf []byte, deadline time.Time) (int, error) {
+	t.wlock.Lock()
+	defer t.wlock.Unlock()
+
+	t.wc.SetWriteDeadline(t.deadline(deadline))
+
+	n, err := t.wc.Write(buf)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		// reported for an earlier datagram, the call is retransmitted
+		// when this one is lost too
+		return len(buf), nil
+	}
+
+	return n, err
+}
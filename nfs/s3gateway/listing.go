@@ -0,0 +1,166 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package s3gateway
+
+import (
+	"errors"
+	_path "path"
+	"sort"
+	"strings"
+)
+
+// errDone stops a walk once a listing is full
+var errDone = errors.New("listing is full")
+
+// entry is a file of a directory walked for a listing, an object or a
+// directory holding more
+type entry struct {
+	name   string
+	dir    bool
+	object object
+}
+
+// listing collects the objects and common prefixes of a ListObjectsV2 page.
+//
+// S3 lists keys in byte order, which a walk visits them in when the entries
+// of each directory are sorted with a / appended to the names of the
+// directories, as all the keys below a directory share that prefix.  This
+// also allows skipping the directories that can only hold keys before the
+// start of the page or outside the prefix.
+type listing struct {
+	prefix, delimiter string
+
+	// only keys and common prefixes after this one are listed
+	after string
+	max   int
+
+	objects   []object
+	prefixes  []string
+	last      string
+	truncated bool
+}
+
+// walk lists the directory dir, whose keys start with dirKey, and the
+// directories below it
+func (l *listing) walk(readDir func(dir string) ([]entry, error), dir, dirKey string) error {
+	entries, err := readDir(dir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].sortName() < entries[j].sortName()
+	})
+
+	for _, e := range entries {
+		key := dirKey + e.sortName()
+
+		if !e.dir {
+			if err = l.add(key, e.object); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// a directory may hold keys matching the prefix, or sit in the
+		// directory the prefix names part of
+		if !strings.HasPrefix(key, l.prefix) && !strings.HasPrefix(l.prefix, key) {
+			continue
+		}
+
+		// all the keys below the directory are before the page
+		if key < l.after && !strings.HasPrefix(l.after, key) {
+			continue
+		}
+
+		// all the keys below the directory roll up into one prefix
+		if cp, ok := l.commonPrefix(key); ok {
+			if err = l.addPrefix(cp); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err = l.walk(readDir, _path.Join(dir, e.name), key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortName is the name of the entry in the keys of the objects below it
+func (e *entry) sortName() string {
+	if e.dir {
+		return e.name + "/"
+	}
+
+	return e.name
+}
+
+// commonPrefix returns the prefix up to the first delimiter after the prefix
+// of the listing, which key rolls up into
+func (l *listing) commonPrefix(key string) (string, bool) {
+	if l.delimiter == "" || !strings.HasPrefix(key, l.prefix) {
+		return "", false
+	}
+
+	i := strings.Index(key[len(l.prefix):], l.delimiter)
+	if i < 0 {
+		return "", false
+	}
+
+	return key[:len(l.prefix)+i+len(l.delimiter)], true
+}
+
+// add lists the object at key, or the common prefix it rolls up into
+func (l *listing) add(key string, obj object) error {
+	if !strings.HasPrefix(key, l.prefix) {
+		return nil
+	}
+
+	if cp, ok := l.commonPrefix(key); ok {
+		return l.addPrefix(cp)
+	}
+
+	if key <= l.after {
+		return nil
+	}
+
+	if err := l.full(); err != nil {
+		return err
+	}
+
+	obj.Key = key
+	l.objects = append(l.objects, obj)
+	l.last = key
+
+	return nil
+}
+
+// addPrefix lists a common prefix, once
+func (l *listing) addPrefix(cp string) error {
+	if cp <= l.after || cp == l.last {
+		return nil
+	}
+
+	if err := l.full(); err != nil {
+		return err
+	}
+
+	l.prefixes = append(l.prefixes, cp)
+	l.last = cp
+
+	return nil
+}
+
+// full marks the listing truncated when it cannot take another key
+func (l *listing) full() error {
+	if len(l.objects)+len(l.prefixes) < l.max {
+		return nil
+	}
+
+	l.truncated = true
+	return errDone
+}
@@ -0,0 +1,74 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package s3gateway
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListing(t *testing.T) {
+	// a-c sorts before a/b in byte order, though a sorts before a-c
+	tree := map[string][]entry{
+		".":   {{name: "a", dir: true}, {name: "a-c"}, {name: "b"}, {name: "d", dir: true}},
+		"a":   {{name: "b"}, {name: "c", dir: true}},
+		"a/c": {{name: "x"}, {name: "y"}},
+		"d":   {{name: "e"}},
+	}
+	readDir := func(dir string) ([]entry, error) {
+		return append([]entry(nil), tree[dir]...), nil
+	}
+
+	tests := []struct {
+		prefix, delimiter, after string
+		max                      int
+		keys, prefixes           []string
+		truncated                bool
+	}{
+		{"", "", "", 1000, []string{"a-c", "a/b", "a/c/x", "a/c/y", "b", "d/e"}, nil, false},
+		{"", "/", "", 1000, []string{"a-c", "b"}, []string{"a/", "d/"}, false},
+		{"a/", "/", "", 1000, []string{"a/b"}, []string{"a/c/"}, false},
+		{"a/c", "", "", 1000, []string{"a/c/x", "a/c/y"}, nil, false},
+		{"a", "-", "", 1000, []string{"a/b", "a/c/x", "a/c/y"}, []string{"a-"}, false},
+		{"", "", "", 2, []string{"a-c", "a/b"}, nil, true},
+		{"", "", "a/b", 2, []string{"a/c/x", "a/c/y"}, nil, true},
+		{"", "", "a/c/y", 2, []string{"b", "d/e"}, nil, false},
+		{"", "/", "a-c", 2, []string{"b"}, []string{"a/"}, true},
+		{"", "/", "a/", 1000, []string{"b"}, []string{"d/"}, false},
+	}
+
+	for _, tt := range tests {
+		l := &listing{prefix: tt.prefix, delimiter: tt.delimiter, after: tt.after, max: tt.max}
+		if err := l.walk(readDir, ".", ""); err != nil && err != errDone {
+			t.Fatal(err)
+		}
+
+		var keys []string
+		for _, obj := range l.objects {
+			keys = append(keys, obj.Key)
+		}
+
+		if !reflect.DeepEqual(keys, tt.keys) || !reflect.DeepEqual(l.prefixes, tt.prefixes) || l.truncated != tt.truncated {
+			t.Errorf("list(%q, %q, after %q, max %d) = %q, %q, truncated %v, want %q, %q, %v",
+				tt.prefix, tt.delimiter, tt.after, tt.max, keys, l.prefixes, l.truncated, tt.keys, tt.prefixes, tt.truncated)
+		}
+	}
+}
+
+func TestKeyPath(t *testing.T) {
+	for key, ok := range map[string]bool{
+		"a":       true,
+		"a/b.txt": true,
+		"":        false,
+		"a/":      false,
+		"a//b":    false,
+		"./a":     false,
+		"a/../b":  false,
+		"..":      false,
+	} {
+		if _, got := keyPath(key); got != ok {
+			t.Errorf("keyPath(%q) ok = %v, want %v", key, got, ok)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Package s3gateway serves an NFS target as a read-only bucket of an
+// S3-compatible API, so that tools speaking only S3 can read data stored on
+// a filer without copying it.
+//
+//	http.Handle("/", s3gateway.New(v, "datalake"))
+//
+// Requests are path-style, /<bucket>/<key>, and are not authenticated: the
+// gateway is meant to sit behind a proxy checking the credentials, or on a
+// trusted network.  The object keys are the paths of the regular files
+// relative to the root of the export.  Symlinks are followed when getting
+// objects, but not listed.
+package s3gateway
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	_path "path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+// maxKeys is the default and largest number of keys in a listing, as on S3
+const maxKeys = 1000
+
+// Gateway is an http.Handler answering GetObject, HeadObject, ListObjectsV2
+// and ListBuckets requests for a single bucket stored on a Target
+type Gateway struct {
+	v      *nfs.Target
+	fsys   fs.FS
+	bucket string
+}
+
+// New returns a gateway serving the export of v as the bucket named bucket
+func New(v *nfs.Target, bucket string) *Gateway {
+	return &Gateway{v: v, fsys: nfs.FS(v), bucket: bucket}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The method is not allowed against this resource.")
+		return
+	}
+
+	switch {
+	case bucket == "":
+		g.listBuckets(w, r)
+	case bucket != g.bucket:
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+	case key == "":
+		g.listObjects(w, r)
+	default:
+		g.getObject(w, r, key)
+	}
+}
+
+// getObject serves the file named by key, honouring conditional and range
+// requests
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	path, ok := keyPath(key)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	f, err := g.v.Open(path)
+	if err != nil {
+		writeNFSError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeNFSError(w, r, err)
+		return
+	}
+
+	if !info.Mode().IsRegular() {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	w.Header().Set("ETag", etag(info))
+	w.Header().Set("Accept-Ranges", "bytes")
+	http.ServeContent(w, r, path, info.ModTime(), f)
+}
+
+// keyPath maps an object key to a path, refusing the keys that a path cannot
+// spell, like those with empty, . or .. elements
+func keyPath(key string) (string, bool) {
+	if key == "" || strings.HasSuffix(key, "/") || _path.Clean("/"+key) != "/"+key {
+		return "", false
+	}
+
+	return key, true
+}
+
+// etag derives the entity tag of an object from its file id and modification
+// time
+func etag(info os.FileInfo) string {
+	fattr, ok := info.Sys().(*nfs.Fattr)
+	if !ok {
+		return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return fmt.Sprintf(`"%x-%x.%x"`, fattr.Fileid, fattr.Mtime.Seconds, fattr.Mtime.Nseconds)
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Buckets []bucket `xml:"Buckets>Bucket"`
+}
+
+type bucket struct {
+	Name         string
+	CreationDate string
+}
+
+// listBuckets lists the single bucket of the gateway, created when the root
+// of the export last changed
+func (g *Gateway) listBuckets(w http.ResponseWriter, r *http.Request) {
+	info, err := g.v.Stat(".")
+	if err != nil {
+		writeNFSError(w, r, err)
+		return
+	}
+
+	writeXML(w, r, &listAllMyBucketsResult{
+		Buckets: []bucket{{Name: g.bucket, CreationDate: s3Time(info.ModTime())}},
+	})
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	EncodingType          string `xml:",omitempty"`
+	KeyCount              int
+	MaxKeys               int
+	IsTruncated           bool
+	Contents              []object
+	CommonPrefixes        []commonPrefix
+}
+
+type object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+// listObjects answers a ListObjectsV2 request
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "Only ListObjectsV2 is implemented.")
+		return
+	}
+
+	l := &listing{
+		prefix:    q.Get("prefix"),
+		delimiter: q.Get("delimiter"),
+		after:     q.Get("start-after"),
+		max:       maxKeys,
+	}
+
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "The max-keys argument is invalid.")
+			return
+		}
+		l.max = min(n, maxKeys)
+	}
+
+	token := q.Get("continuation-token")
+	if token != "" {
+		after, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect.")
+			return
+		}
+		l.after = string(after)
+	}
+
+	if l.max > 0 {
+		if err := l.walk(g.readDir, ".", ""); err != nil && err != errDone {
+			writeNFSError(w, r, err)
+			return
+		}
+	}
+
+	res := &listBucketResult{
+		Name:              g.bucket,
+		Prefix:            l.prefix,
+		Delimiter:         l.delimiter,
+		StartAfter:        q.Get("start-after"),
+		ContinuationToken: token,
+		KeyCount:          len(l.objects) + len(l.prefixes),
+		MaxKeys:           l.max,
+		IsTruncated:       l.truncated,
+		Contents:          l.objects,
+	}
+	if l.truncated {
+		res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(l.last))
+	}
+	for _, p := range l.prefixes {
+		res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: p})
+	}
+
+	// keys may hold characters XML 1.0 cannot carry, clients ask for them
+	// to be URL encoded
+	if q.Get("encoding-type") == "url" {
+		res.EncodingType = "url"
+		res.Prefix = url.QueryEscape(res.Prefix)
+		res.Delimiter = url.QueryEscape(res.Delimiter)
+		res.StartAfter = url.QueryEscape(res.StartAfter)
+		for i := range res.Contents {
+			res.Contents[i].Key = url.QueryEscape(res.Contents[i].Key)
+		}
+		for i := range res.CommonPrefixes {
+			res.CommonPrefixes[i].Prefix = url.QueryEscape(res.CommonPrefixes[i].Prefix)
+		}
+	}
+
+	writeXML(w, r, res)
+}
+
+// readDir lists a directory of the export for a listing
+func (g *Gateway) readDir(dir string) ([]entry, error) {
+	dirEntries, err := fs.ReadDir(g.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entry, 0, len(dirEntries))
+	for _, d := range dirEntries {
+		info, err := d.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case info.IsDir():
+			entries = append(entries, entry{name: d.Name(), dir: true})
+		case info.Mode().IsRegular():
+			entries = append(entries, entry{
+				name: d.Name(),
+				object: object{
+					LastModified: s3Time(info.ModTime()),
+					ETag:         etag(info),
+					Size:         info.Size(),
+					StorageClass: "STANDARD",
+				},
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+func s3Time(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// errorResponse is the body of the error replies of S3
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(&errorResponse{Code: code, Message: msg, Resource: r.URL.Path})
+}
+
+// writeNFSError replies with the S3 error matching an NFS failure, without
+// revealing its details to the client
+func writeNFSError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	case errors.Is(err, fs.ErrPermission):
+		writeError(w, r, http.StatusForbidden, "AccessDenied", "Access Denied")
+	default:
+		util.Errorf("s3gateway %s: %v", r.URL.Path, err)
+		writeError(w, r, http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again.")
+	}
+}
+
+func writeXML(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		util.Errorf("s3gateway %s: %v", r.URL.Path, err)
+	}
+}
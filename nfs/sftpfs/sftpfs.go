@@ -0,0 +1,250 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Package sftpfs serves an NFS target over SFTP, implementing the request
+// handlers of github.com/pkg/sftp with the NFS client.
+//
+//	server := sftp.NewRequestServer(channel, sftpfs.Handlers(v))
+//	err := server.Serve()
+package sftpfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	_path "path"
+	"strings"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"github.com/pkg/sftp"
+)
+
+// the modes of files and directories created without one
+const (
+	defaultFilePerm = 0o644
+	defaultDirPerm  = 0o755
+)
+
+// handler implements the sftp handler interfaces over a Target
+type handler struct {
+	v *nfs.Target
+}
+
+var (
+	_ sftp.FileReader           = (*handler)(nil)
+	_ sftp.FileWriter           = (*handler)(nil)
+	_ sftp.OpenFileWriter       = (*handler)(nil)
+	_ sftp.FileCmder            = (*handler)(nil)
+	_ sftp.PosixRenameFileCmder = (*handler)(nil)
+	_ sftp.FileLister           = (*handler)(nil)
+	_ sftp.LstatFileLister      = (*handler)(nil)
+	_ sftp.ReadlinkFileLister   = (*handler)(nil)
+)
+
+// Handlers returns the SFTP request handlers serving the export of v.  SFTP
+// paths are resolved relative to the root of the export.
+func Handlers(v *nfs.Target) sftp.Handlers {
+	h := &handler{v: v}
+
+	return sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	}
+}
+
+// clean turns an SFTP path into a path relative to the root of the target
+func clean(path string) string {
+	path = strings.TrimPrefix(_path.Clean("/"+path), "/")
+	if path == "" {
+		return "."
+	}
+
+	return path
+}
+
+func (h *handler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	f, err := h.v.Open(clean(r.Filepath))
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (h *handler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return h.OpenFile(r)
+}
+
+// OpenFile opens the file with the flags of the request, creating it with the
+// permissions of the request, or 0644 when there are none
+func (h *handler) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	pflags := r.Pflags()
+
+	flag := os.O_RDONLY
+	switch {
+	case pflags.Read && pflags.Write:
+		flag = os.O_RDWR
+	case pflags.Write:
+		flag = os.O_WRONLY
+	}
+	if pflags.Creat {
+		flag |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flag |= os.O_EXCL
+	}
+
+	perm := os.FileMode(defaultFilePerm)
+	if r.AttrFlags().Permissions {
+		perm = r.Attributes().FileMode().Perm()
+	}
+
+	f, err := h.v.OpenFile(clean(r.Filepath), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (h *handler) Filecmd(r *sftp.Request) error {
+	path := clean(r.Filepath)
+
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(path, r)
+
+	case "Rename":
+		// unlike POSIX, SFTP renames don't replace an existing file
+		if _, err := h.v.Lstat(clean(r.Target)); err == nil {
+			return &os.LinkError{Op: "rename", Old: r.Filepath, New: r.Target, Err: os.ErrExist}
+		}
+		return h.v.Rename(path, clean(r.Target))
+
+	case "Rmdir":
+		return h.v.RmDir(path)
+
+	case "Remove":
+		return h.v.Remove(path)
+
+	case "Mkdir":
+		_, err := h.v.Mkdir(path, defaultDirPerm)
+		return err
+
+	case "Link":
+		return h.v.Link(path, clean(r.Target))
+
+	case "Symlink":
+		// Filepath holds the target of the link, unchanged
+		_, _, err := h.v.Symlink(r.Filepath, clean(r.Target), nil)
+		return err
+	}
+
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename renames replacing an existing file, as the
+// posix-rename@openssh.com extension asks for
+func (h *handler) PosixRename(r *sftp.Request) error {
+	return h.v.Rename(clean(r.Filepath), clean(r.Target))
+}
+
+// setstat changes the attributes of the request with a single SETATTR
+func (h *handler) setstat(path string, r *sftp.Request) error {
+	flags, stat := r.AttrFlags(), r.Attributes()
+
+	var attr nfs.Sattr3
+	if flags.Size {
+		attr.Size = nfs.SetSize{SetIt: true, Size: stat.Size}
+	}
+	if flags.UidGid {
+		attr.UID = nfs.SetUID{SetIt: true, UID: stat.UID}
+		attr.GID = nfs.SetUID{SetIt: true, UID: stat.GID}
+	}
+	if flags.Permissions {
+		attr.Mode = nfs.SetMode{SetIt: true, Mode: stat.Mode & 0o7777}
+	}
+	if flags.Acmodtime {
+		attr.Atime = setTime(stat.Atime)
+		attr.Mtime = setTime(stat.Mtime)
+	}
+
+	_, fh, err := h.v.Lookup(path)
+	if err != nil {
+		return err
+	}
+
+	return h.v.SetAttrByFh(fh, attr)
+}
+
+func setTime(sec uint32) nfs.SetTime {
+	return nfs.SetTime{
+		SetIt: nfs.SetToClientTime,
+		Time:  nfs.NFS3Time{Seconds: sec},
+	}
+}
+
+func (h *handler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	path := clean(r.Filepath)
+
+	switch r.Method {
+	case "List":
+		entries, err := fs.ReadDir(nfs.FS(h.v), path)
+		if err != nil {
+			return nil, err
+		}
+
+		infos := make(lister, 0, len(entries))
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+		return infos, nil
+
+	case "Stat":
+		info, err := h.v.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		return lister{info}, nil
+	}
+
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *handler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	info, err := h.v.Lstat(clean(r.Filepath))
+	if err != nil {
+		return nil, err
+	}
+
+	return lister{info}, nil
+}
+
+func (h *handler) Readlink(path string) (string, error) {
+	return h.v.Readlink(clean(path))
+}
+
+// lister hands out a listing in the chunks the client asks for
+type lister []os.FileInfo
+
+func (l lister) ListAt(p []os.FileInfo, off int64) (int, error) {
+	if off >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, l[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
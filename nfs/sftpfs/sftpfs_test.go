@@ -0,0 +1,35 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package sftpfs
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestListerListAt(t *testing.T) {
+	l := make(lister, 5)
+
+	tests := []struct {
+		size  int
+		off   int64
+		n     int
+		isEOF bool
+	}{
+		{2, 0, 2, false},
+		{2, 3, 2, false},
+		{3, 3, 2, true},
+		{5, 0, 5, false},
+		{2, 5, 0, true},
+		{2, 9, 0, true},
+	}
+
+	for _, tt := range tests {
+		n, err := l.ListAt(make([]os.FileInfo, tt.size), tt.off)
+		if n != tt.n || (err == io.EOF) != tt.isEOF {
+			t.Errorf("ListAt(%d, %d) = %d, %v, want %d, eof %v", tt.size, tt.off, n, err, tt.n, tt.isEOF)
+		}
+	}
+}
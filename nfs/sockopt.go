@@ -0,0 +1,48 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"net"
+)
+
+// setSockopts applies the socket options of d to conn, skipping those the
+// connection has no socket for, as through a proxy
+func (d *Dialer) setSockopts(conn net.Conn) error {
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if d.KeepAlive != nil {
+			if err := tc.SetKeepAliveConfig(*d.KeepAlive); err != nil {
+				return err
+			}
+		}
+
+		if d.Nagle {
+			if err := tc.SetNoDelay(false); err != nil {
+				return err
+			}
+		}
+	}
+
+	sc, ok := conn.(interface {
+		SetReadBuffer(int) error
+		SetWriteBuffer(int) error
+	})
+	if !ok {
+		return nil
+	}
+
+	if d.ReadBuffer > 0 {
+		if err := sc.SetReadBuffer(d.ReadBuffer); err != nil {
+			return err
+		}
+	}
+
+	if d.WriteBuffer > 0 {
+		if err := sc.SetWriteBuffer(d.WriteBuffer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
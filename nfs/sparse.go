@@ -0,0 +1,118 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+// sparseBlock is the granularity at which a sparse File looks for zeros
+const sparseBlock = 4096
+
+// SetSparse makes Write and WriteAt skip the WRITEs for blocks of zeros, so
+// the server can leave holes in the file instead of allocating them.  When the
+// end of the data written is zeros, the file is extended to its full size with
+// SETATTR before the next COMMIT, read or attribute change.
+//
+// Skipping is only correct where the file holds no data yet, as when copying
+// a disk image or similar into a new or truncated file.
+func (f *File) SetSparse(on bool) {
+	f.sparse = on
+}
+
+// outputSparse writes the runs of p which are not zeros
+func (f *File) outputSparse(p []byte, off uint64) (int, error) {
+	runs := dataRuns(p, off)
+	for _, r := range runs {
+		n, err := f.issue(p[r[0]:r[1]], off+uint64(r[0]))
+		if err != nil {
+			return r[0] + n, err
+		}
+	}
+
+	if len(runs) == 0 || runs[len(runs)-1][1] < len(p) {
+		f.mu.Lock()
+		if end := off + uint64(len(p)); end > f.sparseEnd {
+			f.sparseEnd = end
+		}
+		f.mu.Unlock()
+	}
+
+	return len(p), nil
+}
+
+// extendSparse grows the file to cover zeros skipped at its end
+func (f *File) extendSparse() error {
+	f.mu.Lock()
+	end := f.sparseEnd
+	f.mu.Unlock()
+
+	if end == 0 {
+		return nil
+	}
+
+	fattr, err := f.GetAttrFh(f.fh)
+	if err != nil {
+		return err
+	}
+
+	if fattr.Filesize < end {
+		_, err = f.setAttr(f.fh, Sattr3{
+			Size: SetSize{
+				SetIt: true,
+				Size:  end,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// zeros may have been skipped further out in the meantime
+	f.mu.Lock()
+	if f.sparseEnd == end {
+		f.sparseEnd = 0
+	}
+	f.mu.Unlock()
+
+	return nil
+}
+
+// dataRuns returns the [start, end) ranges of p holding data other than zeros,
+// looking at blocks of sparseBlock bytes aligned to the file offset off.
+// Adjacent blocks with data are merged into one run.
+func dataRuns(p []byte, off uint64) [][2]int {
+	var runs [][2]int
+
+	start := -1
+	for i := 0; i < len(p); {
+		end := i + sparseBlock - int((off+uint64(i))%sparseBlock)
+		if end > len(p) {
+			end = len(p)
+		}
+
+		if isZero(p[i:end]) {
+			if start >= 0 {
+				runs = append(runs, [2]int{start, i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+
+		i = end
+	}
+
+	if start >= 0 {
+		runs = append(runs, [2]int{start, len(p)})
+	}
+
+	return runs
+}
+
+func isZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,33 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDataRuns(t *testing.T) {
+	p := make([]byte, 4*sparseBlock)
+	p[10] = 1
+	p[sparseBlock+1] = 1
+	p[3*sparseBlock] = 1
+
+	runs := dataRuns(p, 0)
+	expected := [][2]int{{0, 2 * sparseBlock}, {3 * sparseBlock, 4 * sparseBlock}}
+	if !reflect.DeepEqual(runs, expected) {
+		t.Fatalf("expected %v, got %v", expected, runs)
+	}
+
+	// blocks are aligned to the file offset, not to the buffer
+	runs = dataRuns(p[:sparseBlock], sparseBlock-100)
+	expected = [][2]int{{0, 100}}
+	if !reflect.DeepEqual(runs, expected) {
+		t.Fatalf("expected %v, got %v", expected, runs)
+	}
+
+	if runs = dataRuns(make([]byte, 3*sparseBlock), 0); len(runs) != 0 {
+		t.Fatalf("expected no runs, got %v", runs)
+	}
+}
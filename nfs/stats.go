@@ -0,0 +1,72 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"fmt"
+
+	"github.com/go-nfs/nfsv3/nfs/rpc"
+)
+
+var procToName = map[uint32]string{
+	0:                   "NULL",
+	NFSProc3GetAttr:     "GETATTR",
+	NFSProc3SetAttr:     "SETATTR",
+	NFSProc3Lookup:      "LOOKUP",
+	NFSProc3Access:      "ACCESS",
+	NFSProc3Readlink:    "READLINK",
+	NFSProc3Read:        "READ",
+	NFSProc3Write:       "WRITE",
+	NFSProc3Create:      "CREATE",
+	NFSProc3Mkdir:       "MKDIR",
+	NFSProc3Symlink:     "SYMLINK",
+	NFSProc3Mknod:       "MKNOD",
+	NFSProc3Remove:      "REMOVE",
+	NFSProc3RmDir:       "RMDIR",
+	NFSProc3Rename:      "RENAME",
+	NFSProc3Link:        "LINK",
+	NFSProc3ReadDir:     "READDIR",
+	NFSProc3ReadDirPlus: "READDIRPLUS",
+	NFSProc3FSStat:      "FSSTAT",
+	NFSProc3FSInfo:      "FSINFO",
+	NFSProc3PathConf:    "PATHCONF",
+	NFSProc3Commit:      "COMMIT",
+}
+
+// Stats are the counters of the NFS calls of a Target and of those sharing
+// its connections, like those nfsstat -c reports
+type Stats struct {
+	// InFlight is the number of calls waiting for their reply, and Queued
+	// the number waiting for their turn to be sent
+	InFlight, Queued int
+
+	// Procs holds the counters of each NFS procedure called, by name, such
+	// as READ
+	Procs map[string]rpc.ProcStats
+}
+
+// Stats returns the counters of the NFS calls of v
+func (v *Target) Stats() *Stats {
+	s := v.Client.Stats()
+
+	stats := &Stats{
+		InFlight: s.InFlight,
+		Queued:   s.Queued,
+		Procs:    make(map[string]rpc.ProcStats),
+	}
+
+	for p, ps := range s.Procs {
+		if p.Prog != Nfs3Prog || p.Vers != Nfs3Vers {
+			continue
+		}
+
+		name, ok := procToName[p.Proc]
+		if !ok {
+			name = fmt.Sprintf("PROC%d", p.Proc)
+		}
+		stats.Procs[name] = ps
+	}
+
+	return stats
+}
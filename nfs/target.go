@@ -4,11 +4,16 @@
 package nfs
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	_path "path"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-nfs/nfsv3/nfs/rpc"
 	"github.com/go-nfs/nfsv3/nfs/util"
@@ -22,33 +27,68 @@ type Target struct {
 	fh      []byte
 	dirPath string
 	fsinfo  *FSInfo
+
+	// set for Targets returned by Sub, the handle and path of the root of
+	// the export, which absolute symlinks resolve from
+	rootFh   []byte
+	rootPath string
+
+	// set when the server's address is known, for locking files
+	lm *lockManager
+
+	// the symlinks followed per lookup when set by SetMaxSymlinks
+	maxSymlinks *int
+
+	// set when mounted through a Mount, to unmount the export on Close
+	mount      *Mount
+	exportPath string
+
+	// set for Targets returned by Sub and WithContext, which don't own the
+	// connection
+	sub bool
+
+	// set when mounted with MountFailover, to move between servers and
+	// unmount the export on Close
+	failover *failover
+
+	// set by WithContext, bounding the calls
+	ctx context.Context
+
+	// how the calls failing with NFS3ERR_JUKEBOX are sent again
+	jukebox JukeboxRetry
 }
 
-func NewTarget(addr string, auth rpc.Auth, fh []byte, dirpath string, priv bool) (*Target, error) {
-	m := rpc.Mapping{
-		Prog: Nfs3Prog,
-		Vers: Nfs3Vers,
-		Prot: rpc.IPProtoTCP,
-		Port: 0,
-	}
+// defaultMaxSymlinks is the number of symlinks followed while looking up a
+// path unless set otherwise, the same as MAXSYMLINKS on Linux
+const defaultMaxSymlinks = 40
 
-	client, err := DialService(addr, m, priv)
-	if err != nil {
-		return nil, err
-	}
+func NewTarget(addr string, auth rpc.Auth, fh []byte, dirpath string, priv bool) (*Target, error) {
+	return NewTargetContext(context.Background(), addr, auth, fh, dirpath, priv)
+}
 
-	return NewTargetWithClient(client, auth, fh, dirpath)
+// NewTargetContext is like NewTarget, but gives up once ctx is done.  The
+// Target returned is not bound to ctx.
+func NewTargetContext(ctx context.Context, addr string, auth rpc.Auth, fh []byte, dirpath string, priv bool) (*Target, error) {
+	d := &Dialer{Priv: priv}
+	return d.NewTarget(ctx, addr, auth, fh, dirpath)
 }
 
 func NewTargetWithClient(client *rpc.Client, auth rpc.Auth, fh []byte, dirpath string) (*Target, error) {
+	return newTarget(context.Background(), client, auth, fh, dirpath)
+}
+
+// newTarget returns a Target over client, asking for the FSINFO of the export
+// within ctx
+func newTarget(ctx context.Context, client *rpc.Client, auth rpc.Auth, fh []byte, dirpath string) (*Target, error) {
 	vol := &Target{
 		Client:  client,
 		auth:    auth,
 		fh:      fh,
 		dirPath: dirpath,
+		jukebox: DefaultJukeboxRetry,
 	}
 
-	fsinfo, err := vol.FSInfo()
+	fsinfo, err := vol.WithContext(ctx).FSInfo()
 	if err != nil {
 		return nil, err
 	}
@@ -59,25 +99,231 @@ func NewTargetWithClient(client *rpc.Client, auth rpc.Auth, fh []byte, dirpath s
 	return vol, nil
 }
 
-// wraps the Call function to check status and decode errors
-func (v *Target) call(c interface{}) (io.ReadSeeker, error) {
-	res, err := v.Call(c)
+// Sub returns a Target rooted at the directory dir, sharing the connection and
+// settings of v, so that part of an export can be handed out with paths
+// relative to it.  It is a convenience rather than a security boundary: the
+// server still resolves ".." above the new root, and absolute symlinks still
+// resolve from the root of the export.
+func (v *Target) Sub(dir string) (*Target, error) {
+	fattr, fh, _, _, err := v.lookupInner(v.fh, dir, true)
 	if err != nil {
 		return nil, err
 	}
 
-	status, err := xdr.ReadUint32(res)
-	if err != nil {
-		return nil, err
+	if fattr != nil && !fattr.IsDir() {
+		return nil, &os.PathError{Op: "sub", Path: dir, Err: NFS3Error(NFS3ErrNotDir)}
+	}
+
+	sub := *v
+	sub.rootFh, sub.rootPath = v.exportRoot()
+	sub.fh = fh
+	sub.dirPath = _path.Join(v.dirPath, dir)
+	sub.sub = true
+
+	return &sub, nil
+}
+
+// WithContext returns a Target sharing the connection and settings of v whose
+// calls give up once ctx is done, with the error of the context.  The Files
+// it opens are bound to ctx too, including their read-ahead and pipelined
+// writes, so close them before ctx is done to flush their writes.  Closing the
+// returned Target does nothing.
+func (v *Target) WithContext(ctx context.Context) *Target {
+	bound := *v
+	bound.ctx = ctx
+	bound.sub = true
+
+	return &bound
+}
+
+// Close tears the Target down: it unmounts the export when the Target was
+// mounted through a Mount or with MountFailover, closes the connection to the
+// lock manager and then the connection to the server, waiting for the RPCs in
+// flight to complete.
+// When the Target shares the connection of its Mount, that connection is left
+// for the Mount to close.  Closing a Target returned by Sub or WithContext does
+// nothing, close the Target it was derived from instead.
+func (v *Target) Close() error {
+	if v.sub {
+		return nil
+	}
+
+	var errs []error
+	if v.mount != nil || v.failover != nil {
+		if err := v.unmount(context.Background()); err != nil {
+			util.Errorf("umount(%s): %s", v.exportPath, err.Error())
+			errs = append(errs, err)
+		}
+	}
+
+	if v.lm != nil {
+		if err := v.lm.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if v.mount == nil || v.Client != v.mount.Client {
+		if err := v.Client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return multiError(errs)
+}
+
+// Shutdown tears the Target down gracefully: the calls made from then on fail
+// with rpc.ErrClosed, and once those in flight completed it unmounts the
+// export when the Target was mounted through a Mount or with MountFailover
+// and closes the connections, like Close.  When ctx is done first, the
+// connections are closed from under the calls still in flight, without
+// unmounting, and the error of ctx is returned.  When the Target shares the
+// connection of its Mount, its calls are not waited for, the Mount closing
+// that connection.
+func (v *Target) Shutdown(ctx context.Context) error {
+	if v.sub {
+		return nil
+	}
+
+	shared := v.mount != nil && v.Client == v.mount.Client
+
+	// the error of ctx is returned once, first
+	var (
+		errs    []error
+		expired bool
+	)
+	add := func(err error) {
+		switch {
+		case err == nil:
+		case err == ctx.Err():
+			expired = true
+		default:
+			errs = append(errs, err)
+		}
+	}
+
+	if !shared {
+		add(v.Client.Drain(ctx))
+	}
+
+	if v.mount != nil || v.failover != nil {
+		if ctx.Err() != nil {
+			add(ctx.Err())
+		} else if err := v.unmount(ctx); err != nil {
+			util.Errorf("umount(%s): %s", v.exportPath, err.Error())
+			add(err)
+		}
+	}
+
+	if v.lm != nil {
+		add(v.lm.shutdown(ctx))
+	}
+
+	if !shared {
+		add(v.Client.Shutdown(ctx))
 	}
 
-	if err = NFS3Error(status); err != nil {
+	if expired {
+		errs = append([]error{ctx.Err()}, errs...)
+	}
+
+	return multiError(errs)
+}
+
+// unmount unmounts the export from the server it was mounted from
+func (v *Target) unmount(ctx context.Context) error {
+	if v.failover != nil {
+		return v.failover.unmount(ctx)
+	}
+
+	return v.mount.unmount(ctx, v.exportPath)
+}
+
+// wraps the Call function to check status and decode errors
+func (v *Target) call(c interface{}) (io.ReadSeeker, error) {
+	return v.callDeadline(c, time.Time{})
+}
+
+// callDeadline is call giving up once deadline has passed
+func (v *Target) callDeadline(c interface{}, deadline time.Time) (io.ReadSeeker, error) {
+	var res io.ReadSeeker
+
+	err := v.retryJukebox(deadline, func() (err error) {
+		if res, err = v.rpcCall(v.Client, c, deadline); err != nil {
+			return err
+		}
+
+		status, err := xdr.ReadUint32(res)
+		if err != nil {
+			return err
+		}
+
+		return NFS3Error(status)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return res, nil
 }
 
+// callInto is callDeadline, having decode read the results of a successful
+// call straight from the connection
+func (v *Target) callInto(c interface{}, deadline time.Time, decode func(r io.Reader) error) error {
+	ctx := v.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	err := v.retryJukebox(deadline, func() error {
+		return v.Client.CallInto(ctx, c, func(r io.Reader) error {
+			status, err := xdr.ReadUint32(r)
+			if err != nil {
+				return err
+			}
+
+			if err = NFS3Error(status); err != nil {
+				return err
+			}
+
+			return decode(r)
+		})
+	})
+	if err == context.DeadlineExceeded && (v.ctx == nil || v.ctx.Err() == nil) {
+		// the deadline of the call passed, rather than that of v
+		return os.ErrDeadlineExceeded
+	}
+
+	return err
+}
+
+// rpcCall issues c on client, giving up once deadline has passed or the
+// context of v is done
+func (v *Target) rpcCall(client *rpc.Client, c interface{}, deadline time.Time) (io.ReadSeeker, error) {
+	if v.ctx == nil {
+		return client.CallDeadline(c, deadline)
+	}
+
+	ctx := v.ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	res, err := client.CallContext(ctx, c)
+	if err == context.DeadlineExceeded && v.ctx.Err() == nil {
+		// the deadline of the call passed, rather than that of v
+		return nil, os.ErrDeadlineExceeded
+	}
+
+	return res, err
+}
+
 func (v *Target) FSInfo() (*FSInfo, error) {
 	type FSInfoArgs struct {
 		rpc.Header
@@ -109,6 +355,145 @@ func (v *Target) FSInfo() (*FSInfo, error) {
 	return fsinfo, nil
 }
 
+// FSStat returns the total, free and available space and file slots of the
+// file system of the export
+func (v *Target) FSStat() (*FSStat, error) {
+	type FSStatArgs struct {
+		rpc.Header
+		FsRoot []byte
+	}
+
+	res, err := v.call(&FSStatArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    Nfs3Prog,
+			Vers:    Nfs3Vers,
+			Proc:    NFSProc3FSStat,
+			Cred:    v.auth,
+			Verf:    rpc.AuthNull,
+		},
+		FsRoot: v.fh,
+	})
+
+	if err != nil {
+		util.Debugf("fsstat: %s", err.Error())
+		return nil, err
+	}
+
+	fsstat := new(FSStat)
+	if err = xdr.Read(res, fsstat); err != nil {
+		return nil, err
+	}
+
+	return fsstat, nil
+}
+
+// PathConf returns the limits and name handling of the file system for the file
+// at path: the maximum number of links and name length, whether longer names
+// are rejected rather than truncated, whether only root may chown and whether
+// names are compared and stored case sensitively
+func (v *Target) PathConf(path string) (*PathConf, error) {
+	type PathConfArgs struct {
+		rpc.Header
+		FH []byte
+	}
+
+	_, fh, err := v.Lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := v.call(&PathConfArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    Nfs3Prog,
+			Vers:    Nfs3Vers,
+			Proc:    NFSProc3PathConf,
+			Cred:    v.auth,
+			Verf:    rpc.AuthNull,
+		},
+		FH: fh,
+	})
+
+	if err != nil {
+		util.Debugf("pathconf(%s): %s", path, err.Error())
+		return nil, err
+	}
+
+	pathconf := new(PathConf)
+	if err = xdr.Read(res, pathconf); err != nil {
+		return nil, err
+	}
+
+	return pathconf, nil
+}
+
+// VolumeStats is the usage of the file system of an export in the terms of
+// the NodeGetVolumeStats call of CSI drivers, with the name and link limits
+type VolumeStats struct {
+	// bytes, where used is what is not free and available is what the
+	// user may still allocate, which excludes any reserved space
+	CapacityBytes  int64
+	UsedBytes      int64
+	AvailableBytes int64
+
+	// file slots, on the same terms
+	CapacityInodes  int64
+	UsedInodes      int64
+	AvailableInodes int64
+
+	MaxNameLength int64
+	MaxLinks      int64
+}
+
+// VolumeStats returns the usage of the file system of the export, combining
+// FSSTAT with the PATHCONF of its root
+func (v *Target) VolumeStats() (*VolumeStats, error) {
+	fsstat, err := v.FSStat()
+	if err != nil {
+		return nil, err
+	}
+
+	pathconf, err := v.PathConf(".")
+	if err != nil {
+		return nil, err
+	}
+
+	return volumeStats(fsstat, pathconf), nil
+}
+
+func volumeStats(fsstat *FSStat, pathconf *PathConf) *VolumeStats {
+	return &VolumeStats{
+		CapacityBytes:   clampInt64(fsstat.TBytes),
+		UsedBytes:       clampInt64(used(fsstat.TBytes, fsstat.FBytes)),
+		AvailableBytes:  clampInt64(fsstat.ABytes),
+		CapacityInodes:  clampInt64(fsstat.TFiles),
+		UsedInodes:      clampInt64(used(fsstat.TFiles, fsstat.FFiles)),
+		AvailableInodes: clampInt64(fsstat.AFiles),
+		MaxNameLength:   int64(pathconf.NameMax),
+		MaxLinks:        int64(pathconf.LinkMax),
+	}
+}
+
+// clampInt64 converts the unsigned counts of FSSTAT, which some servers set
+// to all ones for unlimited
+func clampInt64(n uint64) int64 {
+	if n > math.MaxInt64 {
+		return math.MaxInt64
+	}
+
+	return int64(n)
+}
+
+// used is what is not free of total, as far as the server is consistent
+func used(total, free uint64) uint64 {
+	if free > total {
+		return 0
+	}
+
+	return total - free
+}
+
 func sameHandle(a []byte, b []byte) bool {
 	if len(a) != len(b) {
 		return false
@@ -123,25 +508,31 @@ func sameHandle(a []byte, b []byte) bool {
 
 // Lookup returns attributes and the file handle to a given dirent
 func (v *Target) Lookup(p string) (os.FileInfo, []byte, error) {
-	fattr, fh, _, _, err := v.lookupInner(v.fh, p, true, nil)
+	fattr, fh, _, _, err := v.lookupInner(v.fh, p, true)
 	return fattr, fh, err
 }
 
-func (v *Target) lookupInner(fh []byte, p string, lookupLast bool, lookupOrigin []byte) (*Fattr, []byte, string, []byte, error) {
+// LookupContext is like Lookup, but gives up once ctx is done
+func (v *Target) LookupContext(ctx context.Context, p string) (os.FileInfo, []byte, error) {
+	return v.WithContext(ctx).Lookup(p)
+}
+
+func (v *Target) lookupInner(fh []byte, p string, lookupLast bool) (*Fattr, []byte, string, []byte, error) {
 	var (
 		err   error
 		fattr *Fattr
+		links int
 	)
 
-	// desecend down a path heirarchy to get the last elem's fh
+	// desecend down a path heirarchy to get the last elem's fh, splicing the
+	// targets of symlinks into the elements still to look up
 	dirents := strings.Split(p, "/")
 	var dirent string
 	var prevFh []byte
-	for i := 0; i < len(dirents); {
-		dirent = dirents[i]
+	for len(dirents) > 0 {
+		dirent, dirents = dirents[0], dirents[1:]
 		prevFh = fh
-		i += 1
-		if i == len(dirents) && !lookupLast {
+		if len(dirents) == 0 && !lookupLast {
 			fattr = nil
 			fh = nil
 			break
@@ -155,23 +546,89 @@ func (v *Target) lookupInner(fh []byte, p string, lookupLast bool, lookupOrigin
 		if err != nil {
 			return nil, nil, "", nil, err
 		}
-		if fattr.FileMode&0o170000 == 0o120000 {
-			if lookupOrigin != nil && sameHandle(fh, lookupOrigin) {
-				return nil, nil, "", nil, fmt.Errorf("recursed symlink")
-			}
-			// symlink
-			_, target, err := v.readlinkFh(fh)
-			if err != nil {
-				return nil, nil, "", nil, err
+		if fattr.Type != NF3Lnk {
+			continue
+		}
+
+		if links++; links > v.symlinkLimit() {
+			return nil, nil, "", nil, &os.PathError{Op: "lookup", Path: p, Err: syscall.ELOOP}
+		}
+
+		_, target, err := v.readlinkFh(fh)
+		if err != nil {
+			return nil, nil, "", nil, err
+		}
+
+		// relative targets are resolved from the directory holding the
+		// link, absolute ones from the root of the export
+		fh = prevFh
+		if strings.HasPrefix(target, "/") {
+			fh, _ = v.exportRoot()
+			if target, err = v.exportRelative(target); err != nil {
+				return nil, nil, "", nil, &os.PathError{Op: "lookup", Path: p, Err: err}
 			}
-			// reparse
-			_, fh, _, _, err = v.lookupInner(v.fh, target, true, fh)
+		}
+		fattr = nil
+
+		dirents = append(strings.Split(target, "/"), dirents...)
+	}
+
+	// a symlink resolved to a directory by a name like "." or ".."
+	if fattr == nil && fh != nil && links > 0 {
+		if fattr, err = v.GetAttrFh(fh); err != nil {
+			return nil, nil, "", nil, err
 		}
 	}
 
 	return fattr, fh, dirent, prevFh, nil
 }
 
+// symlinkLimit returns how many symlinks are followed while looking up a path
+func (v *Target) symlinkLimit() int {
+	if v.maxSymlinks == nil {
+		return defaultMaxSymlinks
+	}
+
+	return *v.maxSymlinks
+}
+
+// SetMaxSymlinks sets how many symlinks are followed while looking up a single
+// path, 40 by default like on Linux.  Lookups needing more, as well as those
+// running into a cycle of symlinks, fail with an *os.PathError holding
+// syscall.ELOOP.  A limit of 0 stops symlinks from being followed at all.
+func (v *Target) SetMaxSymlinks(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	v.maxSymlinks = &n
+}
+
+// exportRoot returns the handle and path of the root of the export, above
+// that of v for a Target returned by Sub
+func (v *Target) exportRoot() ([]byte, string) {
+	if v.rootFh == nil {
+		return v.fh, v.dirPath
+	}
+
+	return v.rootFh, v.rootPath
+}
+
+// exportRelative maps the absolute target of a symlink, which is a path on the
+// server, to a path within the export.  A target outside the exported
+// directory cannot be reached through the export and fails with
+// ErrOutsideExport.
+func (v *Target) exportRelative(target string) (string, error) {
+	_, root := v.exportRoot()
+	target = _path.Clean(target)
+	dir := strings.TrimSuffix(_path.Clean("/"+root), "/")
+	if target == dir || strings.HasPrefix(target, dir+"/") {
+		return strings.TrimPrefix(target[len(dir):], "/"), nil
+	}
+
+	return "", ErrOutsideExport
+}
+
 // lookup returns the same as above, but by fh and name
 func (v *Target) lookup(fh []byte, name string) (*Fattr, []byte, *Fattr, error) {
 	type Lookup3Args struct {
@@ -216,7 +673,17 @@ func (v *Target) lookup(fh []byte, name string) (*Fattr, []byte, *Fattr, error)
 	return &lookupres.Attr.Attr, lookupres.FH, &lookupres.DirAttr.Attr, nil
 }
 
-// Access file
+// LookupByParentFh looks up name in the directory with the handle fh, without
+// following a symlink named name
+func (v *Target) LookupByParentFh(fh []byte, name string) (*Fattr, []byte, error) {
+	fattr, fh, _, err := v.lookup(fh, name)
+	return fattr, fh, err
+}
+
+// Access asks the server which of the ACCESS3_* rights in mode the credentials
+// of the target are granted on the file at path, and returns those granted.
+// Unlike guessing from the mode bits, this takes root squashing, ACLs and
+// other server side policy into account.
 func (v *Target) Access(path string, mode uint32) (uint32, error) {
 
 	_, fh, err := v.Lookup(path)
@@ -229,7 +696,12 @@ func (v *Target) Access(path string, mode uint32) (uint32, error) {
 	return mode, err
 }
 
-// access returns the same as above, but by fh and name
+// AccessByFh is like Access for the file with the handle fh
+func (v *Target) AccessByFh(fh []byte, mode uint32) (uint32, error) {
+	_, mode, err := v.access(fh, fmt.Sprintf("%x", fh), mode)
+	return mode, err
+}
+
 func (v *Target) access(fh []byte, path string, access uint32) (*Fattr, uint32, error) {
 	type Access3Args struct {
 		rpc.Header
@@ -282,104 +754,95 @@ func (v *Target) ReadDirPlus(dir string) ([]*EntryPlus, error) {
 }
 
 func (v *Target) ReadDirPlusByFh(fh []byte) ([]*EntryPlus, error) {
-	cookie := uint64(0)
-	cookieVerf := uint64(0)
-	eof := false
+	var entries []*EntryPlus
 
-	type ReadDirPlus3Args struct {
-		rpc.Header
-		FH         []byte
-		Cookie     uint64
-		CookieVerf uint64
-		DirCount   uint32
-		MaxCount   uint32
+	it := v.ReadDirPlusIterByFh(fh)
+	for it.Next() {
+		entries = append(entries, it.Entry())
 	}
 
-	type DirListPlus3 struct {
-		IsSet bool      `xdr:"union"`
-		Entry EntryPlus `xdr:"unioncase=1"`
+	if err := it.Err(); err != nil {
+		return nil, err
 	}
 
-	type DirListOK struct {
-		DirAttrs   PostOpAttr
-		CookieVerf uint64
+	return entries, nil
+}
+
+// Mkdir creates the directory at path with the permissions perm and returns
+// its handle.  The parent directory must exist, see MkdirAll.
+func (v *Target) Mkdir(path string, perm os.FileMode) ([]byte, error) {
+	dir, newDir := _path.Split(path)
+	_, fh, err := v.Lookup(dir)
+	if err != nil {
+		return nil, err
 	}
 
-	var entries []*EntryPlus
-	for !eof {
-		res, err := v.call(&ReadDirPlus3Args{
-			Header: rpc.Header{
-				Rpcvers: 2,
-				Prog:    Nfs3Prog,
-				Vers:    Nfs3Vers,
-				Proc:    NFSProc3ReadDirPlus,
-				Cred:    v.auth,
-				Verf:    rpc.AuthNull,
-			},
-			FH:         fh,
-			Cookie:     cookie,
-			CookieVerf: cookieVerf,
-			DirCount:   512,
-			MaxCount:   4096,
-		})
+	return v.MkdirByParentFh(fh, newDir, perm)
+}
 
-		if err != nil {
-			util.Debugf("readdir(%x): %s", fh, err.Error())
-			return nil, err
-		}
+// MkdirAll creates the directory at path along with any parents missing, like
+// os.MkdirAll.  Existing directories are left alone, including those another
+// client creates concurrently.
+func (v *Target) MkdirAll(path string, perm os.FileMode) error {
+	path = _path.Clean(path)
+	if path == "." || path == "/" {
+		return nil
+	}
 
-		// The dir list entries are so-called "optional-data".  We need to check
-		// the Follows fields before continuing down the array.  Effectively, it's
-		// an encoding used to flatten a linked list into an array where the
-		// Follows field is set when the next idx has data. See
-		// https://tools.ietf.org/html/rfc4506.html#section-4.19 for details.
-		dirlistOK := new(DirListOK)
-		if err = xdr.Read(res, dirlistOK); err != nil {
-			util.Errorf("readdir failed to parse result (%x): %s", fh, err.Error())
-			util.Debugf("partial dirlist: %+v", dirlistOK)
-			return nil, err
+	info, _, err := v.Lookup(path)
+	if err == nil {
+		if info.IsDir() {
+			return nil
 		}
 
-		for {
-			var item DirListPlus3
-			if err = xdr.Read(res, &item); err != nil {
-				util.Errorf("readdir failed to parse directory entry, aborting")
-				util.Debugf("partial dirent: %+v", item)
-				return nil, err
-			}
+		return NFS3Error(NFS3ErrNotDir)
+	}
 
-			if !item.IsSet {
-				break
-			}
+	if !os.IsNotExist(err) {
+		return err
+	}
 
-			cookie = item.Entry.Cookie
-			entries = append(entries, &item.Entry)
+	if parent := _path.Dir(path); parent != "." && parent != "/" {
+		if err = v.MkdirAll(parent, perm); err != nil {
+			return err
 		}
+	}
 
-		if err = xdr.Read(res, &eof); err != nil {
-			util.Errorf("readdir failed to determine presence of more data to read, aborting")
-			return nil, err
+	if _, err = v.Mkdir(path, perm); err != nil {
+		// lost a race with another client creating it
+		if info, _, lerr := v.Lookup(path); lerr == nil && info.IsDir() {
+			return nil
 		}
 
-		util.Debugf("No EOF for dirents so calling back for more")
-		cookieVerf = dirlistOK.CookieVerf
+		return err
 	}
 
-	return entries, nil
+	return nil
 }
 
-func (v *Target) Mkdir(path string, perm os.FileMode) ([]byte, error) {
+// MkdirWithAttrs creates the directory at path with the attributes set in attr,
+// such as its owner and times along with its mode, in a single MKDIR
+func (v *Target) MkdirWithAttrs(path string, attr Sattr3) ([]byte, error) {
 	dir, newDir := _path.Split(path)
 	_, fh, err := v.Lookup(dir)
 	if err != nil {
 		return nil, err
 	}
 
-	return v.MkdirByParentFh(fh, newDir, perm)
+	return v.mkdir(fh, newDir, attr)
 }
 
 // Creates a directory of the given name and returns its handle
 func (v *Target) MkdirByParentFh(fh []byte, name string, perm os.FileMode) ([]byte, error) {
+	return v.mkdir(fh, name, Sattr3{
+		Mode: SetMode{
+			SetIt: true,
+			Mode:  uint32(perm.Perm()),
+		},
+	})
+}
+
+func (v *Target) mkdir(fh []byte, name string, attr Sattr3) ([]byte, error) {
 	type MkdirArgs struct {
 		rpc.Header
 		Where Diropargs3
@@ -405,12 +868,7 @@ func (v *Target) MkdirByParentFh(fh []byte, name string, perm os.FileMode) ([]by
 			FH:       fh,
 			Filename: name,
 		},
-		Attrs: Sattr3{
-			Mode: SetMode{
-				SetIt: true,
-				Mode:  uint32(perm.Perm()),
-			},
-		},
+		Attrs: attr,
 	}
 	res, err := v.call(args)
 
@@ -433,11 +891,176 @@ func (v *Target) MkdirByParentFh(fh []byte, name string, perm os.FileMode) ([]by
 
 // Create a file with name the given mode
 func (v *Target) CreateTruncate(path string, perm os.FileMode, size uint64) ([]byte, error) {
-	_, _, newFile, fh, err := v.lookupInner(v.fh, path, false, nil)
+	_, _, newFile, fh, err := v.lookupInner(v.fh, path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	newFh, _, err := v.create(fh, newFile, createUnchecked, Sattr3{
+		Mode: SetMode{
+			SetIt: true,
+			Mode:  uint32(perm.Perm()),
+		},
+		Size: SetSize{
+			SetIt: true,
+			Size:  size,
+		},
+	})
+	if err != nil {
+		util.Debugf("create(%s): %s", path, err.Error())
+		return nil, err
+	}
+
+	util.Debugf("create(%s): created successfully", path)
+	return newFh, nil
+}
+
+// CreateWithAttrs creates the file at path with the attributes set in attr,
+// such as its owner and times along with its mode, in a single CREATE
+func (v *Target) CreateWithAttrs(path string, attr Sattr3) ([]byte, error) {
+	_, _, newFile, fh, err := v.lookupInner(v.fh, path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	newFh, _, err := v.create(fh, newFile, createUnchecked, attr)
+	if err != nil {
+		util.Debugf("create(%s): %s", path, err.Error())
+		return nil, err
+	}
+
+	return newFh, nil
+}
+
+// Create a file with name the given mode
+func (v *Target) Create(path string, perm os.FileMode) ([]byte, error) {
+	_, _, newFile, fh, err := v.lookupInner(v.fh, path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.CreateByFh(fh, newFile, perm)
+}
+
+// Stat returns the attributes of the file at path, following symlinks
+func (v *Target) Stat(path string) (os.FileInfo, error) {
+	fattr, _, _, _, err := v.lookupInner(v.fh, path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if fattr == nil {
+		// path names the root of the export
+		if fattr, err = v.GetAttrFh(v.fh); err != nil {
+			return nil, err
+		}
+	}
+
+	return &fileInfo{name: _path.Base(path), Fattr: fattr}, nil
+}
+
+// Lstat returns the attributes of the file at path.  A symlink at path is
+// described itself rather than followed.
+func (v *Target) Lstat(path string) (os.FileInfo, error) {
+	fattr, _, err := v.lstat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{name: _path.Base(path), Fattr: fattr}, nil
+}
+
+// lstat returns the attributes and handle of the file at path, without
+// following a symlink at path
+func (v *Target) lstat(path string) (*Fattr, []byte, error) {
+	_, _, name, dirFh, err := v.lookupInner(v.fh, _path.Clean(path), false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if name == "" || name == "." {
+		fattr, err := v.GetAttrFh(v.fh)
+		return fattr, v.fh, err
+	}
+
+	fattr, fh, _, err := v.lookup(dirFh, name)
+	return fattr, fh, err
+}
+
+func (v *Target) GetAttr(path string) (*Fattr, []byte, error) {
+	_, fh, err := v.Lookup(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fattr, err := v.GetAttrFh(fh)
+
+	util.Debugf("getattr(%s): FH 0x%x, attr: %+v", path, fh, fattr)
+	return fattr, fh, err
+}
+
+func (v *Target) GetAttrFh(fh []byte) (*Fattr, error) {
+	type GetAttrArgs struct {
+		rpc.Header
+		FH []byte
+	}
+
+	type GetAttrOk struct {
+		Attr Fattr
+	}
+
+	res, err := v.call(&GetAttrArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    Nfs3Prog,
+			Vers:    Nfs3Vers,
+			Proc:    NFSProc3GetAttr,
+			Cred:    v.auth,
+			Verf:    rpc.AuthNull,
+		},
+		FH: fh,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	getAttrRes := new(GetAttrOk)
+	if err := xdr.Read(res, getAttrRes); err != nil {
+		util.Debugf("getattr partial decode: %+v", *getAttrRes)
+		util.Debugf("getattr raw res: %+v", res)
+		return nil, err
+	}
+
+	return &getAttrRes.Attr, nil
+}
+
+// Create a file with name the given mode
+func (v *Target) CreateByFh(fh []byte, name string, perm os.FileMode) ([]byte, error) {
+	newFh, _, err := v.create(fh, name, createUnchecked, Sattr3{
+		Mode: SetMode{
+			SetIt: true,
+			Mode:  uint32(perm.Perm()),
+		},
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	util.Debugf("create(%+v %s): created successfully", fh, name)
+	return newFh, nil
+}
+
+// createmode3
+const (
+	createUnchecked = iota
+	createGuarded
+	createExclusive
+)
+
+// create issues a CREATE for name in the directory fh and returns the new
+// handle along with its attributes, when the server sent them
+func (v *Target) create(fh []byte, name string, mode uint32, attr Sattr3) ([]byte, *Fattr, error) {
 	type How struct {
 		// 0 : UNCHECKED (default)
 		// 1 : GUARDED
@@ -468,121 +1091,242 @@ func (v *Target) CreateTruncate(path string, perm os.FileMode, size uint64) ([]b
 		},
 		Where: Diropargs3{
 			FH:       fh,
-			Filename: newFile,
+			Filename: name,
 		},
 		HW: How{
-			Attr: Sattr3{
-				Mode: SetMode{
-					SetIt: true,
-					Mode:  uint32(perm.Perm()),
-				},
-				Size: SetSize{
-					SetIt: true,
-					Size:  size,
-				},
-			},
+			Mode: mode,
+			Attr: attr,
 		},
 	})
 
 	if err != nil {
-		util.Debugf("create(%s): %s", path, err.Error())
-		return nil, err
+		return nil, nil, err
 	}
 
 	status := new(Create3Res)
 	if err = xdr.Read(res, status); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	util.Debugf("create(%s): created successfully", path)
-	return status.FH.FH, nil
+	// The server is allowed to omit the new handle, in which case it has to be
+	// looked up.
+	if !status.FH.IsSet {
+		fattr, newFh, _, err := v.lookup(fh, name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return newFh, fattr, nil
+	}
+
+	if !status.Attr.IsSet {
+		return status.FH.FH, nil, nil
+	}
+
+	return status.FH.FH, &status.Attr.Attr, nil
 }
 
-// Create a file with name the given mode
-func (v *Target) Create(path string, perm os.FileMode) ([]byte, error) {
-	_, _, newFile, fh, err := v.lookupInner(v.fh, path, false, nil)
+// CreateExclusive creates a file using the EXCLUSIVE mode of CREATE and sets
+// its mode afterwards.  The server records verf with the file, so a
+// retransmitted request is recognized as such, while a file created by anybody
+// else fails with os.ErrExist.  verf must be unique for each file created; a
+// zero verf is replaced with a random one.
+func (v *Target) CreateExclusive(path string, perm os.FileMode, verf [NFS3_CREATEVERFSIZE]byte) ([]byte, error) {
+	_, _, newFile, fh, err := v.lookupInner(v.fh, path, false)
 	if err != nil {
 		return nil, err
 	}
 
-	return v.CreateByFh(fh, newFile, perm)
+	newFh, _, err := v.createExclusive(fh, newFile, perm, verf)
+	if err != nil {
+		util.Debugf("create(%s): %s", path, err.Error())
+		return nil, err
+	}
+
+	util.Debugf("create(%s): created successfully", path)
+	return newFh, nil
 }
 
-func (v *Target) GetAttr(path string) (*Fattr, []byte, error) {
-	_, fh, err := v.Lookup(path)
+// createExclusive exclusively creates name in the directory fh.  The server
+// keeps the verifier in the file's attributes, so they are reset once the file
+// exists, as described in RFC 1813.
+func (v *Target) createExclusive(fh []byte, name string, perm os.FileMode, verf [NFS3_CREATEVERFSIZE]byte) ([]byte, *Fattr, error) {
+	type How struct {
+		// 2 : EXCLUSIVE
+		Mode uint32
+		Verf [NFS3_CREATEVERFSIZE]byte
+	}
+	type Create3Args struct {
+		rpc.Header
+		Where Diropargs3
+		HW    How
+	}
+
+	type Create3Res struct {
+		FH     PostOpFH3
+		Attr   PostOpAttr
+		DirWcc WccData
+	}
+
+	if verf == [NFS3_CREATEVERFSIZE]byte{} {
+		if _, err := rand.Read(verf[:]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	res, err := v.call(&Create3Args{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    Nfs3Prog,
+			Vers:    Nfs3Vers,
+			Proc:    NFSProc3Create,
+			Cred:    v.auth,
+			Verf:    rpc.AuthNull,
+		},
+		Where: Diropargs3{
+			FH:       fh,
+			Filename: name,
+		},
+		HW: How{
+			Mode: createExclusive,
+			Verf: verf,
+		},
+	})
+
 	if err != nil {
 		return nil, nil, err
 	}
 
-	fattr, err := v.GetAttrFh(fh)
+	status := new(Create3Res)
+	if err = xdr.Read(res, status); err != nil {
+		return nil, nil, err
+	}
 
-	util.Debugf("getattr(%s): FH 0x%x, attr: %+v", path, fh, fattr)
-	return fattr, fh, err
-}
+	newFh := status.FH.FH
+	if !status.FH.IsSet {
+		if _, newFh, _, err = v.lookup(fh, name); err != nil {
+			return nil, nil, err
+		}
+	}
 
-func (v *Target) GetAttrFh(fh []byte) (*Fattr, error) {
-	type GetAttrArgs struct {
-		rpc.Header
-		FH []byte
+	err = v.SetAttrByFh(newFh, Sattr3{
+		Mode: SetMode{
+			SetIt: true,
+			Mode:  uint32(perm.Perm()),
+		},
+		Atime: SetTime{
+			SetIt: SetToServerTime,
+		},
+		Mtime: SetTime{
+			SetIt: SetToServerTime,
+		},
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	type GetAttrOk struct {
-		Attr Fattr
+	fattr, err := v.GetAttrFh(newFh)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	res, err := v.call(&GetAttrArgs{
-		Header: rpc.Header{
-			Rpcvers: 2,
-			Prog:    Nfs3Prog,
-			Vers:    Nfs3Vers,
-			Proc:    NFSProc3GetAttr,
-			Cred:    v.auth,
-			Verf:    rpc.AuthNull,
-		},
-		FH: fh,
-	})
+	return newFh, fattr, nil
+}
 
-	if err != nil {
-		return nil, err
+// Mkfifo creates a named pipe at path
+func (v *Target) Mkfifo(path string, perm os.FileMode) ([]byte, error) {
+	return v.Mknod(path, os.ModeNamedPipe|perm.Perm(), 0, 0)
+}
+
+// Mksocket creates a unix domain socket node at path
+func (v *Target) Mksocket(path string, perm os.FileMode) ([]byte, error) {
+	return v.Mknod(path, os.ModeSocket|perm.Perm(), 0, 0)
+}
+
+// Mknod creates a special file at path and returns its handle.  The type bits
+// of mode select a character device (os.ModeDevice|os.ModeCharDevice), a block
+// device (os.ModeDevice), a named pipe or a socket.  major and minor are the
+// device numbers of device nodes and ignored otherwise.
+func (v *Target) Mknod(path string, mode os.FileMode, major, minor uint32) ([]byte, error) {
+	var ftype uint32
+	switch {
+	case mode&os.ModeCharDevice != 0:
+		ftype = NF3Chr
+	case mode&os.ModeDevice != 0:
+		ftype = NF3Blk
+	case mode&os.ModeNamedPipe != 0:
+		ftype = NF3FIFO
+	case mode&os.ModeSocket != 0:
+		ftype = NF3Sock
+	default:
+		return nil, &os.PathError{Op: "mknod", Path: path, Err: os.ErrInvalid}
+	}
+
+	dir, name := _path.Split(path)
+	if err := checkName(name); err != nil {
+		return nil, &os.PathError{Op: "mknod", Path: path, Err: err}
 	}
 
-	getAttrRes := new(GetAttrOk)
-	if err := xdr.Read(res, getAttrRes); err != nil {
-		util.Debugf("getattr partial decode: %+v", *getAttrRes)
-		util.Debugf("getattr raw res: %+v", res)
+	_, fh, err := v.Lookup(dir)
+	if err != nil {
 		return nil, err
 	}
 
-	return &getAttrRes.Attr, nil
+	return v.MknodByParentFh(fh, name, ftype, chmodAttr(mode), major, minor)
 }
 
-// Create a file with name the given mode
-func (v *Target) CreateByFh(fh []byte, name string, perm os.FileMode) ([]byte, error) {
-	type How struct {
-		// 0 : UNCHECKED (default)
-		// 1 : GUARDED
-		// 2 : EXCLUSIVE
-		Mode uint32
-		Attr Sattr3
+// MknodByParentFh creates the special file name of type ftype, one of NF3Chr,
+// NF3Blk, NF3Sock and NF3FIFO, in the directory fh
+func (v *Target) MknodByParentFh(fh []byte, name string, ftype uint32, attr Sattr3, major, minor uint32) ([]byte, error) {
+	// mknoddata3 is a union on the type, with device numbers only for devices
+	type DeviceData struct {
+		Type  uint32
+		Attrs Sattr3
+		Major uint32
+		Minor uint32
 	}
-	type Create3Args struct {
+
+	type PipeData struct {
+		Type  uint32
+		Attrs Sattr3
+	}
+
+	type Mknod3Args struct {
 		rpc.Header
 		Where Diropargs3
-		HW    How
+		What  interface{}
 	}
 
-	type Create3Res struct {
+	type MknodOk struct {
 		FH     PostOpFH3
 		Attr   PostOpAttr
 		DirWcc WccData
 	}
 
-	res, err := v.call(&Create3Args{
+	var what interface{}
+	switch ftype {
+	case NF3Chr, NF3Blk:
+		what = &DeviceData{
+			Type:  ftype,
+			Attrs: attr,
+			Major: major,
+			Minor: minor,
+		}
+	case NF3Sock, NF3FIFO:
+		what = &PipeData{
+			Type:  ftype,
+			Attrs: attr,
+		}
+	default:
+		return nil, NFS3Error(NFS3ErrBadType)
+	}
+
+	res, err := v.call(&Mknod3Args{
 		Header: rpc.Header{
 			Rpcvers: 2,
 			Prog:    Nfs3Prog,
 			Vers:    Nfs3Vers,
-			Proc:    NFSProc3Create,
+			Proc:    NFSProc3Mknod,
 			Cred:    v.auth,
 			Verf:    rpc.AuthNull,
 		},
@@ -590,27 +1334,31 @@ func (v *Target) CreateByFh(fh []byte, name string, perm os.FileMode) ([]byte, e
 			FH:       fh,
 			Filename: name,
 		},
-		HW: How{
-			Attr: Sattr3{
-				Mode: SetMode{
-					SetIt: true,
-					Mode:  uint32(perm.Perm()),
-				},
-			},
-		},
+		What: what,
 	})
 
 	if err != nil {
+		util.Debugf("mknod(%x %s): %s", fh, name, err.Error())
 		return nil, err
 	}
 
-	status := new(Create3Res)
-	if err = xdr.Read(res, status); err != nil {
+	mknodres := new(MknodOk)
+	if err = xdr.Read(res, mknodres); err != nil {
+		util.Errorf("mknod(%x %s) failed to parse return: %s", fh, name, err)
 		return nil, err
 	}
 
-	util.Debugf("create(%+v %s): created successfully", fh, name)
-	return status.FH.FH, nil
+	if mknodres.FH.IsSet {
+		return mknodres.FH.FH, nil
+	}
+
+	// the server may leave the handle out of the reply
+	_, newFh, _, err := v.lookup(fh, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFh, nil
 }
 
 // Remove a file
@@ -624,6 +1372,11 @@ func (v *Target) Remove(path string) error {
 	return v.remove(fh, deleteFile)
 }
 
+// RemoveByParentFh removes the file name from the directory with the handle fh
+func (v *Target) RemoveByParentFh(fh []byte, name string) error {
+	return v.remove(fh, name)
+}
+
 // remove the named file from the parent (fh)
 func (v *Target) remove(fh []byte, deleteFile string) error {
 	type RemoveArgs struct {
@@ -665,6 +1418,12 @@ func (v *Target) RmDir(path string) error {
 	return v.rmDir(fh, deletedir)
 }
 
+// RmDirByParentFh removes the empty directory name from the directory with the
+// handle fh
+func (v *Target) RmDirByParentFh(fh []byte, name string) error {
+	return v.rmDir(fh, name)
+}
+
 // delete the named directory from the parent directory (fh)
 func (v *Target) rmDir(fh []byte, name string) error {
 	type RmDir3Args struct {
@@ -696,82 +1455,100 @@ func (v *Target) rmDir(fh []byte, name string) error {
 	return nil
 }
 
+// removeAllRetries is how many more times RemoveAll empties a directory that
+// others keep adding entries to
+const removeAllRetries = 2
+
+// RemoveAll removes path and any children it contains, like os.RemoveAll.  It
+// carries on past entries it fails to remove and then returns all failures,
+// each as an *os.PathError, wrapped in a *MultiError when there are several.
+// A path which does not exist is not an error.
 func (v *Target) RemoveAll(path string) error {
-	_, _, deleteDir, parentDirfh, err := v.lookupInner(v.fh, path, false, nil)
+	_, _, name, parentfh, err := v.lookupInner(v.fh, path, false)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return err
 	}
 
-	// Easy path.  This is a directory and it's empty.  If not a dir or not an
-	// empty dir, this will throw an error.
-	err = v.rmDir(parentDirfh, deleteDir)
-	if err == nil || os.IsNotExist(err) {
-		return nil
+	if name == "" || name == "." {
+		return &os.PathError{Op: "removeall", Path: path, Err: os.ErrInvalid}
 	}
 
-	// Collect the not a dir error.
-	if IsNotDirError(err) {
-		return err
-	}
+	return multiError(v.removeTree(parentfh, name, path, nil, nil))
+}
 
-	_, deleteDirfh, _, _, err := v.lookupInner(parentDirfh, deleteDir, true, nil)
-	if err != nil {
-		return err
+// removeTree removes the entry name of the directory parentfh, first removing
+// its children if it is a directory.  fattr and fh are looked up unless known
+// from the listing of the parent.
+func (v *Target) removeTree(parentfh []byte, name, path string, fattr *Fattr, fh []byte) []error {
+	if fattr == nil || (fattr.Type == NF3Dir && fh == nil) {
+		var err error
+		if fattr, fh, _, err = v.lookup(parentfh, name); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return []error{&os.PathError{Op: "lookup", Path: path, Err: err}}
+		}
 	}
 
-	if err = v.removeAll(deleteDirfh); err != nil {
-		return err
+	if fattr.Type != NF3Dir {
+		if err := v.remove(parentfh, name); err != nil && !os.IsNotExist(err) {
+			util.Errorf("error deleting %s: %s", path, err.Error())
+			return []error{&os.PathError{Op: "remove", Path: path, Err: err}}
+		}
+		return nil
 	}
 
-	// Delete the directory we started at.
-	if err = v.rmDir(parentDirfh, deleteDir); err != nil {
-		return err
-	}
+	for attempt := 0; ; attempt++ {
+		errs := v.removeChildren(fh, path)
 
-	return nil
-}
+		err := v.rmDir(parentfh, name)
+		if err == nil || os.IsNotExist(err) {
+			return errs
+		}
 
-// removeAll removes the deleteDir recursively
-func (v *Target) removeAll(deleteDirfh []byte) error {
+		// entries created by others while the children were removed
+		if IsNotEmptyError(err) && len(errs) == 0 && attempt < removeAllRetries {
+			continue
+		}
 
-	// BFS the dir tree recursively.  If dir, recurse, then delete the dir and
-	// all files.
+		util.Errorf("error deleting %s: %s", path, err.Error())
+		return append(errs, &os.PathError{Op: "rmdir", Path: path, Err: err})
+	}
+}
 
-	// This is a directory, get all of its Entries
-	entries, err := v.ReadDirPlusByFh(deleteDirfh)
+// removeChildren removes the entries of the directory fh found at path
+func (v *Target) removeChildren(fh []byte, path string) []error {
+	entries, err := v.ReadDirPlusByFh(fh)
 	if err != nil {
-		return err
+		return []error{&os.PathError{Op: "readdir", Path: path, Err: err}}
 	}
 
+	var errs []error
 	for _, entry := range entries {
 		// skip "." and ".."
 		if entry.FileName == "." || entry.FileName == ".." {
 			continue
 		}
 
-		// If directory, recurse, then nuke it.  It should be empty when we get
-		// back.
-		if entry.Attr.Attr.Type == NF3Dir {
-			if entry.Handle.IsSet {
-				if err = v.removeAll(entry.Handle.FH); err != nil {
-					return err
-				}
-			}
-
-			err = v.rmDir(deleteDirfh, entry.FileName)
-		} else {
-
-			// nuke all files
-			err = v.remove(deleteDirfh, entry.FileName)
+		// entries listed with READDIR carry neither attributes nor handles
+		var (
+			fattr *Fattr
+			efh   []byte
+		)
+		if entry.Attr.IsSet {
+			fattr = &entry.Attr.Attr
 		}
-
-		if err != nil {
-			util.Errorf("error deleting %s: %s", entry.FileName, err.Error())
-			return err
+		if entry.Handle.IsSet {
+			efh = entry.Handle.FH
 		}
+
+		errs = append(errs, v.removeTree(fh, entry.FileName, _path.Join(path, entry.FileName), fattr, efh)...)
 	}
 
-	return nil
+	return errs
 }
 
 func (v *Target) GetAttrByFh(fh []byte) (*Fattr, error) {
@@ -817,6 +1594,87 @@ type Guard struct {
 }
 
 func (v *Target) SetAttrByFh(fh []byte, fattr Sattr3) error {
+	_, err := v.setAttr(fh, fattr)
+	return err
+}
+
+// Chmod changes the mode of the file at path, following symlinks
+func (v *Target) Chmod(path string, mode os.FileMode) error {
+	return v.setAttrPath("chmod", path, chmodAttr(mode))
+}
+
+// Chown changes the owner and group of the file at path, following symlinks.
+// An id of -1 leaves it unchanged.
+func (v *Target) Chown(path string, uid, gid int) error {
+	return v.setAttrPath("chown", path, chownAttr(uid, gid))
+}
+
+// Lchown is like Chown, but changes the symlink at path instead of its target
+func (v *Target) Lchown(path string, uid, gid int) error {
+	_, fh, err := v.lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err = v.setAttr(fh, chownAttr(uid, gid)); err != nil {
+		util.Debugf("lchown(%s): %s", path, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Chtimes changes the access and modification times of the file at path,
+// following symlinks.  A zero time leaves it unchanged.
+func (v *Target) Chtimes(path string, atime, mtime time.Time) error {
+	return v.setAttrPath("chtimes", path, chtimesAttr(atime, mtime))
+}
+
+// Truncate changes the size of the file at path, following symlinks, without
+// opening it
+func (v *Target) Truncate(path string, size int64) error {
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: path, Err: os.ErrInvalid}
+	}
+
+	return v.setAttrPath("truncate", path, Sattr3{
+		Size: SetSize{
+			SetIt: true,
+			Size:  uint64(size),
+		},
+	})
+}
+
+func (v *Target) setAttrPath(op, path string, attr Sattr3) error {
+	_, fh, err := v.Lookup(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err = v.setAttr(fh, attr); err != nil {
+		util.Debugf("%s(%s): %s", op, path, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// SetAttrByFhGuarded is like SetAttrByFh, but the server only changes the
+// attributes if the ctime of the file still is ctime, as returned by a previous
+// GETATTR.  When another client changed the file in the meantime it fails with
+// an error for which IsNotSyncError is true.
+func (v *Target) SetAttrByFhGuarded(fh []byte, fattr Sattr3, ctime NFS3Time) error {
+	_, err := v.setAttrGuard(fh, fattr, Guard{Check: true, Ctime: ctime})
+	return err
+}
+
+// setAttr issues a SETATTR and returns the attributes the server reported
+// afterwards, if any
+func (v *Target) setAttr(fh []byte, fattr Sattr3) (*Fattr, error) {
+	return v.setAttrGuard(fh, fattr, Guard{})
+}
+
+func (v *Target) setAttrGuard(fh []byte, fattr Sattr3, guard Guard) (*Fattr, error) {
 	type SetAttr3Args struct {
 		rpc.Header
 		FH    []byte
@@ -839,42 +1697,133 @@ func (v *Target) SetAttrByFh(fh []byte, fattr Sattr3) error {
 		},
 		FH:    fh,
 		Fattr: fattr,
-		Guard: Guard{
-			Check: false,
-		},
+		Guard: guard,
 	})
 
 	if err != nil {
 		util.Debugf("setattr: %s", err.Error())
-		return err
+		return nil, err
 	}
 
 	wccData := new(WccData)
 	if err = xdr.Read(res, wccData); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	if !wccData.After.IsSet {
+		return nil, nil
+	}
+
+	return &wccData.After.Attr, nil
 }
 
+// Rename moves fromPath to toPath, which may be in another directory of the
+// export, replacing what toPath refers to.  Symlinks in the last element of
+// either path are renamed or replaced themselves rather than followed.
 func (v *Target) Rename(fromPath string, toPath string) error {
-	_, _, fromName, fromFh, err := v.lookupInner(v.fh, fromPath, true, nil)
+	_, _, fromName, fromFh, err := v.lookupInner(v.fh, _path.Clean(fromPath), false)
 	if err != nil {
 		return err
 	}
-	if fromFh == nil {
-		return fmt.Errorf("fromName cannot be a root directory")
+	if err = checkName(fromName); err != nil {
+		return &os.LinkError{Op: "rename", Old: fromPath, New: toPath, Err: err}
 	}
-	_, _, toName, toFh, err := v.lookupInner(v.fh, toPath, false, nil)
+
+	_, _, toName, toFh, err := v.lookupInner(v.fh, _path.Clean(toPath), false)
 	if err != nil {
 		return err
 	}
-	if toFh == nil {
-		return fmt.Errorf("toName cannot be a root directory")
+	if err = checkName(toName); err != nil {
+		return &os.LinkError{Op: "rename", Old: fromPath, New: toPath, Err: err}
 	}
+
 	return v.RenameByFh(fromFh, fromName, toFh, toName)
 }
 
+// checkName rejects the names which cannot be the last element of the path of
+// a directory entry to create, rename or remove
+func checkName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return fmt.Errorf("invalid name %q: names the root or a directory by a relative name", name)
+	case strings.ContainsRune(name, '/'), strings.ContainsRune(name, 0):
+		return fmt.Errorf("invalid name %q", name)
+	}
+
+	return nil
+}
+
+// Link creates newPath as a hard link to the file at oldPath.  A symlink at
+// oldPath is linked to itself rather than followed.
+func (v *Target) Link(oldPath, newPath string) error {
+	_, _, oldName, oldDirFh, err := v.lookupInner(v.fh, _path.Clean(oldPath), false)
+	if err != nil {
+		return err
+	}
+	if err = checkName(oldName); err != nil {
+		return &os.LinkError{Op: "link", Old: oldPath, New: newPath, Err: err}
+	}
+
+	_, fh, _, err := v.lookup(oldDirFh, oldName)
+	if err != nil {
+		return err
+	}
+
+	_, _, newName, newDirFh, err := v.lookupInner(v.fh, _path.Clean(newPath), false)
+	if err != nil {
+		return err
+	}
+	if err = checkName(newName); err != nil {
+		return &os.LinkError{Op: "link", Old: oldPath, New: newPath, Err: err}
+	}
+
+	return v.LinkByFh(fh, newDirFh, newName)
+}
+
+// LinkByFh creates the entry name in the directory dirFh as a hard link to the
+// file fh
+func (v *Target) LinkByFh(fh []byte, dirFh []byte, name string) error {
+	type Link3Args struct {
+		rpc.Header
+		FH   []byte
+		Link Diropargs3
+	}
+
+	type Link3Res struct {
+		Attr       PostOpAttr
+		LinkDirWcc WccData
+	}
+
+	res, err := v.call(&Link3Args{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    Nfs3Prog,
+			Vers:    Nfs3Vers,
+			Proc:    NFSProc3Link,
+			Cred:    v.auth,
+			Verf:    rpc.AuthNull,
+		},
+		FH: fh,
+		Link: Diropargs3{
+			FH:       dirFh,
+			Filename: name,
+		},
+	})
+
+	if err != nil {
+		util.Debugf("link(%x -> %x %s): %s", fh, dirFh, name, err.Error())
+		return err
+	}
+
+	linkres := new(Link3Res)
+	if err = xdr.Read(res, linkres); err != nil {
+		return err
+	}
+
+	util.Debugf("link(%x): linked as (%x %s)", fh, dirFh, name)
+	return nil
+}
+
 func (v *Target) RenameByFh(fromFh []byte, fromName string, toFh []byte, toName string) error {
 	type Rename3Args struct {
 		rpc.Header
@@ -920,13 +1869,119 @@ func (v *Target) RenameByFh(fromFh []byte, fromName string, toFh []byte, toName
 	return nil
 }
 
-// Readlink reads a symbolic link and returns the target
+// Symlink creates linkPath as a symlink to target, with the attributes set in
+// attrs if not nil, and returns its handle and attributes.  The attributes are
+// nil if the server returned none.
+func (v *Target) Symlink(target string, linkPath string, attrs *Sattr3) ([]byte, *Fattr, error) {
+	_, _, name, dirFh, err := v.lookupInner(v.fh, _path.Clean(linkPath), false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = checkName(name); err != nil {
+		return nil, nil, &os.LinkError{Op: "symlink", Old: target, New: linkPath, Err: err}
+	}
+
+	return v.symlink(dirFh, name, target, attrs)
+}
+
+// SymlinkByParentFh creates the symlink name pointing to target in the
+// directory with the handle fh, see Symlink
+func (v *Target) SymlinkByParentFh(fh []byte, name, target string, attrs *Sattr3) ([]byte, *Fattr, error) {
+	if err := checkName(name); err != nil {
+		return nil, nil, &os.LinkError{Op: "symlink", Old: target, New: name, Err: err}
+	}
+
+	return v.symlink(fh, name, target, attrs)
+}
+
+func (v *Target) symlink(dirFh []byte, name, target string, attrs *Sattr3) ([]byte, *Fattr, error) {
+	type Symlinkdata3 struct {
+		Attrs Sattr3
+		Data  string
+	}
+
+	type SymlinkArgs struct {
+		rpc.Header
+		Where   Diropargs3
+		Symlink Symlinkdata3
+	}
+
+	type SymlinkRes struct {
+		FH     PostOpFH3
+		Attr   PostOpAttr
+		DirWcc WccData
+	}
+
+	var attr Sattr3
+	if attrs != nil {
+		attr = *attrs
+	}
+
+	res, err := v.call(&SymlinkArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    Nfs3Prog,
+			Vers:    Nfs3Vers,
+			Proc:    NFSProc3Symlink,
+			Cred:    v.auth,
+			Verf:    rpc.AuthNull,
+		},
+		Where: Diropargs3{
+			FH:       dirFh,
+			Filename: name,
+		},
+		Symlink: Symlinkdata3{
+			Attrs: attr,
+			Data:  target,
+		},
+	})
+
+	if err != nil {
+		util.Debugf("symlink(%s -> %s): %s", name, target, err.Error())
+		return nil, nil, err
+	}
+
+	symlinkres := new(SymlinkRes)
+	if err = xdr.Read(res, symlinkres); err != nil {
+		return nil, nil, err
+	}
+
+	if !symlinkres.FH.IsSet {
+		// the server may leave the handle out of the reply
+		fattr, fh, _, err := v.lookup(dirFh, name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return fh, fattr, nil
+	}
+
+	var fattr *Fattr
+	if symlinkres.Attr.IsSet {
+		fattr = &symlinkres.Attr.Attr
+	}
+
+	return symlinkres.FH.FH, fattr, nil
+}
+
+// Readlink reads a symbolic link and returns the target, like os.Readlink.
+// The symlink itself is looked up rather than followed.
 func (v *Target) Readlink(path string) (string, error) {
-	_, fh, err := v.Lookup(path)
+	fattr, fh, err := v.lstat(path)
 	if err != nil {
 		return "", err
 	}
 
+	if fattr.Type != NF3Lnk {
+		return "", &os.PathError{Op: "readlink", Path: path, Err: os.ErrInvalid}
+	}
+
+	_, target, err := v.readlinkFh(fh)
+	return target, err
+}
+
+// ReadlinkByFh returns the target of the symlink with the handle fh
+func (v *Target) ReadlinkByFh(fh []byte) (string, error) {
 	_, target, err := v.readlinkFh(fh)
 	return target, err
 }
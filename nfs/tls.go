@@ -0,0 +1,64 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+var (
+	errTLSOverUDP = errors.New("nfs: RPC-with-TLS needs TCP")
+	errNotPinned  = errors.New("nfs: the key of the server is not pinned")
+)
+
+// SPKIHash returns the SHA-256 hash of the SubjectPublicKeyInfo of cert, which
+// Dialer.PinnedKeys holds
+func SPKIHash(cert *x509.Certificate) []byte {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return h[:]
+}
+
+// tlsConfig returns the TLS configuration of the connections to host
+func (d *Dialer) tlsConfig(host string) *tls.Config {
+	config := d.TLS.Clone()
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	if len(d.PinnedKeys) > 0 {
+		config.VerifyConnection = verifyPinned(d.PinnedKeys, config.VerifyConnection)
+	}
+
+	return config
+}
+
+// verifyPinned returns a tls.Config.VerifyConnection accepting the servers
+// whose certificate has one of the SPKI hashes of pins, once verify accepted
+// them when not nil
+func verifyPinned(pins [][]byte, verify func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+
+		if len(cs.PeerCertificates) == 0 {
+			return errNotPinned
+		}
+
+		h := SPKIHash(cs.PeerCertificates[0])
+		for _, pin := range pins {
+			if bytes.Equal(pin, h) {
+				return nil
+			}
+		}
+
+		return errNotPinned
+	}
+}
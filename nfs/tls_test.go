@@ -0,0 +1,41 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"testing"
+)
+
+func TestVerifyPinned(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{SerialNumber: big.NewInt(1)}, &x509.Certificate{SerialNumber: big.NewInt(1)}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	if err = verifyPinned([][]byte{make([]byte, 32), SPKIHash(cert)}, nil)(cs); err != nil {
+		t.Errorf("pinned key: %v", err)
+	}
+
+	if err = verifyPinned([][]byte{make([]byte, 32)}, nil)(cs); err != errNotPinned {
+		t.Errorf("key not pinned: %v, want %v", err, errNotPinned)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import "fmt"
+
+// ChangedError is returned by the reads of a File validating them when the file
+// was changed by someone else since its attributes were last fetched.
+type ChangedError struct {
+	FH []byte
+
+	// attributes the File had cached and those returned by the READ
+	Old, New *Fattr
+}
+
+func (err *ChangedError) Error() string {
+	if err.New.Filesize < err.Old.Filesize {
+		return fmt.Sprintf("file %x shrank from %d to %d bytes while being read", err.FH, err.Old.Filesize, err.New.Filesize)
+	}
+
+	return fmt.Sprintf("file %x was modified while being read", err.FH)
+}
+
+func IsChangedError(err error) bool {
+	_, ok := err.(*ChangedError)
+	return ok
+}
+
+// SetValidateReads makes reads check the attributes the server returns with
+// every READ against those cached by the File, and fail with a *ChangedError
+// when the size or modification time of the file changed underneath them,
+// instead of silently returning the truncated or mixed data.  The cached
+// attributes are those fetched when the file was opened, refreshed by Stat,
+// SetAttr and the File's own writes.  Calling Stat accepts the change and
+// allows reading to continue.
+func (f *File) SetValidateReads(on bool) {
+	f.validate = on
+}
+
+// checkChanged compares the attributes returned by a READ, issued once seq
+// WRITEs of f were, with those cached.  The READs overlapping WRITEs of f are
+// not checked, the changes they see being those of f itself.
+func (f *File) checkChanged(attr *Fattr, seq uint64) error {
+	if f.writing.Load() > 0 || f.writeSeq.Load() != seq {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fattr == nil {
+		f.fattr = attr
+		return nil
+	}
+
+	if attr.Filesize != f.fattr.Filesize || attr.Mtime != f.fattr.Mtime {
+		return &ChangedError{
+			FH:  f.fh,
+			Old: f.fattr,
+			New: attr,
+		}
+	}
+
+	return nil
+}
+
+// writes returns how many WRITEs f issued so far
+func (f *File) writes() uint64 {
+	return f.writeSeq.Load()
+}
+
+// startWrite records a WRITE of f being issued, returning its order
+func (f *File) startWrite() uint64 {
+	f.writing.Add(1)
+	return f.writeSeq.Add(1)
+}
+
+// endWrite records the WRITE issued as seq completing, with the attributes of
+// the file after it unless nil.  The replies to pipelined or concurrent WRITEs
+// come in any order, so the attributes are only cached when no WRITE issued
+// later already provided them.
+func (f *File) endWrite(seq uint64, attr *Fattr) {
+	if attr != nil && f.validate {
+		f.mu.Lock()
+		if seq > f.fattrSeq {
+			f.fattr, f.fattrSeq = attr, seq
+		}
+		f.mu.Unlock()
+	}
+	f.writing.Add(-1)
+}
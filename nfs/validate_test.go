@@ -0,0 +1,30 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import "testing"
+
+// test the attributes of WRITEs replied to out of order are those of the last
+// one issued, and the READs overlapping WRITEs are not checked
+func TestValidateReordered(t *testing.T) {
+	f := &File{validate: true, fattr: &Fattr{Filesize: 0}}
+
+	first, second := f.startWrite(), f.startWrite()
+	seq := f.writes()
+	f.endWrite(second, &Fattr{Filesize: 200})
+	if err := f.checkChanged(&Fattr{Filesize: 200}, seq); err != nil {
+		t.Errorf("read overlapping a write = %v", err)
+	}
+	f.endWrite(first, &Fattr{Filesize: 100})
+
+	if f.fattr.Filesize != 200 {
+		t.Errorf("cached size %d, want that of the last write issued", f.fattr.Filesize)
+	}
+	if err := f.checkChanged(&Fattr{Filesize: 200}, f.writes()); err != nil {
+		t.Errorf("read after the writes = %v", err)
+	}
+	if err := f.checkChanged(&Fattr{Filesize: 100}, f.writes()); !IsChangedError(err) {
+		t.Errorf("read of a shrunk file = %v, want a ChangedError", err)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"io/fs"
+	_path "path"
+	"sort"
+	"strings"
+)
+
+// dirEntry is an fs.DirEntry for a file whose attributes are known
+type dirEntry struct {
+	name  string
+	fattr *Fattr
+}
+
+func (d *dirEntry) Name() string               { return d.name }
+func (d *dirEntry) IsDir() bool                { return d.fattr.IsDir() }
+func (d *dirEntry) Type() fs.FileMode          { return d.fattr.Mode().Type() }
+func (d *dirEntry) Info() (fs.FileInfo, error) { return &fileInfo{name: d.name, Fattr: d.fattr}, nil }
+
+// WalkDir walks the tree rooted at root like filepath.WalkDir, calling fn for
+// root and every file and directory below it, in lexical order within each
+// directory.  The directories are listed with READDIRPLUS, so the entries
+// passed to fn come with their attributes without further GETATTRs.  fn may
+// return fs.SkipDir to skip a directory, or the rest of the directory holding
+// a file, or fs.SkipAll to stop walking.  Symlinks are not followed.  When
+// the attributes of root or of an entry cannot be fetched, fn is called with
+// its path, a nil DirEntry and the error.
+func (v *Target) WalkDir(root string, fn fs.WalkDirFunc) error {
+	fattr, fh, err := v.lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = v.walkDir(root, fh, &dirEntry{name: _path.Base(root), fattr: fattr}, fn)
+	}
+
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+
+	return err
+}
+
+func (v *Target) walkDir(path string, fh []byte, d *dirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			// successfully skipped directory
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := v.ReadDirPlusByFh(fh)
+	if err != nil {
+		// second call, to report the failure to list the directory
+		err = fn(path, d, err)
+		if err != nil {
+			if err == fs.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FileName < entries[j].FileName
+	})
+
+	for _, entry := range entries {
+		if entry.FileName == "." || entry.FileName == ".." {
+			continue
+		}
+
+		child := _path.Join(path, entry.FileName)
+
+		// entries listed with READDIR carry neither attributes nor handles
+		fattr, childFh := &entry.Attr.Attr, entry.Handle.FH
+		if !entry.Attr.IsSet || !entry.Handle.IsSet {
+			if fattr, childFh, _, err = v.lookup(fh, entry.FileName); err != nil {
+				if err = fn(child, nil, err); err != nil {
+					if err == fs.SkipDir {
+						break
+					}
+					return err
+				}
+				continue
+			}
+		}
+
+		if err = v.walkDir(child, childFh, &dirEntry{name: entry.FileName, fattr: fattr}, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Glob returns the paths matching pattern, or nil if there is none, like
+// filepath.Glob.  The syntax of the patterns is that of path.Match, and the
+// only possible error is path.ErrBadPattern.  Directories are listed with
+// READDIR, and I/O errors such as failing to list one are ignored.
+func (v *Target) Glob(pattern string) ([]string, error) {
+	// check the pattern is well-formed
+	if _, err := _path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	if !hasMeta(pattern) {
+		if _, err := v.Lstat(pattern); err != nil {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+
+	dir, file := _path.Split(pattern)
+	dir = cleanGlobPath(dir)
+
+	if !hasMeta(dir) {
+		return v.glob(dir, file, nil)
+	}
+
+	// prevent infinite recursion
+	if dir == pattern {
+		return nil, _path.ErrBadPattern
+	}
+
+	dirs, err := v.Glob(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, d := range dirs {
+		if matches, err = v.glob(d, file, matches); err != nil {
+			return nil, err
+		}
+	}
+
+	return matches, nil
+}
+
+// glob appends to matches the entries of dir matching pattern
+func (v *Target) glob(dir, pattern string, matches []string) ([]string, error) {
+	info, err := v.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return matches, nil
+	}
+
+	names, err := v.ReadDirNames(dir)
+	if err != nil {
+		return matches, nil
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		if n == "." || n == ".." {
+			continue
+		}
+
+		matched, err := _path.Match(pattern, n)
+		if err != nil {
+			return matches, err
+		}
+
+		if matched {
+			matches = append(matches, _path.Join(dir, n))
+		}
+	}
+
+	return matches, nil
+}
+
+// cleanGlobPath prepares the directory part of a pattern for matching
+func cleanGlobPath(path string) string {
+	switch path {
+	case "":
+		return "."
+	case "/":
+		return path
+	}
+
+	return path[:len(path)-1]
+}
+
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
@@ -0,0 +1,256 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"os"
+	_path "path"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-nfs/nfsv3/nfs/util"
+)
+
+const (
+	// defaultWatchInterval is how often a Watcher polls by default
+	defaultWatchInterval = time.Second
+
+	// watchBacklog is the number of events buffered for a slow receiver
+	watchBacklog = 64
+)
+
+// WatchOp is the kind of change a WatchEvent reports
+type WatchOp int
+
+const (
+	WatchCreate WatchOp = iota + 1
+	WatchRemove
+	WatchModify
+)
+
+func (op WatchOp) String() string {
+	switch op {
+	case WatchCreate:
+		return "create"
+	case WatchRemove:
+		return "remove"
+	case WatchModify:
+		return "modify"
+	}
+
+	return "unknown"
+}
+
+// WatchEvent is a change to an entry of a watched directory.  Name is the path
+// of the entry, joined to the path passed to Watch.  Attr holds the attributes
+// the entry was listed with, the last ones seen for WatchRemove, and is nil
+// when the server listed the directory without attributes.
+type WatchEvent struct {
+	Op   WatchOp
+	Name string
+	Attr *Fattr
+}
+
+// Watcher reports the changes to the entries of a directory
+type Watcher struct {
+	v        *Target
+	fh       []byte
+	path     string
+	interval time.Duration
+
+	events chan WatchEvent
+	done   chan struct{}
+	exited chan struct{}
+	close  sync.Once
+
+	// valid once events is closed
+	err error
+}
+
+// Watch polls the directory at path with GETATTR every interval, or every
+// second for a non-positive interval, and lists it again with READDIRPLUS
+// whenever its modification or change time moved.  The new listing is compared
+// with the previous one, and created, removed and modified entries are sent on
+// the Events channel.  Entries are told apart by name and file id, so a file
+// replaced by a rename shows up as removed and created again.  Changes to the
+// contents of a file only show up once the directory itself changed, as
+// servers don't update the directory for writes to its files.
+func (v *Target) Watch(path string, interval time.Duration) (*Watcher, error) {
+	fattr, fh, err := v.GetAttr(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fattr.IsDir() {
+		return nil, &os.PathError{Op: "watch", Path: path, Err: syscall.ENOTDIR}
+	}
+
+	entries, err := v.watchList(fh)
+	if err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	w := &Watcher{
+		v:        v,
+		fh:       fh,
+		path:     path,
+		interval: interval,
+		events:   make(chan WatchEvent, watchBacklog),
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	go w.run(fattr, entries)
+
+	return w, nil
+}
+
+// Events returns the channel changes are sent on.  It is closed once the
+// watcher is closed or polling failed.
+func (w *Watcher) Events() <-chan WatchEvent {
+	return w.events
+}
+
+// Err returns the error polling failed with, once the Events channel is closed
+func (w *Watcher) Err() error {
+	select {
+	case <-w.exited:
+		return w.err
+	default:
+		return nil
+	}
+}
+
+// Close stops polling and waits for the watcher to exit
+func (w *Watcher) Close() error {
+	w.close.Do(func() { close(w.done) })
+	<-w.exited
+
+	return nil
+}
+
+func (w *Watcher) run(dir *Fattr, entries map[string]*EntryPlus) {
+	// the error is published before closing events, for Err to return it
+	// once Events is drained
+	defer close(w.events)
+	defer close(w.exited)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		fattr, err := w.v.GetAttrFh(w.fh)
+		if err != nil {
+			w.err = err
+			return
+		}
+
+		if fattr.Mtime == dir.Mtime && fattr.Ctime == dir.Ctime {
+			continue
+		}
+
+		util.Debugf("watch(%s): directory changed, listing", w.path)
+
+		latest, err := w.v.watchList(w.fh)
+		if err != nil {
+			w.err = err
+			return
+		}
+
+		for _, ev := range w.diff(entries, latest) {
+			select {
+			case w.events <- ev:
+			case <-w.done:
+				return
+			}
+		}
+
+		dir, entries = fattr, latest
+	}
+}
+
+// diff returns the events turning the listing before into after, sorted by name
+func (w *Watcher) diff(before, after map[string]*EntryPlus) []WatchEvent {
+	var events []WatchEvent
+
+	for name, e := range before {
+		if a, ok := after[name]; !ok || a.FileId != e.FileId {
+			events = append(events, w.event(WatchRemove, e))
+		}
+	}
+
+	for name, e := range after {
+		b, ok := before[name]
+		switch {
+		case !ok || b.FileId != e.FileId:
+			events = append(events, w.event(WatchCreate, e))
+		case modified(b, e):
+			events = append(events, w.event(WatchModify, e))
+		}
+	}
+
+	// report a replaced entry as removed before it is created again
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Name != events[j].Name {
+			return events[i].Name < events[j].Name
+		}
+		return events[i].Op == WatchRemove
+	})
+
+	return events
+}
+
+func (w *Watcher) event(op WatchOp, e *EntryPlus) WatchEvent {
+	ev := WatchEvent{
+		Op:   op,
+		Name: _path.Join(w.path, e.FileName),
+	}
+	if e.Attr.IsSet {
+		ev.Attr = &e.Attr.Attr
+	}
+
+	return ev
+}
+
+// modified tells whether the attributes of an entry listed twice changed
+func modified(before, after *EntryPlus) bool {
+	if !before.Attr.IsSet || !after.Attr.IsSet {
+		return false
+	}
+
+	b, a := &before.Attr.Attr, &after.Attr.Attr
+	return b.Filesize != a.Filesize || b.Mtime != a.Mtime || b.Ctime != a.Ctime
+}
+
+// watchList lists the directory with the handle fh by name
+func (v *Target) watchList(fh []byte) (map[string]*EntryPlus, error) {
+	entries := map[string]*EntryPlus{}
+
+	it := v.ReadDirPlusIterByFh(fh)
+	for it.Next() {
+		e := it.Entry()
+		if e.FileName == "." || e.FileName == ".." {
+			continue
+		}
+
+		entries[e.FileName] = e
+	}
+
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,49 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+package nfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWatchDiff(t *testing.T) {
+	entry := func(name string, id, size uint64) *EntryPlus {
+		e := &EntryPlus{FileId: id, FileName: name}
+		e.Attr.IsSet = true
+		e.Attr.Attr.Filesize = size
+		return e
+	}
+
+	before := map[string]*EntryPlus{
+		"kept":     entry("kept", 1, 10),
+		"grown":    entry("grown", 2, 10),
+		"removed":  entry("removed", 3, 10),
+		"replaced": entry("replaced", 4, 10),
+	}
+	after := map[string]*EntryPlus{
+		"kept":     entry("kept", 1, 10),
+		"grown":    entry("grown", 2, 20),
+		"replaced": entry("replaced", 5, 10),
+		"new":      entry("new", 6, 0),
+	}
+
+	w := &Watcher{path: "dir"}
+
+	var got []string
+	for _, ev := range w.diff(before, after) {
+		got = append(got, ev.Op.String()+" "+ev.Name)
+	}
+
+	expected := []string{
+		"modify dir/grown",
+		"create dir/new",
+		"remove dir/removed",
+		"remove dir/replaced",
+		"create dir/replaced",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
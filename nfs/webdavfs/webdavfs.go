@@ -0,0 +1,154 @@
+// Copyright © 2017 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: BSD-2-Clause
+//
+// Package webdavfs implements webdav.FileSystem over an NFS target, so that a
+// process can serve an export to WebDAV clients without mounting it.
+//
+//	http.Handle("/", &webdav.Handler{
+//		FileSystem: webdavfs.New(v),
+//		LockSystem: webdav.NewMemLS(),
+//	})
+package webdavfs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	_path "path"
+	"strings"
+	"syscall"
+
+	"github.com/go-nfs/nfsv3/nfs"
+	"golang.org/x/net/webdav"
+)
+
+// FileSystem is a webdav.FileSystem storing its files on a Target.  The
+// contexts passed in are not used, the RPCs are bounded by the timeout of the
+// target's client.
+type FileSystem struct {
+	v *nfs.Target
+}
+
+var (
+	_ webdav.FileSystem = (*FileSystem)(nil)
+	_ webdav.File       = (*file)(nil)
+	_ webdav.File       = (*dir)(nil)
+)
+
+// New returns a file system serving the export of v
+func New(v *nfs.Target) *FileSystem {
+	return &FileSystem{v: v}
+}
+
+// clean turns a slash separated WebDAV name into a path relative to the root
+// of the target
+func clean(name string) string {
+	name = strings.TrimPrefix(_path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+func (wfs *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	_, err := wfs.v.Mkdir(clean(name), perm)
+	return err
+}
+
+// OpenFile opens the file with the given os.O_* flags like os.OpenFile.
+// Directories can only be opened for reading, to list them.
+func (wfs *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	path := clean(name)
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) == 0 {
+		f, err := nfs.FS(wfs.v).Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if d, ok := f.(fs.ReadDirFile); ok {
+			return &dir{ReadDirFile: d, path: path}, nil
+		}
+
+		return &file{File: f.(*nfs.File), path: path}, nil
+	}
+
+	f, err := wfs.v.OpenFile(path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: f, path: path}, nil
+}
+
+// RemoveAll removes the file or tree at name.  The root cannot be removed.
+func (wfs *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	path := clean(name)
+	if path == "." {
+		return &os.PathError{Op: "removeall", Path: name, Err: os.ErrInvalid}
+	}
+
+	return wfs.v.RemoveAll(path)
+}
+
+// Rename moves the file or tree at oldName to newName.  The root cannot be
+// moved.
+func (wfs *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	from, to := clean(oldName), clean(newName)
+	if from == "." || to == "." {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrInvalid}
+	}
+
+	return wfs.v.Rename(from, to)
+}
+
+func (wfs *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return wfs.v.Stat(clean(name))
+}
+
+// file is a regular file, which cannot be listed
+type file struct {
+	*nfs.File
+	path string
+}
+
+func (f *file) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.path, Err: syscall.ENOTDIR}
+}
+
+// dir is a directory opened for listing
+type dir struct {
+	fs.ReadDirFile
+	path string
+}
+
+// Readdir returns the attributes of the next count entries, or of all remaining
+// ones for count <= 0, like os.File.Readdir
+func (d *dir) Readdir(count int) ([]fs.FileInfo, error) {
+	entries, err := d.ReadDir(count)
+
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, ierr := e.Info()
+		if ierr != nil {
+			return infos, ierr
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, err
+}
+
+func (d *dir) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekStart {
+		return 0, nil
+	}
+
+	return 0, &os.PathError{Op: "seek", Path: d.path, Err: syscall.EISDIR}
+}
+
+func (d *dir) Write([]byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.path, Err: syscall.EISDIR}
+}
@@ -4,13 +4,53 @@
 package xdr
 
 import (
+	"errors"
+	"fmt"
 	"io"
 
 	xdr "github.com/rasky/go-xdr/xdr2"
 )
 
+// MaxLength is the longest opaque, string or array read from a reader which
+// does not tell how much data it holds.  The lengths read from the others,
+// like the bytes.Reader of a reply, are bounded by what is left to read, so
+// that a server cannot make a client allocate more memory than its reply
+// takes.
+const MaxLength = 4 << 20
+
+// TooLargeError is the error of reading an opaque, string or array whose
+// length is larger than the data left to read, or than MaxLength
+type TooLargeError struct {
+	Length, Max uint32
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("xdr: length %d larger than %d", e.Length, e.Max)
+}
+
+// maxLength returns the longest length r can hold
+func maxLength(r io.Reader) uint32 {
+	l, ok := r.(interface{ Len() int })
+	if !ok || l.Len() > MaxLength {
+		return MaxLength
+	}
+
+	// a length of 0 would leave the reads unbounded, one byte still gets
+	// the shorter reads to fail
+	return uint32(max(l.Len(), 1))
+}
+
 func Read(r io.Reader, val interface{}) error {
-	_, err := xdr.Unmarshal(r, val)
+	limit := maxLength(r)
+	_, err := xdr.UnmarshalLimited(r, val, uint(limit))
+
+	var uerr *xdr.UnmarshalError
+	if errors.As(err, &uerr) && uerr.ErrorCode == xdr.ErrOverflow {
+		if n, ok := uerr.Value.(uint32); ok && n > limit {
+			return &TooLargeError{Length: n, Max: limit}
+		}
+	}
+
 	return err
 }
 
@@ -37,6 +77,10 @@ func ReadOpaque(r io.Reader) ([]byte, error) {
 		return nil, err
 	}
 
+	if limit := maxLength(r); length > limit {
+		return nil, &TooLargeError{Length: length, Max: limit}
+	}
+
 	buf := make([]byte, length)
 	if _, err = r.Read(buf); err != nil {
 		return nil, err
@@ -51,6 +95,11 @@ func ReadUint32List(r io.Reader) ([]uint32, error) {
 		return nil, err
 	}
 
+	// 4 bytes each
+	if limit := maxLength(r); length > limit/4 {
+		return nil, &TooLargeError{Length: length, Max: limit / 4}
+	}
+
 	buf := make([]uint32, length)
 
 	for i := 0; i < int(length); i++ {
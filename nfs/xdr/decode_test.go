@@ -66,3 +66,30 @@ func TestByteSlice(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestTooLarge(t *testing.T) {
+	// a length far beyond the 4 bytes following it
+	b := []byte{0x7f, 0xff, 0xff, 0xff, 0, 1, 2, 3}
+
+	var out []byte
+	err := Read(bytes.NewReader(b), &out)
+	if e, ok := err.(*TooLargeError); !ok || e.Length != 0x7fffffff || e.Max != 8 {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if _, err = ReadOpaque(bytes.NewReader(b)); err == nil {
+		t.Fatal("ReadOpaque of a length beyond the data")
+	}
+
+	if _, err = ReadUint32List(bytes.NewReader(b)); err == nil {
+		t.Fatal("ReadUint32List of a length beyond the data")
+	}
+
+	type S struct {
+		Name string
+	}
+	s := &S{}
+	if err = Read(bytes.NewReader([]byte{0, 0, 0, 3, 'a', 'b', 'c', 0}), s); err != nil || s.Name != "abc" {
+		t.Fatalf("Read: %v %q", err, s.Name)
+	}
+}